@@ -15,8 +15,12 @@
 package pyth
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"sync"
 
 	bin "github.com/gagliardetto/binary"
 	"github.com/gagliardetto/solana-go"
@@ -138,6 +142,12 @@ type Ema struct {
 	Denom int64
 }
 
+func (e *Ema) decode(buf []byte) {
+	e.Val = int64(binary.LittleEndian.Uint64(buf[0:]))
+	e.Numer = int64(binary.LittleEndian.Uint64(buf[8:]))
+	e.Denom = int64(binary.LittleEndian.Uint64(buf[16:]))
+}
+
 // PriceInfo contains a price and confidence at a specific slot.
 //
 // This struct can represent either a publisher's contribution or the outcome of price aggregation.
@@ -149,6 +159,14 @@ type PriceInfo struct {
 	PubSlot uint64 // valid publishing slot
 }
 
+func (p *PriceInfo) decode(buf []byte) {
+	p.Price = int64(binary.LittleEndian.Uint64(buf[0:]))
+	p.Conf = binary.LittleEndian.Uint64(buf[8:])
+	p.Status = binary.LittleEndian.Uint32(buf[16:])
+	p.CorpAct = binary.LittleEndian.Uint32(buf[20:])
+	p.PubSlot = binary.LittleEndian.Uint64(buf[24:])
+}
+
 func (p *PriceInfo) IsZero() bool {
 	return p == nil || *p == PriceInfo{}
 }
@@ -183,6 +201,9 @@ type PriceComp struct {
 	Latest    PriceInfo        // latest price of publisher
 }
 
+// PriceAccountLen is the size of a price account in the on-chain format.
+const PriceAccountLen = 3312
+
 // PriceAccount represents a continuously-updating price feed for a product.
 type PriceAccount struct {
 	AccountHeader
@@ -207,19 +228,80 @@ type PriceAccount struct {
 
 // UnmarshalBinary decodes the price account from the on-chain format.
 func (p *PriceAccount) UnmarshalBinary(buf []byte) error {
-	decoder := bin.NewBinDecoder(buf)
-	if err := decoder.Decode(p); err != nil {
-		return err
+	// Decoded by hand instead of via reflection as this is on the hot path of price streams.
+	if len(buf) < PriceAccountLen {
+		return fmt.Errorf("price account too short (%d < %d)", len(buf), PriceAccountLen)
 	}
+	le := binary.LittleEndian
+	p.Magic = le.Uint32(buf[0:])
+	p.Version = le.Uint32(buf[4:])
+	p.AccountType = le.Uint32(buf[8:])
+	p.Size = le.Uint32(buf[12:])
 	if !p.AccountHeader.Valid() {
 		return errors.New("invalid account")
 	}
 	if p.AccountType != AccountTypePrice {
 		return errors.New("not a price account")
 	}
+	p.PriceType = le.Uint32(buf[16:])
+	p.Exponent = int32(le.Uint32(buf[20:]))
+	p.Num = le.Uint32(buf[24:])
+	p.NumQt = le.Uint32(buf[28:])
+	p.LastSlot = le.Uint64(buf[32:])
+	p.ValidSlot = le.Uint64(buf[40:])
+	p.Twap.decode(buf[48:])
+	p.Twac.decode(buf[72:])
+	p.Drv1 = int64(le.Uint64(buf[96:]))
+	p.Drv2 = int64(le.Uint64(buf[104:]))
+	copy(p.Product[:], buf[112:144])
+	copy(p.Next[:], buf[144:176])
+	p.PrevSlot = le.Uint64(buf[176:])
+	p.PrevPrice = int64(le.Uint64(buf[184:]))
+	p.PrevConf = le.Uint64(buf[192:])
+	p.Drv3 = int64(le.Uint64(buf[200:]))
+	p.Agg.decode(buf[208:])
+	for i := range p.Components {
+		comp := &p.Components[i]
+		off := 240 + i*96
+		copy(comp.Publisher[:], buf[off:off+32])
+		comp.Agg.decode(buf[off+32:])
+		comp.Latest.decode(buf[off+64:])
+	}
 	return nil
 }
 
+// MarshalBinary encodes the price account to the on-chain format.
+func (p *PriceAccount) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(PriceAccountLen)
+	if err := bin.NewBinEncoder(&buf).Encode(p); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var priceAccountPool = sync.Pool{
+	New: func() interface{} {
+		return new(PriceAccount)
+	},
+}
+
+// AcquirePriceAccount returns a price account from a shared pool.
+//
+// Its contents are undefined until overwritten, e.g. with UnmarshalBinary.
+func AcquirePriceAccount() *PriceAccount {
+	return priceAccountPool.Get().(*PriceAccount)
+}
+
+// ReleasePriceAccount returns a price account to the shared pool.
+//
+// The price account must not be used after calling this function.
+func ReleasePriceAccount(p *PriceAccount) {
+	if p != nil {
+		priceAccountPool.Put(p)
+	}
+}
+
 // GetComponent returns the first price component with the given publisher key. Might return nil.
 func (p *PriceAccount) GetComponent(publisher *solana.PublicKey) *PriceComp {
 	for i := range p.Components {
@@ -230,6 +312,13 @@ func (p *PriceAccount) GetComponent(publisher *solana.PublicKey) *PriceComp {
 	return nil
 }
 
+// MinPub returns the minimum number of publishers required for a valid aggregate price.
+//
+// It is stored in the lowest byte of Drv2, as set by Instruction_SetMinPub.
+func (p *PriceAccount) MinPub() uint8 {
+	return uint8(p.Drv2)
+}
+
 // MappingAccount is a piece of a singly linked-list of all products on Pyth.
 type MappingAccount struct {
 	AccountHeader
@@ -274,6 +363,7 @@ type PriceAccountEntry struct {
 	*PriceAccount
 	Pubkey solana.PublicKey `json:"pubkey"`
 	Slot   uint64           `json:"slot"`
+	Raw    []byte           `json:"-"` // raw account data as received, if known
 }
 
 // MappingAccountEntry is a versioned mapping account and its pubkey.
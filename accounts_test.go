@@ -19,6 +19,7 @@ import (
 	"encoding/json"
 	"testing"
 
+	bin "github.com/gagliardetto/binary"
 	"github.com/gagliardetto/solana-go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -308,3 +309,57 @@ func TestMappingAccount(t *testing.T) {
 
 	assert.Equal(t, &expected, &actual)
 }
+
+func TestPriceAccount_UnmarshalBinary_Invalid(t *testing.T) {
+	var acc PriceAccount
+	assert.EqualError(t, acc.UnmarshalBinary(casePriceAccount[:100]), "price account too short (100 < 3312)")
+	assert.EqualError(t, acc.UnmarshalBinary(caseProductAccount[:PriceAccountLen]), "not a price account")
+	assert.EqualError(t, acc.UnmarshalBinary(make([]byte, PriceAccountLen)), "invalid account")
+}
+
+func TestPriceAccount_UnmarshalBinary_Reflect(t *testing.T) {
+	// The hand-written decoder must match the reflection-based one.
+	var expected, actual PriceAccount
+	require.NoError(t, bin.NewBinDecoder(casePriceAccount).Decode(&expected))
+	require.NoError(t, actual.UnmarshalBinary(casePriceAccount))
+	assert.Equal(t, expected, actual)
+}
+
+// benchPriceAccount prevents the compiler from optimizing away benchmarked allocations.
+var benchPriceAccount *PriceAccount
+
+func BenchmarkPriceAccount_UnmarshalBinary(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(PriceAccountLen)
+	for i := 0; i < b.N; i++ {
+		acc := new(PriceAccount)
+		if err := acc.UnmarshalBinary(casePriceAccount); err != nil {
+			b.Fatal(err)
+		}
+		benchPriceAccount = acc
+	}
+}
+
+func BenchmarkPriceAccount_UnmarshalBinary_Pooled(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(PriceAccountLen)
+	for i := 0; i < b.N; i++ {
+		acc := AcquirePriceAccount()
+		if err := acc.UnmarshalBinary(casePriceAccount); err != nil {
+			b.Fatal(err)
+		}
+		ReleasePriceAccount(acc)
+	}
+}
+
+func BenchmarkPriceAccount_UnmarshalBinary_Reflect(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(PriceAccountLen)
+	for i := 0; i < b.N; i++ {
+		acc := new(PriceAccount)
+		if err := bin.NewBinDecoder(casePriceAccount).Decode(acc); err != nil {
+			b.Fatal(err)
+		}
+		benchPriceAccount = acc
+	}
+}
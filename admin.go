@@ -0,0 +1,348 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/programs/system"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// ProductAccountLen is the size of a product account in the on-chain format.
+const ProductAccountLen = 512
+
+// MappingAccountLen is the size of a mapping account in the on-chain format.
+const MappingAccountLen = 20536
+
+// MappingAccountCapacity is the number of products a single mapping account holds.
+const MappingAccountCapacity = 640
+
+// PriceTypePrice is the price type of regular price accounts.
+const PriceTypePrice = uint32(1)
+
+// PreparedTransaction is an unsigned transaction prepared by an admin workflow.
+//
+// It carries the signers of the accounts it creates.
+// The remaining signatures, such as of the funding and mapping accounts, are added with Sign.
+type PreparedTransaction struct {
+	Transaction *solana.Transaction
+	signers     []Signer
+	// lastValidBlockHeight is the last block height at which the transaction can land.
+	lastValidBlockHeight uint64
+}
+
+// RequiredSigners returns the accounts that must sign the transaction.
+func (p *PreparedTransaction) RequiredSigners() []solana.PublicKey {
+	return p.Transaction.Message.Signers()
+}
+
+// Sign signs the transaction with the given signers and the signers of the created accounts.
+func (p *PreparedTransaction) Sign(ctx context.Context, signers ...Signer) error {
+	return SignTransaction(ctx, p.Transaction, append(signers, p.signers...)...)
+}
+
+// CreateProductParams describes a new product.
+type CreateProductParams struct {
+	// Attrs are the reference data of the product, such as "symbol".
+	Attrs map[string]string
+	// Product is the new product account. A new keypair is generated if nil.
+	Product Signer
+	// Prices are the price accounts of the product.
+	Prices []CreatePriceParams
+	// NewMapping is used if a new mapping account is required. A new keypair is generated if nil.
+	NewMapping Signer
+}
+
+// CreatePriceParams describes a new price account.
+type CreatePriceParams struct {
+	Exponent int32
+	// PriceType defaults to PriceTypePrice.
+	PriceType uint32
+	// Price is the new price account. A new keypair is generated if nil.
+	Price Signer
+	// Publishers are permitted to publish to the price account.
+	Publishers []solana.PublicKey
+	// MinPub is the minimum number of publishers for a valid aggregate price. Left unset if zero.
+	MinPub uint8
+}
+
+// CreateProductPlan is the outcome of PrepareCreateProduct.
+type CreateProductPlan struct {
+	ProductKey solana.PublicKey
+	PriceKeys  []solana.PublicKey // in order of CreateProductParams.Prices
+	MappingKey solana.PublicKey   // mapping account the product is added to
+	NewMapping bool               // whether MappingKey is created by the plan
+
+	// Transactions must be signed and confirmed in order.
+	// Each is signed by the funding account and the signers of the mapping accounts used,
+	// in addition to the accounts it creates.
+	Transactions []*PreparedTransaction
+}
+
+// PrepareCreateProduct builds the transactions creating a product with its price accounts and publishers.
+//
+// The product is added to the last account of the mapping list starting at mappingKey.
+// If that account is full (MappingAccountCapacity), a new mapping account is created and appended first.
+// New accounts are funded by the funding account with the rent-exempt minimum of their size.
+//
+// Instructions are packed into as few transactions as possible,
+// creating a product with a single price in one transaction in most cases.
+// Creating an account and initializing it always happen in the same transaction,
+// so that a failed transaction never leaves a funded but uninitialized account behind.
+func (c *Client) PrepareCreateProduct(
+	ctx context.Context,
+	funding solana.PublicKey,
+	mappingKey solana.PublicKey,
+	params *CreateProductParams,
+) (*CreateProductPlan, error) {
+	if _, err := newProductAttrs(params.Attrs); err != nil {
+		return nil, err
+	}
+	tail, err := c.findMappingTail(ctx, mappingKey)
+	if err != nil {
+		return nil, err
+	}
+	batch := c.newAdminBatch(funding)
+	plan, err := batch.createProduct(ctx, &mappingTail{key: tail.Pubkey, num: tail.Num}, params)
+	if err != nil {
+		return nil, err
+	}
+	plan.Transactions, err = batch.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// adminBatch accumulates the instructions of an admin workflow,
+// along with the signers of the accounts they create.
+//
+// Instructions are added in groups which are never split across transactions.
+type adminBatch struct {
+	client  *Client
+	funding solana.PublicKey
+	builder *InstructionBuilder
+	rent    *rentCache
+	groups  [][]solana.Instruction
+	signers []Signer
+}
+
+// mappingTail tracks the last mapping account while products are added to it.
+type mappingTail struct {
+	key solana.PublicKey
+	num uint32
+}
+
+func (c *Client) newAdminBatch(funding solana.PublicKey) *adminBatch {
+	return &adminBatch{
+		client:  c,
+		funding: funding,
+		builder: NewInstructionBuilder(c.Env.Program),
+		rent:    newRentCache(c),
+	}
+}
+
+// add appends a group of instructions that must be executed in the same transaction.
+func (b *adminBatch) add(instructions ...solana.Instruction) {
+	b.groups = append(b.groups, instructions)
+}
+
+// createAccount returns the instruction funding a new account owned by the Pyth program.
+//
+// It must be added in the same group as the instructions initializing the account.
+func (b *adminBatch) createAccount(ctx context.Context, account Signer, size uint64) (solana.Instruction, error) {
+	lamports, err := b.rent.get(ctx, size)
+	if err != nil {
+		return nil, err
+	}
+	b.signers = append(b.signers, account)
+	return system.NewCreateAccountInstruction(
+		lamports, size, b.client.Env.Program, b.funding, account.PublicKey()).Build(), nil
+}
+
+// createProduct adds the instructions creating a product and its prices to the batch.
+//
+// The tail is advanced past the new product, so that further products can be added to the same batch.
+func (b *adminBatch) createProduct(ctx context.Context, tail *mappingTail, params *CreateProductParams) (*CreateProductPlan, error) {
+	attrs, err := newProductAttrs(params.Attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &CreateProductPlan{MappingKey: tail.key}
+	if tail.num >= MappingAccountCapacity {
+		newMapping := params.NewMapping
+		if newMapping == nil {
+			newMapping = NewKeypairSigner(solana.NewWallet().PrivateKey)
+		}
+		create, err := b.createAccount(ctx, newMapping, MappingAccountLen)
+		if err != nil {
+			return nil, err
+		}
+		b.add(create, b.builder.AddMapping(b.funding, tail.key, newMapping.PublicKey()))
+		*tail = mappingTail{key: newMapping.PublicKey()}
+		plan.MappingKey = tail.key
+		plan.NewMapping = true
+	}
+
+	product := params.Product
+	if product == nil {
+		product = NewKeypairSigner(solana.NewWallet().PrivateKey)
+	}
+	plan.ProductKey = product.PublicKey()
+	create, err := b.createAccount(ctx, product, ProductAccountLen)
+	if err != nil {
+		return nil, err
+	}
+	b.add(
+		create,
+		b.builder.AddProduct(b.funding, plan.MappingKey, plan.ProductKey),
+		b.builder.UpdProduct(b.funding, plan.ProductKey, CommandUpdProduct{AttrsMap: attrs}))
+	tail.num++
+
+	for _, priceParams := range params.Prices {
+		priceKey, err := b.createPrice(ctx, plan.ProductKey, &priceParams)
+		if err != nil {
+			return nil, err
+		}
+		plan.PriceKeys = append(plan.PriceKeys, priceKey)
+	}
+	return plan, nil
+}
+
+// createPrice adds the instructions creating a price account of an existing product to the batch.
+func (b *adminBatch) createPrice(ctx context.Context, productKey solana.PublicKey, params *CreatePriceParams) (solana.PublicKey, error) {
+	price := params.Price
+	if price == nil {
+		price = NewKeypairSigner(solana.NewWallet().PrivateKey)
+	}
+	priceType := params.PriceType
+	if priceType == 0 {
+		priceType = PriceTypePrice
+	}
+	priceKey := price.PublicKey()
+	create, err := b.createAccount(ctx, price, PriceAccountLen)
+	if err != nil {
+		return solana.PublicKey{}, err
+	}
+	b.add(create, b.builder.AddPrice(b.funding, productKey, priceKey, CommandAddPrice{
+		Exponent:  params.Exponent,
+		PriceType: priceType,
+	}))
+	for _, publisher := range params.Publishers {
+		b.add(b.builder.AddPublisher(b.funding, priceKey, CommandAddPublisher{
+			Publisher: publisher,
+		}))
+	}
+	if params.MinPub != 0 {
+		b.add(b.builder.SetMinPub(b.funding, priceKey, CommandSetMinPub{MinPub: params.MinPub}))
+	}
+	return priceKey, nil
+}
+
+// prepare packs the instructions of the batch into transactions.
+func (b *adminBatch) prepare(ctx context.Context) ([]*PreparedTransaction, error) {
+	return b.client.prepareTransactions(ctx, b.funding, b.groups, b.signers)
+}
+
+// newProductAttrs converts product attrs, checking that they fit into a product account.
+func newProductAttrs(kvs map[string]string) (AttrsMap, error) {
+	attrs, err := NewAttrsMap(kvs)
+	if err != nil {
+		return attrs, fmt.Errorf("invalid attrs: %w", err)
+	}
+	if size := attrs.BinaryLen(); size > ProductAccountLen-ProductAccountHeaderLen {
+		return attrs, fmt.Errorf("attrs too large (%d > %d bytes)", size, ProductAccountLen-ProductAccountHeaderLen)
+	}
+	return attrs, nil
+}
+
+// findMappingTail returns the last account of the mapping list.
+func (c *Client) findMappingTail(ctx context.Context, mappingKey solana.PublicKey) (MappingAccountEntry, error) {
+	seen := make(map[solana.PublicKey]struct{})
+	for {
+		mapping, err := c.GetMappingAccount(ctx, mappingKey, rpc.CommitmentConfirmed)
+		if err != nil {
+			return MappingAccountEntry{}, fmt.Errorf("failed to get mapping account %s: %w", mappingKey, err)
+		}
+		seen[mappingKey] = struct{}{}
+		if mapping.Next.IsZero() {
+			return mapping, nil
+		}
+		if _, loop := seen[mapping.Next]; loop {
+			return MappingAccountEntry{}, fmt.Errorf("mapping list loops at %s", mapping.Next)
+		}
+		mappingKey = mapping.Next
+	}
+}
+
+// prepareTransactions packs groups of instructions into unsigned transactions with a recent blockhash.
+//
+// Groups are not split across transactions. Each transaction carries the given signers it requires.
+func (c *Client) prepareTransactions(
+	ctx context.Context,
+	funding solana.PublicKey,
+	groups [][]solana.Instruction,
+	signers []Signer,
+) ([]*PreparedTransaction, error) {
+	packed, err := PackInstructionGroups(funding, groups, nil)
+	if err != nil {
+		return nil, err
+	}
+	blockhash, err := c.RPC.GetLatestBlockhash(ctx, rpc.CommitmentConfirmed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blockhash: %w", err)
+	}
+	txs := make([]*PreparedTransaction, 0, len(packed))
+	for _, group := range packed {
+		tx, err := solana.NewTransaction(group, blockhash.Value.Blockhash, solana.TransactionPayer(funding))
+		if err != nil {
+			return nil, fmt.Errorf("failed to build transaction: %w", err)
+		}
+		prepared := &PreparedTransaction{Transaction: tx, lastValidBlockHeight: blockhash.Value.LastValidBlockHeight}
+		for _, key := range tx.Message.Signers() {
+			if signer := findSigner(signers, key); signer != nil {
+				prepared.signers = append(prepared.signers, signer)
+			}
+		}
+		txs = append(txs, prepared)
+	}
+	return txs, nil
+}
+
+// rentCache memoizes rent-exempt minimums by account size.
+type rentCache struct {
+	client   *Client
+	balances map[uint64]uint64
+}
+
+func newRentCache(client *Client) *rentCache {
+	return &rentCache{client: client, balances: make(map[uint64]uint64)}
+}
+
+func (r *rentCache) get(ctx context.Context, size uint64) (uint64, error) {
+	if lamports, ok := r.balances[size]; ok {
+		return lamports, nil
+	}
+	lamports, err := r.client.RPC.GetMinimumBalanceForRentExemption(ctx, size, rpc.CommitmentConfirmed)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rent for %d bytes: %w", size, err)
+	}
+	r.balances[size] = lamports
+	return lamports, nil
+}
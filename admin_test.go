@@ -0,0 +1,265 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	bin "github.com/gagliardetto/binary"
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/programs/system"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// handleAccounts serves getAccountInfo from the given account data.
+func (f *fakeRPC) handleAccounts(t *testing.T, accounts map[solana.PublicKey][]byte) {
+	f.handle("getAccountInfo", func(params json.RawMessage) interface{} {
+		var args []json.RawMessage
+		require.NoError(t, json.Unmarshal(params, &args))
+		var key solana.PublicKey
+		require.NoError(t, json.Unmarshal(args[0], &key))
+		data, ok := accounts[key]
+		require.True(t, ok, "unexpected account %s", key)
+		return map[string]interface{}{
+			"context": map[string]interface{}{"slot": 100},
+			"value": map[string]interface{}{
+				"data":       []string{base64.StdEncoding.EncodeToString(data), "base64"},
+				"executable": false,
+				"lamports":   1,
+				"owner":      Devnet.Program.String(),
+				"rentEpoch":  0,
+			},
+		}
+	})
+}
+
+// handleRent charges 10 lamports per byte.
+func (f *fakeRPC) handleRent(t *testing.T) {
+	f.handle("getMinimumBalanceForRentExemption", func(params json.RawMessage) interface{} {
+		var args []json.RawMessage
+		require.NoError(t, json.Unmarshal(params, &args))
+		var size uint64
+		require.NoError(t, json.Unmarshal(args[0], &size))
+		return size * 10
+	})
+}
+
+func testMappingAccount(t *testing.T, num uint32, next solana.PublicKey) []byte {
+	mapping := MappingAccount{
+		AccountHeader: AccountHeader{
+			Magic:       Magic,
+			Version:     V2,
+			AccountType: AccountTypeMapping,
+			Size:        MappingAccountLen,
+		},
+		Num:  num,
+		Next: next,
+	}
+	var buf bytes.Buffer
+	require.NoError(t, bin.NewBinEncoder(&buf).Encode(&mapping))
+	require.Len(t, buf.Bytes(), MappingAccountLen)
+	return buf.Bytes()
+}
+
+// decodeAdminInstructions returns the Pyth commands and system program instructions of the transactions.
+func decodeAdminInstructions(t *testing.T, txs []*PreparedTransaction) (commands []int32, creates []*system.CreateAccount) {
+	for _, prepared := range txs {
+		tx := prepared.Transaction
+		for _, compiled := range tx.Message.Instructions {
+			program, err := tx.Message.Program(compiled.ProgramIDIndex)
+			require.NoError(t, err)
+			accounts, err := compiled.ResolveInstructionAccounts(&tx.Message)
+			require.NoError(t, err)
+			switch program {
+			case Devnet.Program:
+				ins, err := DecodeInstruction(program, accounts, compiled.Data)
+				require.NoError(t, err)
+				commands = append(commands, ins.Header.Cmd)
+			case solana.SystemProgramID:
+				ins, err := system.DecodeInstruction(accounts, compiled.Data)
+				require.NoError(t, err)
+				create, ok := ins.Impl.(*system.CreateAccount)
+				require.True(t, ok)
+				creates = append(creates, create)
+			default:
+				t.Fatalf("unexpected program %s", program)
+			}
+		}
+	}
+	return
+}
+
+func TestClient_PrepareCreateProduct(t *testing.T) {
+	funding := NewKeypairSigner(solana.NewWallet().PrivateKey)
+	head := NewKeypairSigner(solana.NewWallet().PrivateKey)
+	tail := NewKeypairSigner(solana.NewWallet().PrivateKey)
+
+	rpcServer := newFakeRPC(t)
+	rpcServer.handleBlockhash()
+	rpcServer.handleRent(t)
+	rpcServer.handleAccounts(t, map[solana.PublicKey][]byte{
+		head.PublicKey(): testMappingAccount(t, MappingAccountCapacity, tail.PublicKey()),
+		tail.PublicKey(): testMappingAccount(t, 3, solana.PublicKey{}),
+	})
+	client := NewClient(Devnet, rpcServer.URL, rpcServer.URL)
+
+	publishers := []solana.PublicKey{{1}, {2}}
+	plan, err := client.PrepareCreateProduct(context.Background(), funding.PublicKey(), head.PublicKey(), &CreateProductParams{
+		Attrs:  map[string]string{"symbol": "Crypto.BTC/USD", "asset_type": "Crypto"},
+		Prices: []CreatePriceParams{{Exponent: -8, Publishers: publishers}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, tail.PublicKey(), plan.MappingKey)
+	assert.False(t, plan.NewMapping)
+	require.Len(t, plan.PriceKeys, 1)
+
+	// A product with a single price is created atomically.
+	require.Len(t, plan.Transactions, 1)
+	commands, creates := decodeAdminInstructions(t, plan.Transactions)
+	assert.Equal(t, []int32{
+		Instruction_AddProduct,
+		Instruction_UpdProduct,
+		Instruction_AddPrice,
+		Instruction_AddPublisher,
+		Instruction_AddPublisher,
+	}, commands)
+	require.Len(t, creates, 2)
+	assert.Equal(t, uint64(ProductAccountLen), *creates[0].Space)
+	assert.Equal(t, uint64(ProductAccountLen*10), *creates[0].Lamports)
+	assert.Equal(t, Devnet.Program, *creates[0].Owner)
+	assert.Equal(t, plan.ProductKey, creates[0].GetNewAccount().PublicKey)
+	assert.Equal(t, uint64(PriceAccountLen), *creates[1].Space)
+	assert.Equal(t, plan.PriceKeys[0], creates[1].GetNewAccount().PublicKey)
+
+	tx := plan.Transactions[0]
+	assert.ElementsMatch(t, []solana.PublicKey{
+		funding.PublicKey(), tail.PublicKey(), plan.ProductKey, plan.PriceKeys[0],
+	}, tx.RequiredSigners())
+	assert.EqualError(t, tx.Sign(context.Background(), funding), "missing signer for "+tail.PublicKey().String())
+	require.NoError(t, tx.Sign(context.Background(), funding, tail))
+	assert.NoError(t, tx.Transaction.VerifySignatures())
+}
+
+func TestClient_PrepareCreateProduct_FullMapping(t *testing.T) {
+	funding := NewKeypairSigner(solana.NewWallet().PrivateKey)
+	mapping := NewKeypairSigner(solana.NewWallet().PrivateKey)
+	newMapping := NewKeypairSigner(solana.NewWallet().PrivateKey)
+
+	rpcServer := newFakeRPC(t)
+	rpcServer.handleBlockhash()
+	rpcServer.handleRent(t)
+	rpcServer.handleAccounts(t, map[solana.PublicKey][]byte{
+		mapping.PublicKey(): testMappingAccount(t, MappingAccountCapacity, solana.PublicKey{}),
+	})
+	client := NewClient(Devnet, rpcServer.URL, rpcServer.URL)
+
+	plan, err := client.PrepareCreateProduct(context.Background(), funding.PublicKey(), mapping.PublicKey(), &CreateProductParams{
+		Attrs:      map[string]string{"symbol": "Crypto.ETH/USD"},
+		NewMapping: newMapping,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, newMapping.PublicKey(), plan.MappingKey)
+	assert.True(t, plan.NewMapping)
+
+	commands, creates := decodeAdminInstructions(t, plan.Transactions)
+	assert.Equal(t, []int32{Instruction_AddMapping, Instruction_AddProduct, Instruction_UpdProduct}, commands)
+	require.Len(t, creates, 2)
+	assert.Equal(t, uint64(MappingAccountLen), *creates[0].Space)
+	assert.Equal(t, newMapping.PublicKey(), creates[0].GetNewAccount().PublicKey)
+
+	for _, tx := range plan.Transactions {
+		require.NoError(t, tx.Sign(context.Background(), funding, mapping))
+		assert.NoError(t, tx.Transaction.VerifySignatures())
+	}
+}
+
+func TestClient_PrepareCreateProduct_KeepsCreateWithInit(t *testing.T) {
+	funding := NewKeypairSigner(solana.NewWallet().PrivateKey)
+	mapping := NewKeypairSigner(solana.NewWallet().PrivateKey)
+
+	rpcServer := newFakeRPC(t)
+	rpcServer.handleBlockhash()
+	rpcServer.handleRent(t)
+	rpcServer.handleAccounts(t, map[solana.PublicKey][]byte{
+		mapping.PublicKey(): testMappingAccount(t, 0, solana.PublicKey{}),
+	})
+	client := NewClient(Devnet, rpcServer.URL, rpcServer.URL)
+	ctx := context.Background()
+
+	newParams := func(numPublishers int) *CreateProductParams {
+		params := &CreateProductParams{Attrs: map[string]string{"symbol": "Crypto.BTC/USD"}}
+		for i := 0; i < 6; i++ {
+			price := CreatePriceParams{Exponent: -8}
+			for j := 0; j < numPublishers; j++ {
+				price.Publishers = append(price.Publishers, solana.PublicKey{byte(i), byte(j), 1})
+			}
+			params.Prices = append(params.Prices, price)
+		}
+		return params
+	}
+
+	// Find a product layout where packing the plain instruction list
+	// would separate an account creation from its initialization.
+	var params *CreateProductParams
+	for numPublishers := 0; numPublishers < 16 && params == nil; numPublishers++ {
+		candidate := newParams(numPublishers)
+		batch := client.newAdminBatch(funding.PublicKey())
+		_, err := batch.createProduct(ctx, &mappingTail{key: mapping.PublicKey()}, candidate)
+		require.NoError(t, err)
+		var flat []solana.Instruction
+		for _, group := range batch.groups {
+			flat = append(flat, group...)
+		}
+		packed, err := PackInstructions(funding.PublicKey(), flat, nil)
+		require.NoError(t, err)
+		for _, tx := range packed[:len(packed)-1] {
+			if tx[len(tx)-1].ProgramID() == solana.SystemProgramID {
+				params = candidate
+				break
+			}
+		}
+	}
+	require.NotNil(t, params, "no layout splits a create+init group")
+
+	plan, err := client.PrepareCreateProduct(ctx, funding.PublicKey(), mapping.PublicKey(), params)
+	require.NoError(t, err)
+	require.Greater(t, len(plan.Transactions), 1)
+
+	for i, prepared := range plan.Transactions {
+		commands, creates := decodeAdminInstructions(t, []*PreparedTransaction{prepared})
+		var inits int
+		for _, cmd := range commands {
+			switch cmd {
+			case Instruction_AddMapping, Instruction_AddProduct, Instruction_AddPrice:
+				inits++
+			}
+		}
+		assert.Equal(t, len(creates), inits, "transaction %d", i)
+	}
+}
+
+func TestClient_PrepareCreateProduct_AttrsTooLarge(t *testing.T) {
+	client := NewClient(Devnet, "http://localhost:1", "ws://localhost:1")
+	value := string(bytes.Repeat([]byte{'x'}, 200))
+	_, err := client.PrepareCreateProduct(context.Background(), solana.PublicKey{1}, solana.PublicKey{2}, &CreateProductParams{
+		Attrs: map[string]string{"a": value, "b": value, "c": value},
+	})
+	assert.EqualError(t, err, "attrs too large (609 > 464 bytes)")
+}
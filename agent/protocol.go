@@ -0,0 +1,245 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gagliardetto/solana-go"
+	"go.blockdaemon.com/pyth"
+)
+
+// JSON-RPC methods of the pyth-agent protocol.
+const (
+	MethodGetProductList      = "get_product_list"
+	MethodGetProduct          = "get_product"
+	MethodGetAllProducts      = "get_all_products"
+	MethodUpdatePrice         = "update_price"
+	MethodSubscribePrice      = "subscribe_price"
+	MethodSubscribePriceSched = "subscribe_price_sched"
+
+	MethodNotifyPrice      = "notify_price"
+	MethodNotifyPriceSched = "notify_price_sched"
+)
+
+// JSON-RPC error codes.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+	CodeServerError    = -32000 // failure querying or publishing to Solana
+)
+
+// Error is a JSON-RPC error object.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error returns the message of the error.
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+func errorf(code int, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+type notification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// ProductAccountMetadata is an entry of the get_product_list result.
+type ProductAccountMetadata struct {
+	Account  solana.PublicKey       `json:"account"`
+	AttrDict map[string]string      `json:"attr_dict"`
+	Price    []PriceAccountMetadata `json:"price"`
+}
+
+// PriceAccountMetadata describes a price account of a product in the get_product_list result.
+type PriceAccountMetadata struct {
+	Account       solana.PublicKey `json:"account"`
+	PriceType     string           `json:"price_type"`
+	PriceExponent int32            `json:"price_exponent"`
+}
+
+// ProductAccount is the result of get_product, and an entry of the get_all_products result.
+type ProductAccount struct {
+	Account       solana.PublicKey  `json:"account"`
+	AttrDict      map[string]string `json:"attr_dict"`
+	PriceAccounts []PriceAccount    `json:"price_accounts"`
+}
+
+// PriceAccount is the current state of a price account.
+type PriceAccount struct {
+	Account           solana.PublicKey   `json:"account"`
+	PriceType         string             `json:"price_type"`
+	PriceExponent     int32              `json:"price_exponent"`
+	Status            string             `json:"status"`
+	Price             int64              `json:"price"`
+	Conf              uint64             `json:"conf"`
+	Twap              int64              `json:"twap"`
+	Twac              int64              `json:"twac"`
+	ValidSlot         uint64             `json:"valid_slot"`
+	PubSlot           uint64             `json:"pub_slot"`
+	PrevSlot          uint64             `json:"prev_slot"`
+	PrevPrice         int64              `json:"prev_price"`
+	PrevConf          uint64             `json:"prev_conf"`
+	PublisherAccounts []PublisherAccount `json:"publisher_accounts"`
+}
+
+// PublisherAccount is the latest component price of a publisher.
+type PublisherAccount struct {
+	Account solana.PublicKey `json:"account"`
+	Status  string           `json:"status"`
+	Price   int64            `json:"price"`
+	Conf    uint64           `json:"conf"`
+	Slot    uint64           `json:"slot"`
+}
+
+// PriceUpdate is the result of a notify_price notification.
+type PriceUpdate struct {
+	Price     int64  `json:"price"`
+	Conf      uint64 `json:"conf"`
+	Status    string `json:"status"`
+	ValidSlot uint64 `json:"valid_slot"`
+	PubSlot   uint64 `json:"pub_slot"`
+}
+
+// UpdatePriceParams are the parameters of update_price.
+type UpdatePriceParams struct {
+	Account solana.PublicKey `json:"account"`
+	Price   int64            `json:"price"`
+	Conf    uint64           `json:"conf"`
+	Status  string           `json:"status"`
+}
+
+// AccountParams are the parameters of get_product, subscribe_price and subscribe_price_sched.
+type AccountParams struct {
+	Account solana.PublicKey `json:"account"`
+}
+
+// SubscribeResult is the result of subscribe_price and subscribe_price_sched.
+type SubscribeResult struct {
+	Subscription uint64 `json:"subscription"`
+}
+
+type notifyPriceParams struct {
+	Subscription uint64      `json:"subscription"`
+	Result       PriceUpdate `json:"result"`
+}
+
+type notifyPriceSchedParams struct {
+	Subscription uint64 `json:"subscription"`
+}
+
+// priceStatusIgnored is reported by the on-chain program for ignored components.
+const priceStatusIgnored = 4
+
+var statusNames = map[uint32]string{
+	pyth.PriceStatusUnknown: "unknown",
+	pyth.PriceStatusTrading: "trading",
+	pyth.PriceStatusHalted:  "halted",
+	pyth.PriceStatusAuction: "auction",
+	priceStatusIgnored:      "ignored",
+}
+
+// StatusString returns the protocol name of a price status.
+func StatusString(status uint32) string {
+	if name, ok := statusNames[status]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ParseStatus returns the price status of a protocol name.
+func ParseStatus(name string) (uint32, error) {
+	for status, statusName := range statusNames {
+		if statusName == name {
+			return status, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown price status %q", name)
+}
+
+// PriceTypeString returns the protocol name of a price type.
+func PriceTypeString(priceType uint32) string {
+	if priceType == pyth.PriceTypePrice {
+		return "price"
+	}
+	return "unknown"
+}
+
+func newPriceAccount(entry pyth.PriceAccountEntry) PriceAccount {
+	acc := entry.PriceAccount
+	price := PriceAccount{
+		Account:       entry.Pubkey,
+		PriceType:     PriceTypeString(acc.PriceType),
+		PriceExponent: acc.Exponent,
+		Status:        StatusString(acc.Agg.Status),
+		Price:         acc.Agg.Price,
+		Conf:          acc.Agg.Conf,
+		Twap:          acc.Twap.Val,
+		Twac:          acc.Twac.Val,
+		ValidSlot:     acc.ValidSlot,
+		PubSlot:       acc.Agg.PubSlot,
+		PrevSlot:      acc.PrevSlot,
+		PrevPrice:     acc.PrevPrice,
+		PrevConf:      acc.PrevConf,
+	}
+	for i := range acc.Components {
+		comp := &acc.Components[i]
+		if uint32(i) >= acc.Num || comp.Publisher.IsZero() {
+			break
+		}
+		price.PublisherAccounts = append(price.PublisherAccounts, PublisherAccount{
+			Account: comp.Publisher,
+			Status:  StatusString(comp.Latest.Status),
+			Price:   comp.Latest.Price,
+			Conf:    comp.Latest.Conf,
+			Slot:    comp.Latest.PubSlot,
+		})
+	}
+	return price
+}
+
+func newPriceUpdate(acc *pyth.PriceAccount) PriceUpdate {
+	return PriceUpdate{
+		Price:     acc.Agg.Price,
+		Conf:      acc.Agg.Conf,
+		Status:    StatusString(acc.Agg.Status),
+		ValidSlot: acc.ValidSlot,
+		PubSlot:   acc.Agg.PubSlot,
+	}
+}
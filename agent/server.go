@@ -0,0 +1,633 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package agent implements the pyth-agent WebSocket JSON-RPC protocol.
+//
+// Existing publisher integrations speaking this protocol can connect to a Server
+// instead of the Rust pyth-agent.
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/gorilla/websocket"
+	"go.blockdaemon.com/pyth"
+	"go.uber.org/zap"
+)
+
+// Opts configures a Server.
+type Opts struct {
+	// Commitment is used to query accounts. Defaults to confirmed.
+	Commitment rpc.CommitmentType
+	// Handler delivers the updates of subscribe_price.
+	// If nil, subscribe_price is not available.
+	Handler *pyth.PriceEventHandler
+	// Publisher publishes the prices of update_price.
+	// If nil, update_price and subscribe_price_sched are not available.
+	Publisher *pyth.Publisher
+	// Schedule configures when prices are published and notify_price_sched is sent.
+	// Defaults to every slot.
+	Schedule *pyth.SchedulerOpts
+	// SendBuffer is the number of outgoing messages queued per connection.
+	// Connections falling further behind are closed. Defaults to 256.
+	SendBuffer int
+	// CheckOrigin decides whether to accept a WebSocket handshake.
+	// Defaults to accepting same-origin requests, see websocket.Upgrader.
+	CheckOrigin func(r *http.Request) bool
+	// ProductRefresh is how often get_product_list and get_all_products scan all accounts again.
+	// In between, prices are kept up to date from Handler, if set. Defaults to one minute.
+	ProductRefresh time.Duration
+}
+
+const (
+	defaultSendBuffer     = 256
+	defaultProductRefresh = time.Minute
+	// maxMessageSize limits incoming WebSocket messages, connections sending larger ones are closed.
+	maxMessageSize = 1 << 20
+)
+
+// Server is a pyth-agent compatible JSON-RPC server, served over WebSocket.
+//
+// Product and price queries are answered from Solana RPC.
+// Queries of all products are served from a cache, see Opts.ProductRefresh.
+// Prices received with update_price are published once per scheduled slot,
+// only the latest price of each account is kept in between.
+type Server struct {
+	client   *pyth.Client
+	opts     Opts
+	upgrader websocket.Upgrader
+
+	scheduler *pyth.Scheduler
+	priceKeys map[solana.PublicKey]struct{} // price accounts accepted by update_price
+	subNonce  uint64                        // atomic, last subscription ID
+
+	quotesLock sync.Mutex // lock over quotes
+	quotes     map[solana.PublicKey]pyth.Quote
+
+	refreshLock  sync.Mutex // held while scanning accounts
+	productsLock sync.Mutex // lock over products, prices and scanned
+	products     []pyth.ProductAccountEntry
+	prices       map[solana.PublicKey]pyth.PriceAccountEntry
+	scanned      time.Time
+	priceSub     *pyth.Subscription[pyth.PriceUpdate]
+
+	connsLock sync.Mutex // lock over conns, sched and closed
+	conns     map[*conn]struct{}
+	sched     map[uint64]schedSubscription
+	closed    bool
+}
+
+type schedSubscription struct {
+	conn     *conn
+	priceKey solana.PublicKey
+}
+
+// NewServer creates a new pyth-agent server.
+//
+// If a publisher is given, the server starts publishing on its schedule.
+// The opts may be nil, which only serves product queries.
+//
+// Call Close to stop the server.
+func NewServer(client *pyth.Client, opts *Opts) *Server {
+	if opts == nil {
+		opts = new(Opts)
+	}
+	s := &Server{
+		client:    client,
+		opts:      *opts,
+		priceKeys: make(map[solana.PublicKey]struct{}),
+		quotes:    make(map[solana.PublicKey]pyth.Quote),
+		conns:     make(map[*conn]struct{}),
+		sched:     make(map[uint64]schedSubscription),
+	}
+	if s.opts.Commitment == "" {
+		s.opts.Commitment = rpc.CommitmentConfirmed
+	}
+	if s.opts.SendBuffer <= 0 {
+		s.opts.SendBuffer = defaultSendBuffer
+	}
+	if s.opts.ProductRefresh <= 0 {
+		s.opts.ProductRefresh = defaultProductRefresh
+	}
+	s.upgrader.CheckOrigin = s.opts.CheckOrigin
+	if s.opts.Publisher != nil {
+		for _, key := range s.opts.Publisher.PriceKeys() {
+			s.priceKeys[key] = struct{}{}
+		}
+		s.scheduler = s.opts.Publisher.Schedule(pyth.PriceSourceFunc(s.onSlot), s.opts.Schedule)
+	}
+	if s.opts.Handler != nil {
+		s.priceSub = s.opts.Handler.Subscribe(&pyth.SubscribeOpts{Buffer: 1024, DropWhenFull: true})
+		go s.cachePrices()
+	}
+	return s
+}
+
+// Close stops publishing and closes all connections.
+//
+// Prices not published yet are discarded.
+func (s *Server) Close() {
+	if s.scheduler != nil {
+		s.scheduler.Close()
+	}
+	if s.priceSub != nil {
+		s.priceSub.Unsubscribe()
+	}
+	s.connsLock.Lock()
+	s.closed = true
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.connsLock.Unlock()
+	for _, c := range conns {
+		c.close()
+	}
+}
+
+// ServeHTTP upgrades the request to a WebSocket connection and serves JSON-RPC requests on it.
+func (s *Server) ServeHTTP(wr http.ResponseWriter, req *http.Request) {
+	wsConn, err := s.upgrader.Upgrade(wr, req, nil)
+	if err != nil {
+		// The upgrader has already replied with an error.
+		return
+	}
+	c := &conn{
+		server: s,
+		ws:     wsConn,
+		out:    make(chan []byte, s.opts.SendBuffer),
+		done:   make(chan struct{}),
+		subs:   make(map[uint64]func()),
+	}
+	s.connsLock.Lock()
+	if s.closed {
+		s.connsLock.Unlock()
+		_ = wsConn.Close()
+		return
+	}
+	s.conns[c] = struct{}{}
+	s.connsLock.Unlock()
+
+	wsConn.SetReadLimit(maxMessageSize)
+	go c.writeLoop()
+	c.readLoop()
+	c.close()
+}
+
+// onSlot notifies subscribers of the schedule and returns the prices to publish.
+func (s *Server) onSlot(_ context.Context, _ uint64) ([]pyth.Quote, error) {
+	s.connsLock.Lock()
+	sched := make(map[uint64]*conn, len(s.sched))
+	for id, sub := range s.sched {
+		sched[id] = sub.conn
+	}
+	s.connsLock.Unlock()
+	for id, c := range sched {
+		c.notify(MethodNotifyPriceSched, notifyPriceSchedParams{Subscription: id})
+	}
+
+	s.quotesLock.Lock()
+	defer s.quotesLock.Unlock()
+	quotes := make([]pyth.Quote, 0, len(s.quotes))
+	for _, quote := range s.quotes {
+		quotes = append(quotes, quote)
+	}
+	s.quotes = make(map[solana.PublicKey]pyth.Quote)
+	return quotes, nil
+}
+
+func (s *Server) nextSubscription() uint64 {
+	return atomic.AddUint64(&s.subNonce, 1)
+}
+
+// handle serves a single request, returning nil for notifications.
+func (s *Server) handle(ctx context.Context, c *conn, req *request) *response {
+	result, rpcErr := s.call(ctx, c, req)
+	if len(req.ID) == 0 {
+		return nil
+	}
+	res := &response{JSONRPC: "2.0", ID: req.ID}
+	if rpcErr != nil {
+		res.Error = rpcErr
+	} else {
+		res.Result = result
+	}
+	return res
+}
+
+func (s *Server) call(ctx context.Context, c *conn, req *request) (interface{}, *Error) {
+	switch req.Method {
+	case MethodGetProductList:
+		return s.getProductList(ctx)
+	case MethodGetProduct:
+		var params AccountParams
+		if err := decodeParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.getProduct(ctx, params.Account)
+	case MethodGetAllProducts:
+		return s.getAllProducts(ctx)
+	case MethodUpdatePrice:
+		var params UpdatePriceParams
+		if err := decodeParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.updatePrice(&params)
+	case MethodSubscribePrice:
+		var params AccountParams
+		if err := decodeParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.subscribePrice(c, params.Account)
+	case MethodSubscribePriceSched:
+		var params AccountParams
+		if err := decodeParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.subscribePriceSched(c, params.Account)
+	default:
+		return nil, errorf(CodeMethodNotFound, "method not found: %s", req.Method)
+	}
+}
+
+func decodeParams(raw json.RawMessage, params interface{}) *Error {
+	if len(raw) == 0 || string(raw) == "null" {
+		return errorf(CodeInvalidParams, "missing params")
+	}
+	if err := json.Unmarshal(raw, params); err != nil {
+		return errorf(CodeInvalidParams, "invalid params: %s", err)
+	}
+	return nil
+}
+
+func (s *Server) getProductList(ctx context.Context) (interface{}, *Error) {
+	products, prices, err := s.queryAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]ProductAccountMetadata, 0, len(products))
+	for _, product := range products {
+		meta := ProductAccountMetadata{
+			Account:  product.Pubkey,
+			AttrDict: product.Attrs.KVs(),
+			Price:    []PriceAccountMetadata{},
+		}
+		for _, price := range productPrices(product, prices) {
+			meta.Price = append(meta.Price, PriceAccountMetadata{
+				Account:       price.Pubkey,
+				PriceType:     PriceTypeString(price.PriceType),
+				PriceExponent: price.Exponent,
+			})
+		}
+		list = append(list, meta)
+	}
+	return list, nil
+}
+
+func (s *Server) getProduct(ctx context.Context, productKey solana.PublicKey) (interface{}, *Error) {
+	product, err := s.client.GetProductAccount(ctx, productKey, s.opts.Commitment)
+	if err != nil {
+		return nil, errorf(CodeServerError, "failed to get product %s: %s", productKey, err)
+	}
+	var prices []pyth.PriceAccountEntry
+	if !product.FirstPrice.IsZero() {
+		prices, err = s.client.GetPriceAccountsRecursive(ctx, s.opts.Commitment, product.FirstPrice)
+		if err != nil {
+			return nil, errorf(CodeServerError, "failed to get prices of product %s: %s", productKey, err)
+		}
+	}
+	return newProductAccount(product, prices), nil
+}
+
+func (s *Server) getAllProducts(ctx context.Context) (interface{}, *Error) {
+	products, prices, err := s.queryAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]ProductAccount, 0, len(products))
+	for _, product := range products {
+		list = append(list, newProductAccount(product, productPrices(product, prices)))
+	}
+	return list, nil
+}
+
+// queryAll returns all products and price accounts of the program.
+//
+// The accounts are scanned at most once per Opts.ProductRefresh, concurrent queries share a scan.
+func (s *Server) queryAll(ctx context.Context) ([]pyth.ProductAccountEntry, map[solana.PublicKey]pyth.PriceAccountEntry, *Error) {
+	s.refreshLock.Lock()
+	defer s.refreshLock.Unlock()
+	s.productsLock.Lock()
+	fresh := !s.scanned.IsZero() && time.Since(s.scanned) < s.opts.ProductRefresh
+	s.productsLock.Unlock()
+	if !fresh {
+		if err := s.scanAll(ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	s.productsLock.Lock()
+	defer s.productsLock.Unlock()
+	prices := make(map[solana.PublicKey]pyth.PriceAccountEntry, len(s.prices))
+	for key, price := range s.prices {
+		prices[key] = price
+	}
+	return s.products, prices, nil
+}
+
+// scanAll fetches all products and price accounts of the program into the cache.
+func (s *Server) scanAll(ctx context.Context) *Error {
+	products, err := s.client.GetAllProductAccounts(ctx, s.opts.Commitment)
+	if err != nil {
+		return errorf(CodeServerError, "failed to get products: %s", err)
+	}
+	priceList, err := s.client.GetAllPriceAccounts(ctx, s.opts.Commitment)
+	if err != nil {
+		return errorf(CodeServerError, "failed to get prices: %s", err)
+	}
+	prices := make(map[solana.PublicKey]pyth.PriceAccountEntry, len(priceList))
+	for _, price := range priceList {
+		prices[price.Pubkey] = price
+	}
+	s.productsLock.Lock()
+	defer s.productsLock.Unlock()
+	s.products = products
+	s.prices = prices
+	s.scanned = time.Now()
+	return nil
+}
+
+// cachePrices keeps the cached price accounts up to date with the updates of the handler.
+//
+// Price accounts not seen by the last scan are added by the next one.
+func (s *Server) cachePrices() {
+	for update := range s.priceSub.C() {
+		s.productsLock.Lock()
+		if price, ok := s.prices[update.PriceKey]; ok {
+			price.PriceAccount = update.Account
+			s.prices[update.PriceKey] = price
+		}
+		s.productsLock.Unlock()
+	}
+}
+
+// productPrices returns the price accounts of a product in linked list order.
+func productPrices(product pyth.ProductAccountEntry, prices map[solana.PublicKey]pyth.PriceAccountEntry) []pyth.PriceAccountEntry {
+	var list []pyth.PriceAccountEntry
+	seen := make(map[solana.PublicKey]struct{})
+	for key := product.FirstPrice; !key.IsZero(); {
+		price, ok := prices[key]
+		if _, loop := seen[key]; !ok || loop {
+			break
+		}
+		seen[key] = struct{}{}
+		list = append(list, price)
+		key = price.Next
+	}
+	return list
+}
+
+func newProductAccount(product pyth.ProductAccountEntry, prices []pyth.PriceAccountEntry) ProductAccount {
+	res := ProductAccount{
+		Account:       product.Pubkey,
+		AttrDict:      product.Attrs.KVs(),
+		PriceAccounts: make([]PriceAccount, 0, len(prices)),
+	}
+	for _, price := range prices {
+		res.PriceAccounts = append(res.PriceAccounts, newPriceAccount(price))
+	}
+	return res
+}
+
+func (s *Server) updatePrice(params *UpdatePriceParams) (interface{}, *Error) {
+	if s.opts.Publisher == nil {
+		return nil, errorf(CodeServerError, "publishing not enabled")
+	}
+	if _, ok := s.priceKeys[params.Account]; !ok {
+		return nil, errorf(CodeInvalidParams, "price account %s not configured for publisher", params.Account)
+	}
+	status, err := ParseStatus(params.Status)
+	if err != nil {
+		return nil, errorf(CodeInvalidParams, "%s", err)
+	}
+	s.quotesLock.Lock()
+	defer s.quotesLock.Unlock()
+	s.quotes[params.Account] = pyth.Quote{
+		PriceKey: params.Account,
+		Price:    params.Price,
+		Conf:     params.Conf,
+		Status:   status,
+		Time:     time.Now(),
+	}
+	return 0, nil
+}
+
+func (s *Server) subscribePrice(c *conn, priceKey solana.PublicKey) (interface{}, *Error) {
+	if s.opts.Handler == nil {
+		return nil, errorf(CodeServerError, "price subscriptions not enabled")
+	}
+	id := s.nextSubscription()
+	sub := s.opts.Handler.Subscribe(&pyth.SubscribeOpts{
+		Filter:       pyth.SubscribeFilter{PriceKeys: []solana.PublicKey{priceKey}},
+		Buffer:       16,
+		DropWhenFull: true,
+	})
+	if !c.addSubscription(id, sub.Unsubscribe) {
+		sub.Unsubscribe()
+		return nil, errorf(CodeServerError, "connection closed")
+	}
+	go func() {
+		for update := range sub.C() {
+			c.notify(MethodNotifyPrice, notifyPriceParams{
+				Subscription: id,
+				Result:       newPriceUpdate(update.Account),
+			})
+		}
+	}()
+	return SubscribeResult{Subscription: id}, nil
+}
+
+func (s *Server) subscribePriceSched(c *conn, priceKey solana.PublicKey) (interface{}, *Error) {
+	if s.opts.Publisher == nil {
+		return nil, errorf(CodeServerError, "publishing not enabled")
+	}
+	if _, ok := s.priceKeys[priceKey]; !ok {
+		return nil, errorf(CodeInvalidParams, "price account %s not configured for publisher", priceKey)
+	}
+	id := s.nextSubscription()
+	unsubscribe := func() {
+		s.connsLock.Lock()
+		defer s.connsLock.Unlock()
+		delete(s.sched, id)
+	}
+	s.connsLock.Lock()
+	s.sched[id] = schedSubscription{conn: c, priceKey: priceKey}
+	s.connsLock.Unlock()
+	if !c.addSubscription(id, unsubscribe) {
+		unsubscribe()
+		return nil, errorf(CodeServerError, "connection closed")
+	}
+	return SubscribeResult{Subscription: id}, nil
+}
+
+// conn is a client connection.
+type conn struct {
+	server *Server
+	ws     *websocket.Conn
+	out    chan []byte
+	done   chan struct{}
+
+	lock   sync.Mutex // lock over subs and closed
+	subs   map[uint64]func()
+	closed bool
+}
+
+func (c *conn) readLoop() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-c.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	for {
+		_, msg, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		// Requests are served in order, so that price updates are applied in order.
+		c.serve(ctx, msg)
+	}
+}
+
+// serve answers a request or a batch of requests.
+func (c *conn) serve(ctx context.Context, msg []byte) {
+	msg = bytes.TrimSpace(msg)
+	if len(msg) > 0 && msg[0] == '[' {
+		var reqs []request
+		if err := json.Unmarshal(msg, &reqs); err != nil {
+			c.reply(&response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: errorf(CodeParseError, "parse error: %s", err)})
+			return
+		}
+		var batch []*response
+		for i := range reqs {
+			if res := c.serveRequest(ctx, &reqs[i]); res != nil {
+				batch = append(batch, res)
+			}
+		}
+		if len(batch) > 0 {
+			c.reply(batch)
+		}
+		return
+	}
+	var req request
+	if err := json.Unmarshal(msg, &req); err != nil {
+		c.reply(&response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: errorf(CodeParseError, "parse error: %s", err)})
+		return
+	}
+	if res := c.serveRequest(ctx, &req); res != nil {
+		c.reply(res)
+	}
+}
+
+func (c *conn) serveRequest(ctx context.Context, req *request) *response {
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return &response{JSONRPC: "2.0", ID: orNull(req.ID), Error: errorf(CodeInvalidRequest, "invalid request")}
+	}
+	return c.server.handle(ctx, c, req)
+}
+
+func orNull(id json.RawMessage) json.RawMessage {
+	if len(id) == 0 {
+		return json.RawMessage("null")
+	}
+	return id
+}
+
+func (c *conn) writeLoop() {
+	defer c.ws.Close()
+	for {
+		select {
+		case msg := <-c.out:
+			if err := c.ws.WriteMessage(websocket.TextMessage, msg); err != nil {
+				c.close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *conn) reply(v interface{}) {
+	msg, err := json.Marshal(v)
+	if err != nil {
+		c.server.client.Log.Error("Failed to encode agent response", zap.Error(err))
+		return
+	}
+	select {
+	case c.out <- msg:
+	case <-c.done:
+	default:
+		c.server.client.Log.Warn("Agent client too slow, closing connection")
+		c.close()
+	}
+}
+
+func (c *conn) notify(method string, params interface{}) {
+	c.reply(notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// addSubscription registers a function to be called when the connection closes.
+func (c *conn) addSubscription(id uint64, unsubscribe func()) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return false
+	}
+	c.subs[id] = unsubscribe
+	return true
+}
+
+// close ends all subscriptions of the connection and closes it.
+func (c *conn) close() {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return
+	}
+	c.closed = true
+	subs := c.subs
+	c.subs = nil
+	close(c.done)
+	c.lock.Unlock()
+
+	for _, unsubscribe := range subs {
+		unsubscribe()
+	}
+	_ = c.ws.Close()
+	c.server.connsLock.Lock()
+	delete(c.server.conns, c)
+	c.server.connsLock.Unlock()
+}
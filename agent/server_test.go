@@ -0,0 +1,379 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	bin "github.com/gagliardetto/binary"
+	"github.com/gagliardetto/solana-go"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.blockdaemon.com/pyth"
+)
+
+var (
+	testProductKey = solana.MustPublicKeyFromBase58("EWxGfxoPQSNA2744AYdAKmsQZ8F9o9M7oKkvL3VM1dko")
+	testPriceKey   = solana.MustPublicKeyFromBase58("E36MyBbavhYKHVLWR79GiReNNnBDiHj6nWA7htbkNZbh")
+)
+
+// fakeSolana answers the Solana RPC calls used by the server,
+// and streams slots to WebSocket slot subscriptions.
+type fakeSolana struct {
+	*httptest.Server
+	accounts map[string][]byte
+	slots    chan uint64
+
+	lock  sync.Mutex
+	sent  []*solana.Transaction
+	reads map[string]int // getAccountInfo calls by account
+}
+
+func newFakeSolana(t *testing.T) *fakeSolana {
+	product, err := os.ReadFile("../tests/product_account/EWxGfxoPQSNA2744AYdAKmsQZ8F9o9M7oKkvL3VM1dko.bin")
+	require.NoError(t, err)
+	priceData, err := os.ReadFile("../tests/price_account/E36MyBbavhYKHVLWR79GiReNNnBDiHj6nWA7htbkNZbh.bin")
+	require.NoError(t, err)
+	// Cut the price list after the first price account.
+	var price pyth.PriceAccount
+	require.NoError(t, price.UnmarshalBinary(priceData))
+	price.Next = solana.PublicKey{}
+	priceData, err = price.MarshalBinary()
+	require.NoError(t, err)
+	mapping := pyth.MappingAccount{
+		AccountHeader: pyth.AccountHeader{
+			Magic:       pyth.Magic,
+			Version:     pyth.V2,
+			AccountType: pyth.AccountTypeMapping,
+			Size:        pyth.MappingAccountLen,
+		},
+		Num: 1,
+	}
+	mapping.Products[0] = testProductKey
+	var mappingData bytes.Buffer
+	require.NoError(t, bin.NewBinEncoder(&mappingData).Encode(&mapping))
+
+	f := &fakeSolana{
+		accounts: map[string][]byte{
+			pyth.Devnet.Mapping.String(): mappingData.Bytes(),
+			testProductKey.String():      product,
+			testPriceKey.String():        priceData,
+		},
+		slots: make(chan uint64),
+		reads: make(map[string]int),
+	}
+	upgrader := websocket.Upgrader{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+		if websocket.IsWebSocketUpgrade(req) {
+			wsConn, err := upgrader.Upgrade(wr, req, nil)
+			require.NoError(t, err)
+			defer wsConn.Close()
+			f.serveSlots(t, wsConn)
+			return
+		}
+		var call struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&call))
+		require.NoError(t, json.NewEncoder(wr).Encode(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      call.ID,
+			"result":  f.call(t, call.Method, call.Params),
+		}))
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeSolana) account(key string) interface{} {
+	data, ok := f.accounts[key]
+	if !ok {
+		return nil
+	}
+	return map[string]interface{}{
+		"data":       []string{base64.StdEncoding.EncodeToString(data), "base64"},
+		"executable": false,
+		"lamports":   1,
+		"owner":      pyth.Devnet.Program.String(),
+		"rentEpoch":  0,
+	}
+}
+
+func (f *fakeSolana) call(t *testing.T, method string, params json.RawMessage) interface{} {
+	var args []json.RawMessage
+	require.NoError(t, json.Unmarshal(params, &args))
+	withContext := func(value interface{}) interface{} {
+		return map[string]interface{}{"context": map[string]interface{}{"slot": 100}, "value": value}
+	}
+	switch method {
+	case "getAccountInfo":
+		var key string
+		require.NoError(t, json.Unmarshal(args[0], &key))
+		f.lock.Lock()
+		f.reads[key]++
+		f.lock.Unlock()
+		return withContext(f.account(key))
+	case "getMultipleAccounts":
+		var keys []string
+		require.NoError(t, json.Unmarshal(args[0], &keys))
+		values := make([]interface{}, len(keys))
+		for i, key := range keys {
+			values[i] = f.account(key)
+		}
+		return withContext(values)
+	case "getLatestBlockhash":
+		return withContext(map[string]interface{}{
+			"blockhash":            "EkSnNWid2cvwEVnVx9aBqawnmiCNiDgp3gUdkDPTKN1N",
+			"lastValidBlockHeight": 200,
+		})
+	case "getSignatureStatuses":
+		return withContext([]interface{}{})
+	case "sendTransaction":
+		var encoded string
+		require.NoError(t, json.Unmarshal(args[0], &encoded))
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		require.NoError(t, err)
+		tx, err := solana.TransactionFromDecoder(bin.NewBinDecoder(raw))
+		require.NoError(t, err)
+		f.lock.Lock()
+		f.sent = append(f.sent, tx)
+		f.lock.Unlock()
+		return tx.Signatures[0].String()
+	default:
+		t.Errorf("unexpected RPC call %s", method)
+		return nil
+	}
+}
+
+// serveSlots answers a slot subscription with the slots sent to f.slots.
+func (f *fakeSolana) serveSlots(t *testing.T, wsConn *websocket.Conn) {
+	var req struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	if err := wsConn.ReadJSON(&req); err != nil {
+		return
+	}
+	require.Equal(t, "slotSubscribe", req.Method)
+	require.NoError(t, wsConn.WriteJSON(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": 1}))
+	for slot := range f.slots {
+		err := wsConn.WriteJSON(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"method":  "slotNotification",
+			"params": map[string]interface{}{
+				"subscription": 1,
+				"result":       map[string]interface{}{"parent": slot - 1, "root": slot - 32, "slot": slot},
+			},
+		})
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeSolana) accountReads(key solana.PublicKey) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.reads[key.String()]
+}
+
+func (f *fakeSolana) sentTransactions() []*solana.Transaction {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]*solana.Transaction(nil), f.sent...)
+}
+
+// dial connects a WebSocket client to the agent server.
+func dial(t *testing.T, server *Server) *websocket.Conn {
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+	wsConn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { wsConn.Close() })
+	return wsConn
+}
+
+// roundTrip sends a request and returns the raw response.
+func roundTrip(t *testing.T, wsConn *websocket.Conn, method string, params interface{}) map[string]json.RawMessage {
+	require.NoError(t, wsConn.WriteJSON(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  method,
+		"params":  params,
+	}))
+	var res map[string]json.RawMessage
+	require.NoError(t, wsConn.ReadJSON(&res))
+	return res
+}
+
+func TestServer_GetProduct(t *testing.T) {
+	solanaServer := newFakeSolana(t)
+	client := pyth.NewClient(pyth.Devnet, solanaServer.URL, solanaServer.URL)
+	server := NewServer(client, nil)
+	defer server.Close()
+	wsConn := dial(t, server)
+
+	res := roundTrip(t, wsConn, MethodGetProduct, AccountParams{Account: testProductKey})
+	require.NotContains(t, res, "error")
+	var product ProductAccount
+	require.NoError(t, json.Unmarshal(res["result"], &product))
+	assert.Equal(t, testProductKey, product.Account)
+	assert.Equal(t, "FX.EUR/USD", product.AttrDict["symbol"])
+	require.Len(t, product.PriceAccounts, 1)
+	price := product.PriceAccounts[0]
+	assert.Equal(t, testPriceKey, price.Account)
+	assert.Equal(t, "price", price.PriceType)
+	assert.Equal(t, "unknown", price.Status)
+	assert.NotEmpty(t, price.PublisherAccounts)
+}
+
+func TestServer_GetAllProducts(t *testing.T) {
+	solanaServer := newFakeSolana(t)
+	client := pyth.NewClient(pyth.Devnet, solanaServer.URL, solanaServer.URL)
+	server := NewServer(client, nil)
+	defer server.Close()
+	wsConn := dial(t, server)
+
+	res := roundTrip(t, wsConn, MethodGetProductList, nil)
+	require.NotContains(t, res, "error")
+	var list []ProductAccountMetadata
+	require.NoError(t, json.Unmarshal(res["result"], &list))
+	require.Len(t, list, 1)
+	assert.Equal(t, testProductKey, list[0].Account)
+	require.Len(t, list[0].Price, 1)
+	assert.Equal(t, testPriceKey, list[0].Price[0].Account)
+
+	res = roundTrip(t, wsConn, MethodGetAllProducts, nil)
+	require.NotContains(t, res, "error")
+	var products []ProductAccount
+	require.NoError(t, json.Unmarshal(res["result"], &products))
+	require.Len(t, products, 1)
+	require.Len(t, products[0].PriceAccounts, 1)
+	assert.Equal(t, testPriceKey, products[0].PriceAccounts[0].Account)
+
+	// Both queries are served from a single scan.
+	assert.Equal(t, 2, solanaServer.accountReads(pyth.Devnet.Mapping)) // by products and prices
+}
+
+func TestServer_ReadLimit(t *testing.T) {
+	solanaServer := newFakeSolana(t)
+	client := pyth.NewClient(pyth.Devnet, solanaServer.URL, solanaServer.URL)
+	server := NewServer(client, nil)
+	defer server.Close()
+	wsConn := dial(t, server)
+
+	_ = wsConn.WriteMessage(websocket.TextMessage, bytes.Repeat([]byte{' '}, maxMessageSize+1))
+	require.NoError(t, wsConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, _, err := wsConn.ReadMessage()
+	require.Error(t, err)
+	var netErr interface{ Timeout() bool }
+	if errors.As(err, &netErr) {
+		assert.False(t, netErr.Timeout(), "connection not closed")
+	}
+}
+
+func TestServer_Errors(t *testing.T) {
+	solanaServer := newFakeSolana(t)
+	client := pyth.NewClient(pyth.Devnet, solanaServer.URL, solanaServer.URL)
+	server := NewServer(client, nil)
+	defer server.Close()
+	wsConn := dial(t, server)
+
+	tests := []struct {
+		method string
+		params interface{}
+		code   int
+	}{
+		{"get_nothing", nil, CodeMethodNotFound},
+		{MethodGetProduct, nil, CodeInvalidParams},
+		{MethodGetProduct, map[string]interface{}{"account": "invalid"}, CodeInvalidParams},
+		{MethodUpdatePrice, UpdatePriceParams{Account: testPriceKey, Status: "trading"}, CodeServerError},
+		{MethodSubscribePrice, AccountParams{Account: testPriceKey}, CodeServerError},
+	}
+	for _, tc := range tests {
+		res := roundTrip(t, wsConn, tc.method, tc.params)
+		var rpcErr Error
+		require.NoError(t, json.Unmarshal(res["error"], &rpcErr), tc.method)
+		assert.Equal(t, tc.code, rpcErr.Code, tc.method)
+	}
+}
+
+func TestServer_UpdatePrice(t *testing.T) {
+	solanaServer := newFakeSolana(t)
+	client := pyth.NewClient(pyth.Devnet, solanaServer.URL, "ws"+strings.TrimPrefix(solanaServer.URL, "http"))
+	publisher := client.NewPublisher(context.Background(), pyth.NewKeypairSigner(solana.NewWallet().PrivateKey), []solana.PublicKey{testPriceKey}, nil)
+	defer publisher.Close()
+	server := NewServer(client, &Opts{Publisher: publisher})
+	defer server.Close()
+	wsConn := dial(t, server)
+
+	res := roundTrip(t, wsConn, MethodSubscribePriceSched, AccountParams{Account: testPriceKey})
+	require.NotContains(t, res, "error")
+	var sub SubscribeResult
+	require.NoError(t, json.Unmarshal(res["result"], &sub))
+
+	res = roundTrip(t, wsConn, MethodUpdatePrice, UpdatePriceParams{
+		Account: testPriceKey,
+		Price:   42,
+		Conf:    7,
+		Status:  "trading",
+	})
+	require.NotContains(t, res, "error")
+	assert.JSONEq(t, "0", string(res["result"]))
+
+	// The next slot notifies subscribers and publishes the pending price.
+	select {
+	case solanaServer.slots <- 1000:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publisher did not subscribe to slots")
+	}
+	var notify struct {
+		Method string                 `json:"method"`
+		Params notifyPriceSchedParams `json:"params"`
+	}
+	require.NoError(t, wsConn.ReadJSON(&notify))
+	assert.Equal(t, MethodNotifyPriceSched, notify.Method)
+	assert.Equal(t, sub.Subscription, notify.Params.Subscription)
+
+	require.Eventually(t, func() bool {
+		return len(solanaServer.sentTransactions()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	tx := solanaServer.sentTransactions()[0]
+	accounts, err := tx.Message.Instructions[0].ResolveInstructionAccounts(&tx.Message)
+	require.NoError(t, err)
+	ins, err := pyth.DecodeInstruction(pyth.Devnet.Program, accounts, tx.Message.Instructions[0].Data)
+	require.NoError(t, err)
+	assert.Equal(t, &pyth.CommandUpdPrice{
+		Status:  pyth.PriceStatusTrading,
+		Price:   42,
+		Conf:    7,
+		PubSlot: 1000,
+	}, ins.Payload)
+	close(solanaServer.slots)
+}
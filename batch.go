@@ -0,0 +1,314 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+// SlotBatch is a group of price account updates of the same slot.
+type SlotBatch struct {
+	Slot    uint64
+	Entries []PriceAccountEntry // in order of arrival
+}
+
+// SlotBatchOpts configures slot batching.
+type SlotBatchOpts struct {
+	// Timeout is how long a batch is held back waiting for a newer slot.
+	// Defaults to 1 second.
+	Timeout time.Duration
+}
+
+const defaultSlotBatchTimeout = time.Second
+
+// maxPendingSlotBatches is the number of batches waiting for a slow consumer
+// beyond which add blocks, passing backpressure on to the stream.
+const maxPendingSlotBatches = 64
+
+// slotBatcher groups updates by slot.
+//
+// A batch is emitted once an update of a newer slot arrives, or once the timeout passes.
+// Updates older than the current batch are emitted immediately in a batch of their own.
+// Updates arriving after their slot has been emitted by the timeout start a new batch of the same slot.
+//
+// Batches are emitted without holding the lock, so slow consumers do not block add
+// until maxPendingSlotBatches batches are waiting.
+// Only one goroutine emits at a time, which preserves the order of batches.
+type slotBatcher struct {
+	lock     sync.Mutex
+	idle     *sync.Cond // signaled when a pending batch is taken and when emitting ends
+	timeout  time.Duration
+	emit     func(SlotBatch) // called without lock held
+	cur      SlotBatch
+	gen      uint64      // incremented on each emitted batch
+	pending  []SlotBatch // batches waiting to be emitted
+	emitting bool        // whether a goroutine is emitting pending batches
+	timer    *time.Timer
+	closed   bool
+}
+
+func newSlotBatcher(timeout time.Duration, emit func(SlotBatch)) *slotBatcher {
+	if timeout <= 0 {
+		timeout = defaultSlotBatchTimeout
+	}
+	b := &slotBatcher{timeout: timeout, emit: emit}
+	b.idle = sync.NewCond(&b.lock)
+	return b
+}
+
+// add appends an update to the current batch.
+//
+// Blocks while too many batches are waiting for another goroutine to emit them.
+func (b *slotBatcher) add(entry PriceAccountEntry) {
+	b.lock.Lock()
+	defer b.emitPending()
+	for b.emitting && len(b.pending) >= maxPendingSlotBatches && !b.closed {
+		b.idle.Wait()
+	}
+	if b.closed {
+		return
+	}
+	switch {
+	case len(b.cur.Entries) > 0 && entry.Slot < b.cur.Slot:
+		b.pending = append(b.pending, SlotBatch{Slot: entry.Slot, Entries: []PriceAccountEntry{entry}})
+		return
+	case len(b.cur.Entries) > 0 && entry.Slot > b.cur.Slot:
+		b.flushLocked()
+	}
+	if len(b.cur.Entries) == 0 {
+		b.cur.Slot = entry.Slot
+		b.armLocked()
+	}
+	b.cur.Entries = append(b.cur.Entries, entry)
+}
+
+// emitPending emits all pending batches and releases the lock, which must be held.
+//
+// If another goroutine is already emitting, it takes care of the pending batches instead.
+func (b *slotBatcher) emitPending() {
+	if b.emitting {
+		b.lock.Unlock()
+		return
+	}
+	b.emitting = true
+	for len(b.pending) > 0 {
+		batch := b.pending[0]
+		b.pending = b.pending[1:]
+		b.idle.Broadcast()
+		b.lock.Unlock()
+		b.emit(batch)
+		b.lock.Lock()
+	}
+	b.pending = nil
+	b.emitting = false
+	b.idle.Broadcast()
+	b.lock.Unlock()
+}
+
+// armLocked starts the timeout of a new batch.
+func (b *slotBatcher) armLocked() {
+	gen := b.gen
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	b.timer = time.AfterFunc(b.timeout, func() {
+		b.lock.Lock()
+		defer b.emitPending()
+		if !b.closed && b.gen == gen {
+			b.flushLocked()
+		}
+	})
+}
+
+// flushLocked queues the current batch for emitting, if any.
+func (b *slotBatcher) flushLocked() {
+	if len(b.cur.Entries) == 0 {
+		return
+	}
+	b.pending = append(b.pending, b.cur)
+	b.cur = SlotBatch{}
+	b.gen++
+}
+
+// close emits the pending batches. No more batches are emitted once close returns.
+func (b *slotBatcher) close() {
+	b.lock.Lock()
+	if !b.closed {
+		if b.timer != nil {
+			b.timer.Stop()
+		}
+		b.flushLocked()
+		b.closed = true
+	}
+	b.emitPending()
+
+	// Wait for batches emitted by other goroutines.
+	b.lock.Lock()
+	for b.emitting {
+		b.idle.Wait()
+	}
+	b.lock.Unlock()
+}
+
+// SlotBatcher groups the updates of a PriceAccountSource by slot.
+//
+// Useful for consistent calculations across feeds, such as cross rates.
+type SlotBatcher struct {
+	src     PriceAccountSource
+	batches chan SlotBatch
+}
+
+// BatchSlots consumes all updates of a source and groups them by slot.
+//
+// A batch is emitted once an update of a newer slot arrives, or after SlotBatchOpts.Timeout.
+// Late updates of an already emitted slot are delivered in a separate batch.
+// The opts may be nil.
+func BatchSlots(src PriceAccountSource, opts *SlotBatchOpts) *SlotBatcher {
+	if opts == nil {
+		opts = new(SlotBatchOpts)
+	}
+	s := &SlotBatcher{
+		src:     src,
+		batches: make(chan SlotBatch),
+	}
+	batcher := newSlotBatcher(opts.Timeout, func(batch SlotBatch) {
+		s.batches <- batch
+	})
+	go func() {
+		defer close(s.batches)
+		for update := range src.Updates() {
+			batcher.add(update)
+		}
+		batcher.close()
+	}()
+	return s
+}
+
+// Batches returns a channel of slot batches.
+//
+// The channel is closed once the source ends.
+func (s *SlotBatcher) Batches() <-chan SlotBatch {
+	return s.batches
+}
+
+// Err returns the reason why the source has closed.
+func (s *SlotBatcher) Err() error {
+	return s.src.Err()
+}
+
+// Close closes the source and discards pending batches.
+//
+// Blocks until the source has been fully consumed.
+func (s *SlotBatcher) Close() {
+	s.src.Close()
+	for range s.batches {
+	}
+}
+
+// OnSlot registers a callback function to be called with the updates of each slot.
+//
+// Batches are formed from the stream as described for BatchSlots, using HandlerOpts.SlotTimeout.
+// Slot callbacks are called in order on a dedicated goroutine,
+// concurrently with the per-account callbacks of the same updates.
+func (p *PriceEventHandler) OnSlot(callback func(SlotBatch)) CallbackHandle {
+	key := atomic.AddUint64(&p.regNonce, 1)
+
+	p.slotLock.Lock()
+	defer p.slotLock.Unlock()
+	if p.slotCallbacks == nil {
+		p.slotCallbacks = make(map[uint64]func(SlotBatch))
+		p.slotQueue = make(chan SlotBatch, p.opts.QueueSize)
+		p.slots = newSlotBatcher(p.opts.SlotTimeout, func(batch SlotBatch) {
+			p.slotQueue <- batch
+		})
+		p.startBackground("slots", p.runSlotCallbacks)
+	}
+	p.slotCallbacks[key] = callback
+
+	return CallbackHandle{
+		handler: p,
+		key:     key,
+		cleanup: func() {
+			p.slotLock.Lock()
+			defer p.slotLock.Unlock()
+			delete(p.slotCallbacks, key)
+		},
+	}
+}
+
+// slotBatcher returns the slot batcher, if any slot callbacks were registered.
+func (p *PriceEventHandler) slotBatcher() *slotBatcher {
+	p.slotLock.Lock()
+	defer p.slotLock.Unlock()
+	return p.slots
+}
+
+func (p *PriceEventHandler) runSlotCallbacks(stopped <-chan struct{}) {
+	for {
+		select {
+		case batch := <-p.slotQueue:
+			p.dispatchSlot(batch)
+		case <-stopped:
+			// The last batch was flushed before the workers stopped.
+			for {
+				select {
+				case batch := <-p.slotQueue:
+					p.dispatchSlot(batch)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *PriceEventHandler) dispatchSlot(batch SlotBatch) {
+	p.slotLock.Lock()
+	callbacks := make([]uint64, 0, len(p.slotCallbacks))
+	for key := range p.slotCallbacks {
+		callbacks = append(callbacks, key)
+	}
+	p.slotLock.Unlock()
+	sort.Slice(callbacks, func(i, j int) bool {
+		return callbacks[i] < callbacks[j]
+	})
+
+	for _, key := range callbacks {
+		p.slotLock.Lock()
+		callback, ok := p.slotCallbacks[key]
+		p.slotLock.Unlock()
+		if ok {
+			p.safeSlotCallback(callback, batch)
+		}
+	}
+}
+
+// safeSlotCallback runs a slot callback, recovering from panics.
+func (p *PriceEventHandler) safeSlotCallback(callback func(SlotBatch), batch SlotBatch) {
+	defer func() {
+		if r := recover(); r != nil {
+			metricsCallbackPanicsTotal.Inc()
+			if p.opts.OnPanic != nil {
+				p.opts.OnPanic(solana.PublicKey{}, r)
+			}
+		}
+	}()
+	callback(batch)
+}
@@ -0,0 +1,201 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func batchSlots(batches []SlotBatch) (slots []uint64, sizes []int) {
+	for _, batch := range batches {
+		slots = append(slots, batch.Slot)
+		sizes = append(sizes, len(batch.Entries))
+	}
+	return
+}
+
+func TestSlotBatcher(t *testing.T) {
+	var batches []SlotBatch
+	b := newSlotBatcher(time.Hour, func(batch SlotBatch) {
+		batches = append(batches, batch)
+	})
+	keyA, keyB := solana.PublicKey{1}, solana.PublicKey{2}
+
+	b.add(PriceAccountEntry{Pubkey: keyA, Slot: 10})
+	b.add(PriceAccountEntry{Pubkey: keyB, Slot: 10})
+	assert.Empty(t, batches)
+	b.add(PriceAccountEntry{Pubkey: keyA, Slot: 11})
+	require.Len(t, batches, 1)
+	assert.Equal(t, keyA, batches[0].Entries[0].Pubkey)
+	assert.Equal(t, keyB, batches[0].Entries[1].Pubkey)
+	b.add(PriceAccountEntry{Pubkey: keyB, Slot: 9}) // late
+	b.add(PriceAccountEntry{Pubkey: keyB, Slot: 11})
+	b.close()
+	b.add(PriceAccountEntry{Pubkey: keyB, Slot: 12}) // ignored after close
+
+	slots, sizes := batchSlots(batches)
+	assert.Equal(t, []uint64{10, 9, 11}, slots)
+	assert.Equal(t, []int{2, 1, 2}, sizes)
+}
+
+func TestSlotBatcher_Timeout(t *testing.T) {
+	emitted := make(chan SlotBatch, 1)
+	b := newSlotBatcher(10*time.Millisecond, func(batch SlotBatch) {
+		emitted <- batch
+	})
+	b.add(PriceAccountEntry{Slot: 10})
+	select {
+	case batch := <-emitted:
+		assert.Equal(t, uint64(10), batch.Slot)
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch not emitted after timeout")
+	}
+	b.close()
+	assert.Empty(t, emitted)
+}
+
+func TestSlotBatcher_SlowConsumer(t *testing.T) {
+	emitted := make(chan SlotBatch)
+	b := newSlotBatcher(time.Hour, func(batch SlotBatch) {
+		emitted <- batch
+	})
+	b.add(PriceAccountEntry{Slot: 10})
+	// Emitting the batch of slot 10 blocks until it is received below.
+	go b.add(PriceAccountEntry{Slot: 11})
+	time.Sleep(10 * time.Millisecond)
+
+	// The stream path is not blocked by the pending consumer.
+	added := make(chan struct{})
+	go func() {
+		defer close(added)
+		b.add(PriceAccountEntry{Slot: 11})
+		b.add(PriceAccountEntry{Slot: 12})
+	}()
+	select {
+	case <-added:
+	case <-time.After(5 * time.Second):
+		t.Fatal("add blocked by slow consumer")
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		b.close()
+	}()
+	var slots []uint64
+	for i := 0; i < 3; i++ {
+		slots = append(slots, (<-emitted).Slot)
+	}
+	<-closed
+	assert.Equal(t, []uint64{10, 11, 12}, slots)
+}
+
+func TestSlotBatcher_Backpressure(t *testing.T) {
+	release := make(chan struct{})
+	var emitted []uint64
+	b := newSlotBatcher(time.Hour, func(batch SlotBatch) {
+		<-release
+		emitted = append(emitted, batch.Slot)
+	})
+
+	// The first emitter blocks in the consumer.
+	b.add(PriceAccountEntry{Slot: 1})
+	go b.add(PriceAccountEntry{Slot: 2})
+	require.Eventually(t, func() bool {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		return b.emitting
+	}, 5*time.Second, time.Millisecond)
+
+	// Further batches queue up to the limit, then add blocks.
+	const total = maxPendingSlotBatches + 10
+	var added int64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for slot := uint64(3); slot < 3+total; slot++ {
+			b.add(PriceAccountEntry{Slot: slot})
+			atomic.AddInt64(&added, 1)
+		}
+	}()
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&added) == maxPendingSlotBatches
+	}, 5*time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int64(maxPendingSlotBatches), atomic.LoadInt64(&added))
+	b.lock.Lock()
+	assert.Len(t, b.pending, maxPendingSlotBatches)
+	b.lock.Unlock()
+
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("add still blocked")
+	}
+	b.close()
+	require.Len(t, emitted, total+2)
+	for i, slot := range emitted {
+		assert.Equal(t, uint64(i+1), slot)
+	}
+}
+
+func TestBatchSlots(t *testing.T) {
+	src := newTestSource()
+	batcher := BatchSlots(src, nil)
+	go func() {
+		for _, slot := range []uint64{1, 1, 2, 3, 3} {
+			src.send(testPriceKey, 1, slot)
+		}
+		src.Close()
+	}()
+
+	var batches []SlotBatch
+	for batch := range batcher.Batches() {
+		batches = append(batches, batch)
+	}
+	require.NoError(t, batcher.Err())
+	slots, sizes := batchSlots(batches)
+	assert.Equal(t, []uint64{1, 2, 3}, slots)
+	assert.Equal(t, []int{2, 1, 2}, sizes)
+}
+
+func TestPriceEventHandler_OnSlot(t *testing.T) {
+	src := newTestSource()
+	handler := NewPriceEventHandler(src)
+
+	var batches []SlotBatch
+	handler.OnSlot(func(batch SlotBatch) {
+		batches = append(batches, batch)
+	})
+	removed := handler.OnSlot(func(SlotBatch) { t.Error("unsubscribed callback called") })
+	removed.Unsubscribe()
+
+	for _, slot := range []uint64{1, 1, 2, 3} {
+		src.send(testPriceKey, 1, slot)
+	}
+	src.Close()
+	require.NoError(t, handler.Wait())
+
+	slots, sizes := batchSlots(batches)
+	assert.Equal(t, []uint64{1, 2, 3}, slots)
+	assert.Equal(t, []int{2, 1, 1}, sizes)
+}
@@ -262,3 +262,123 @@ func (i *InstructionBuilder) SetMinPub(
 		Payload: &payload,
 	}
 }
+
+// ResizePriceAccount grows a price account to the current size of the on-chain format.
+func (i *InstructionBuilder) ResizePriceAccount(
+	fundingKey solana.PublicKey,
+	priceKey solana.PublicKey,
+) *Instruction {
+	return &Instruction{
+		programKey: i.programKey,
+		Header:     makeCommandHeader(Instruction_ResizePriceAccount),
+		accounts: []*solana.AccountMeta{
+			solana.Meta(fundingKey).SIGNER().WRITE(),
+			solana.Meta(priceKey).SIGNER().WRITE(),
+			solana.Meta(solana.SystemProgramID),
+		},
+	}
+}
+
+// DelPrice deletes the first price account of a product.
+func (i *InstructionBuilder) DelPrice(
+	fundingKey solana.PublicKey,
+	productKey solana.PublicKey,
+	priceKey solana.PublicKey,
+) *Instruction {
+	return &Instruction{
+		programKey: i.programKey,
+		Header:     makeCommandHeader(Instruction_DelPrice),
+		accounts: []*solana.AccountMeta{
+			solana.Meta(fundingKey).SIGNER().WRITE(),
+			solana.Meta(productKey).SIGNER().WRITE(),
+			solana.Meta(priceKey).SIGNER().WRITE(),
+		},
+	}
+}
+
+// DelProduct deletes a product without price accounts from a mapping account.
+func (i *InstructionBuilder) DelProduct(
+	fundingKey solana.PublicKey,
+	mappingKey solana.PublicKey,
+	productKey solana.PublicKey,
+) *Instruction {
+	return &Instruction{
+		programKey: i.programKey,
+		Header:     makeCommandHeader(Instruction_DelProduct),
+		accounts: []*solana.AccountMeta{
+			solana.Meta(fundingKey).SIGNER().WRITE(),
+			solana.Meta(mappingKey).SIGNER().WRITE(),
+			solana.Meta(productKey).SIGNER().WRITE(),
+		},
+	}
+}
+
+// UpdPermissions sets the authorities of the program, signed by its upgrade authority.
+//
+// See FindProgramDataAccount and FindPermissionsAccount for the accounts involved.
+func (i *InstructionBuilder) UpdPermissions(
+	fundingKey solana.PublicKey,
+	programDataKey solana.PublicKey,
+	permissionsKey solana.PublicKey,
+	payload CommandUpdPermissions,
+) *Instruction {
+	return &Instruction{
+		programKey: i.programKey,
+		Header:     makeCommandHeader(Instruction_UpdPermissions),
+		accounts: []*solana.AccountMeta{
+			solana.Meta(fundingKey).SIGNER().WRITE(),
+			solana.Meta(programDataKey),
+			solana.Meta(permissionsKey).WRITE(),
+			solana.Meta(solana.SystemProgramID),
+		},
+		Payload: &payload,
+	}
+}
+
+// SetMaxLatency sets the maximum slot latency of a price account.
+func (i *InstructionBuilder) SetMaxLatency(
+	fundingKey solana.PublicKey,
+	priceKey solana.PublicKey,
+	permissionsKey solana.PublicKey,
+	payload CommandSetMaxLatency,
+) *Instruction {
+	return &Instruction{
+		programKey: i.programKey,
+		Header:     makeCommandHeader(Instruction_SetMaxLatency),
+		accounts: []*solana.AccountMeta{
+			solana.Meta(fundingKey).SIGNER().WRITE(),
+			solana.Meta(priceKey).SIGNER().WRITE(),
+			solana.Meta(permissionsKey),
+		},
+		Payload: &payload,
+	}
+}
+
+// InitPriceFeedIndex assigns the next feed index to a price account.
+func (i *InstructionBuilder) InitPriceFeedIndex(
+	fundingKey solana.PublicKey,
+	priceKey solana.PublicKey,
+	permissionsKey solana.PublicKey,
+) *Instruction {
+	return &Instruction{
+		programKey: i.programKey,
+		Header:     makeCommandHeader(Instruction_InitPriceFeedIndex),
+		accounts: []*solana.AccountMeta{
+			solana.Meta(fundingKey).SIGNER().WRITE(),
+			solana.Meta(priceKey).SIGNER().WRITE(),
+			solana.Meta(permissionsKey).WRITE(),
+		},
+	}
+}
+
+// FindPermissionsAccount returns the address of the permissions account of the Pyth program.
+func FindPermissionsAccount(programKey solana.PublicKey) (solana.PublicKey, error) {
+	key, _, err := solana.FindProgramAddress([][]byte{[]byte("permissions")}, programKey)
+	return key, err
+}
+
+// FindProgramDataAccount returns the address of the program data account of an upgradeable program.
+func FindProgramDataAccount(programKey solana.PublicKey) (solana.PublicKey, error) {
+	key, _, err := solana.FindProgramAddress([][]byte{programKey[:]}, solana.BPFLoaderUpgradeableProgramID)
+	return key, err
+}
@@ -0,0 +1,524 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+	"gopkg.in/yaml.v3"
+)
+
+// Catalog is the desired state of the products of a Pyth deployment.
+//
+// Example in YAML:
+//
+//	products:
+//	  - attrs:
+//	      symbol: Crypto.BTC/USD
+//	      asset_type: Crypto
+//	    prices:
+//	      - exponent: -8
+//	        min_pub: 3
+//	        publishers:
+//	          - 5U3bH5b6XtG99aVWLqwVzYPVpQiFHytBD68Rz2eFPZd7
+//
+// Products are identified by their "symbol" attribute,
+// prices of a product by their price type.
+type Catalog struct {
+	Products []CatalogProduct `json:"products" yaml:"products"`
+}
+
+// CatalogProduct is the desired state of a product.
+type CatalogProduct struct {
+	// Attrs are the reference data of the product. The "symbol" attribute is required.
+	Attrs  map[string]string `json:"attrs" yaml:"attrs"`
+	Prices []CatalogPrice    `json:"prices" yaml:"prices"`
+}
+
+// Symbol returns the symbol identifying the product.
+func (p *CatalogProduct) Symbol() string {
+	return p.Attrs["symbol"]
+}
+
+// CatalogPrice is the desired state of a price account.
+type CatalogPrice struct {
+	Exponent int32 `json:"exponent" yaml:"exponent"`
+	// PriceType defaults to PriceTypePrice.
+	PriceType uint32 `json:"price_type,omitempty" yaml:"price_type,omitempty"`
+	// MinPub is left unmanaged if nil.
+	MinPub     *uint8             `json:"min_pub,omitempty" yaml:"min_pub,omitempty"`
+	Publishers []solana.PublicKey `json:"publishers" yaml:"publishers"`
+}
+
+func (p *CatalogPrice) priceType() uint32 {
+	if p.PriceType == 0 {
+		return PriceTypePrice
+	}
+	return p.PriceType
+}
+
+// LoadCatalog reads a catalog from a YAML or JSON file.
+func LoadCatalog(path string) (*Catalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	catalog, err := ParseCatalog(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid catalog %s: %w", path, err)
+	}
+	return catalog, nil
+}
+
+// ParseCatalog decodes and validates a catalog in YAML or JSON format.
+func ParseCatalog(data []byte) (*Catalog, error) {
+	// JSON is a subset of YAML.
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	catalog := new(Catalog)
+	if err := decoder.Decode(catalog); err != nil {
+		return nil, err
+	}
+	if err := catalog.Validate(); err != nil {
+		return nil, err
+	}
+	return catalog, nil
+}
+
+// Validate checks that the catalog describes a valid set of products.
+func (c *Catalog) Validate() error {
+	symbols := make(map[string]struct{}, len(c.Products))
+	for i := range c.Products {
+		product := &c.Products[i]
+		symbol := product.Symbol()
+		if symbol == "" {
+			return fmt.Errorf("product %d has no symbol", i)
+		}
+		if _, dup := symbols[symbol]; dup {
+			return fmt.Errorf("duplicate product %s", symbol)
+		}
+		symbols[symbol] = struct{}{}
+		if _, err := newProductAttrs(product.Attrs); err != nil {
+			return fmt.Errorf("product %s: %w", symbol, err)
+		}
+		priceTypes := make(map[uint32]struct{}, len(product.Prices))
+		for j := range product.Prices {
+			price := &product.Prices[j]
+			if _, dup := priceTypes[price.priceType()]; dup {
+				return fmt.Errorf("product %s: duplicate price type %d", symbol, price.priceType())
+			}
+			priceTypes[price.priceType()] = struct{}{}
+			if len(price.Publishers) > len(PriceAccount{}.Components) {
+				return fmt.Errorf("product %s: too many publishers (%d > %d)",
+					symbol, len(price.Publishers), len(PriceAccount{}.Components))
+			}
+			publishers := make(map[solana.PublicKey]struct{}, len(price.Publishers))
+			for _, publisher := range price.Publishers {
+				if _, dup := publishers[publisher]; dup {
+					return fmt.Errorf("product %s: duplicate publisher %s", symbol, publisher)
+				}
+				publishers[publisher] = struct{}{}
+			}
+		}
+	}
+	return nil
+}
+
+// CatalogChangeKind is the type of a change in a catalog plan.
+type CatalogChangeKind int
+
+// Catalog changes.
+const (
+	CatalogCreateProduct CatalogChangeKind = iota // creates a product with its prices (AddProduct)
+	CatalogUpdateProduct                          // replaces the attrs of a product (UpdProduct)
+	CatalogAddPrice                               // creates a price of an existing product (AddPrice)
+	CatalogAddPublisher                           // permits a publisher (AddPublisher)
+	CatalogDelPublisher                           // removes a publisher (DelPublisher)
+	CatalogSetMinPub                              // sets the minimum publishers (SetMinPub)
+)
+
+// CatalogChange is a single change required to bring on-chain state in line with a catalog.
+type CatalogChange struct {
+	Kind       CatalogChangeKind
+	Symbol     string
+	ProductKey solana.PublicKey // existing product, zero for CatalogCreateProduct
+	PriceKey   solana.PublicKey // existing price, for publisher and min pub changes
+
+	Product   *CatalogProduct   // desired product, for CatalogCreateProduct and CatalogUpdateProduct
+	OldAttrs  map[string]string // on-chain attrs, for CatalogUpdateProduct
+	Price     *CatalogPrice     // desired price, for CatalogAddPrice
+	Publisher solana.PublicKey  // for CatalogAddPublisher and CatalogDelPublisher
+	MinPub    uint8             // for CatalogSetMinPub
+	OldMinPub uint8             // for CatalogSetMinPub
+}
+
+// String returns a one-line summary of the change.
+func (c *CatalogChange) String() string {
+	switch c.Kind {
+	case CatalogCreateProduct:
+		return fmt.Sprintf("+ create product %s with %d price(s)", c.Symbol, len(c.Product.Prices))
+	case CatalogUpdateProduct:
+		return fmt.Sprintf("~ update attrs of product %s (%s)", c.Symbol, c.ProductKey)
+	case CatalogAddPrice:
+		return fmt.Sprintf("+ add price to product %s (%s)", c.Symbol, c.ProductKey)
+	case CatalogAddPublisher:
+		return fmt.Sprintf("+ add publisher %s to %s price %s", c.Publisher, c.Symbol, c.PriceKey)
+	case CatalogDelPublisher:
+		return fmt.Sprintf("- remove publisher %s from %s price %s", c.Publisher, c.Symbol, c.PriceKey)
+	case CatalogSetMinPub:
+		return fmt.Sprintf("~ set min_pub of %s price %s: %d -> %d", c.Symbol, c.PriceKey, c.OldMinPub, c.MinPub)
+	default:
+		return fmt.Sprintf("? unknown change %d", c.Kind)
+	}
+}
+
+// details writes the indented details of a change.
+func (c *CatalogChange) details(b *strings.Builder) {
+	switch c.Kind {
+	case CatalogCreateProduct:
+		for _, key := range sortedKeys(c.Product.Attrs) {
+			fmt.Fprintf(b, "    %s = %q\n", key, c.Product.Attrs[key])
+		}
+		for i := range c.Product.Prices {
+			writeCatalogPrice(b, &c.Product.Prices[i])
+		}
+	case CatalogUpdateProduct:
+		keys := sortedKeys(c.OldAttrs)
+		for _, key := range sortedKeys(c.Product.Attrs) {
+			if _, ok := c.OldAttrs[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			oldValue, hadKey := c.OldAttrs[key]
+			newValue, hasKey := c.Product.Attrs[key]
+			switch {
+			case !hadKey:
+				fmt.Fprintf(b, "    + %s = %q\n", key, newValue)
+			case !hasKey:
+				fmt.Fprintf(b, "    - %s = %q\n", key, oldValue)
+			case oldValue != newValue:
+				fmt.Fprintf(b, "    ~ %s = %q -> %q\n", key, oldValue, newValue)
+			}
+		}
+	case CatalogAddPrice:
+		writeCatalogPrice(b, c.Price)
+	}
+}
+
+func writeCatalogPrice(b *strings.Builder, price *CatalogPrice) {
+	fmt.Fprintf(b, "    + price type %d, exponent %d", price.priceType(), price.Exponent)
+	if price.MinPub != nil {
+		fmt.Fprintf(b, ", min_pub %d", *price.MinPub)
+	}
+	b.WriteString("\n")
+	for _, publisher := range price.Publishers {
+		fmt.Fprintf(b, "        + publisher %s\n", publisher)
+	}
+}
+
+// CatalogPlan lists the changes required to bring on-chain state in line with a catalog.
+type CatalogPlan struct {
+	Changes []CatalogChange
+	// Warnings report differences the plan does not resolve,
+	// such as on-chain products missing from the catalog or exponent mismatches.
+	Warnings []string
+}
+
+// Empty returns whether the plan has no changes.
+func (p *CatalogPlan) Empty() bool {
+	return len(p.Changes) == 0
+}
+
+// String returns the human-readable plan.
+func (p *CatalogPlan) String() string {
+	var b strings.Builder
+	for i := range p.Changes {
+		change := &p.Changes[i]
+		b.WriteString(change.String())
+		b.WriteByte('\n')
+		change.details(&b)
+	}
+	for _, warning := range p.Warnings {
+		fmt.Fprintf(&b, "! %s\n", warning)
+	}
+	if p.Empty() {
+		b.WriteString("No changes.\n")
+	} else {
+		fmt.Fprintf(&b, "%d change(s).\n", len(p.Changes))
+	}
+	return b.String()
+}
+
+// PlanCatalog compares the catalog against the on-chain products and prices.
+func (c *Client) PlanCatalog(ctx context.Context, catalog *Catalog, commitment rpc.CommitmentType) (*CatalogPlan, error) {
+	products, err := c.GetAllProductAccounts(ctx, commitment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product accounts: %w", err)
+	}
+	// Fetching prices of the products above, rather than via GetAllPriceAccounts, keeps both in sync.
+	firstPrices := make([]solana.PublicKey, 0, len(products))
+	for _, product := range products {
+		if !product.FirstPrice.IsZero() {
+			firstPrices = append(firstPrices, product.FirstPrice)
+		}
+	}
+	prices, err := c.GetPriceAccountsRecursive(ctx, commitment, firstPrices...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get price accounts: %w", err)
+	}
+	return DiffCatalog(catalog, products, prices)
+}
+
+// DiffCatalog returns the changes required to bring the given on-chain products and prices
+// in line with the catalog.
+//
+// Products and prices missing from the catalog are reported as warnings and left untouched.
+func DiffCatalog(catalog *Catalog, products []ProductAccountEntry, prices []PriceAccountEntry) (*CatalogPlan, error) {
+	if err := catalog.Validate(); err != nil {
+		return nil, err
+	}
+	plan := new(CatalogPlan)
+
+	bySymbol := make(map[string]ProductAccountEntry, len(products))
+	for _, product := range products {
+		symbol := product.Attrs.KVs()["symbol"]
+		if _, dup := bySymbol[symbol]; dup {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("duplicate product %s (%s) ignored", symbol, product.Pubkey))
+			continue
+		}
+		bySymbol[symbol] = product
+	}
+	byProduct := make(map[solana.PublicKey][]PriceAccountEntry)
+	for _, price := range prices {
+		byProduct[price.Product] = append(byProduct[price.Product], price)
+	}
+
+	for i := range catalog.Products {
+		desired := &catalog.Products[i]
+		symbol := desired.Symbol()
+		product, ok := bySymbol[symbol]
+		if !ok {
+			plan.Changes = append(plan.Changes, CatalogChange{
+				Kind:    CatalogCreateProduct,
+				Symbol:  symbol,
+				Product: desired,
+			})
+			continue
+		}
+		delete(bySymbol, symbol)
+
+		if attrs := product.Attrs.KVs(); !equalAttrs(attrs, desired.Attrs) {
+			plan.Changes = append(plan.Changes, CatalogChange{
+				Kind:       CatalogUpdateProduct,
+				Symbol:     symbol,
+				ProductKey: product.Pubkey,
+				Product:    desired,
+				OldAttrs:   attrs,
+			})
+		}
+
+		remaining := byProduct[product.Pubkey]
+		for j := range desired.Prices {
+			desiredPrice := &desired.Prices[j]
+			match := -1
+			for k, price := range remaining {
+				if price.PriceType == desiredPrice.priceType() {
+					match = k
+					break
+				}
+			}
+			if match < 0 {
+				plan.Changes = append(plan.Changes, CatalogChange{
+					Kind:       CatalogAddPrice,
+					Symbol:     symbol,
+					ProductKey: product.Pubkey,
+					Price:      desiredPrice,
+				})
+				continue
+			}
+			price := remaining[match]
+			remaining = append(remaining[:match:match], remaining[match+1:]...)
+			plan.diffPrice(symbol, desiredPrice, price)
+		}
+		for _, price := range remaining {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf(
+				"price %s of product %s (type %d) is not in the catalog", price.Pubkey, symbol, price.PriceType))
+		}
+	}
+
+	unmanaged := make([]string, 0, len(bySymbol))
+	for symbol := range bySymbol {
+		unmanaged = append(unmanaged, symbol)
+	}
+	sort.Strings(unmanaged)
+	for _, symbol := range unmanaged {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf(
+			"product %s (%s) is not in the catalog", symbol, bySymbol[symbol].Pubkey))
+	}
+	return plan, nil
+}
+
+// diffPrice adds the changes of an existing price account.
+//
+// Publishers are removed before new ones are added, to stay within the component limit.
+func (p *CatalogPlan) diffPrice(symbol string, desired *CatalogPrice, price PriceAccountEntry) {
+	if price.Exponent != desired.Exponent {
+		p.Warnings = append(p.Warnings, fmt.Sprintf(
+			"price %s of product %s has exponent %d instead of %d, which cannot be changed",
+			price.Pubkey, symbol, price.Exponent, desired.Exponent))
+	}
+	wanted := make(map[solana.PublicKey]struct{}, len(desired.Publishers))
+	for _, publisher := range desired.Publishers {
+		wanted[publisher] = struct{}{}
+	}
+	current := make(map[solana.PublicKey]struct{})
+	for _, publisher := range price.Publishers() {
+		current[publisher] = struct{}{}
+		if _, ok := wanted[publisher]; !ok {
+			p.Changes = append(p.Changes, CatalogChange{
+				Kind:       CatalogDelPublisher,
+				Symbol:     symbol,
+				ProductKey: price.Product,
+				PriceKey:   price.Pubkey,
+				Publisher:  publisher,
+			})
+		}
+	}
+	for _, publisher := range desired.Publishers {
+		if _, ok := current[publisher]; !ok {
+			p.Changes = append(p.Changes, CatalogChange{
+				Kind:       CatalogAddPublisher,
+				Symbol:     symbol,
+				ProductKey: price.Product,
+				PriceKey:   price.Pubkey,
+				Publisher:  publisher,
+			})
+		}
+	}
+	if minPub := price.MinPub(); desired.MinPub != nil && minPub != *desired.MinPub {
+		p.Changes = append(p.Changes, CatalogChange{
+			Kind:       CatalogSetMinPub,
+			Symbol:     symbol,
+			ProductKey: price.Product,
+			PriceKey:   price.Pubkey,
+			MinPub:     *desired.MinPub,
+			OldMinPub:  minPub,
+		})
+	}
+}
+
+// PrepareCatalogPlan builds the transactions applying a catalog plan.
+//
+// New products are added to the mapping list starting at mappingKey, as in PrepareCreateProduct.
+// The mapping list is only read if the plan creates products.
+//
+// Besides the funding account and the accounts created by the plan,
+// the transactions must be signed by the product accounts updated or extended with prices,
+// the price accounts whose publishers or min pub change, and the last mapping account if products are created.
+// Transactions must be confirmed in order.
+func (c *Client) PrepareCatalogPlan(
+	ctx context.Context,
+	funding solana.PublicKey,
+	mappingKey solana.PublicKey,
+	plan *CatalogPlan,
+) ([]*PreparedTransaction, error) {
+	if plan.Empty() {
+		return nil, nil
+	}
+	batch := c.newAdminBatch(funding)
+	var tail *mappingTail
+	for i := range plan.Changes {
+		change := &plan.Changes[i]
+		switch change.Kind {
+		case CatalogCreateProduct:
+			if tail == nil {
+				entry, err := c.findMappingTail(ctx, mappingKey)
+				if err != nil {
+					return nil, err
+				}
+				tail = &mappingTail{key: entry.Pubkey, num: entry.Num}
+			}
+			params := &CreateProductParams{Attrs: change.Product.Attrs}
+			for _, price := range change.Product.Prices {
+				params.Prices = append(params.Prices, price.createParams())
+			}
+			if _, err := batch.createProduct(ctx, tail, params); err != nil {
+				return nil, fmt.Errorf("failed to create product %s: %w", change.Symbol, err)
+			}
+		case CatalogUpdateProduct:
+			attrs, err := newProductAttrs(change.Product.Attrs)
+			if err != nil {
+				return nil, fmt.Errorf("failed to update product %s: %w", change.Symbol, err)
+			}
+			batch.add(batch.builder.UpdProduct(funding, change.ProductKey, CommandUpdProduct{AttrsMap: attrs}))
+		case CatalogAddPrice:
+			params := change.Price.createParams()
+			if _, err := batch.createPrice(ctx, change.ProductKey, &params); err != nil {
+				return nil, fmt.Errorf("failed to add price to product %s: %w", change.Symbol, err)
+			}
+		case CatalogAddPublisher:
+			batch.add(batch.builder.AddPublisher(funding, change.PriceKey, CommandAddPublisher{Publisher: change.Publisher}))
+		case CatalogDelPublisher:
+			batch.add(batch.builder.DelPublisher(funding, change.PriceKey, CommandDelPublisher{Publisher: change.Publisher}))
+		case CatalogSetMinPub:
+			batch.add(batch.builder.SetMinPub(funding, change.PriceKey, CommandSetMinPub{MinPub: change.MinPub}))
+		default:
+			return nil, fmt.Errorf("unknown catalog change %d", change.Kind)
+		}
+	}
+	return batch.prepare(ctx)
+}
+
+func (p *CatalogPrice) createParams() CreatePriceParams {
+	params := CreatePriceParams{
+		Exponent:   p.Exponent,
+		PriceType:  p.priceType(),
+		Publishers: p.Publishers,
+	}
+	if p.MinPub != nil {
+		params.MinPub = *p.MinPub
+	}
+	return params
+}
+
+func equalAttrs(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if other, ok := b[key]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
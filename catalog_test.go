@@ -0,0 +1,272 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCatalog(t *testing.T) {
+	publisher := solana.MustPublicKeyFromBase58("5U3bH5b6XtG99aVWLqwVzYPVpQiFHytBD68Rz2eFPZd7")
+	expected := &Catalog{Products: []CatalogProduct{{
+		Attrs: map[string]string{"symbol": "Crypto.BTC/USD", "asset_type": "Crypto"},
+		Prices: []CatalogPrice{{
+			Exponent:   -8,
+			MinPub:     testMinPub(3),
+			Publishers: []solana.PublicKey{publisher},
+		}},
+	}}}
+
+	catalog, err := ParseCatalog([]byte(`
+products:
+  - attrs:
+      symbol: Crypto.BTC/USD
+      asset_type: Crypto
+    prices:
+      - exponent: -8
+        min_pub: 3
+        publishers:
+          - 5U3bH5b6XtG99aVWLqwVzYPVpQiFHytBD68Rz2eFPZd7
+`))
+	require.NoError(t, err)
+	assert.Equal(t, expected, catalog)
+
+	catalog, err = ParseCatalog([]byte(`{"products": [{
+		"attrs": {"symbol": "Crypto.BTC/USD", "asset_type": "Crypto"},
+		"prices": [{"exponent": -8, "min_pub": 3, "publishers": ["5U3bH5b6XtG99aVWLqwVzYPVpQiFHytBD68Rz2eFPZd7"]}]
+	}]}`))
+	require.NoError(t, err)
+	assert.Equal(t, expected, catalog)
+}
+
+func TestParseCatalog_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		catalog string
+		err     string
+	}{
+		{"NoSymbol", `products: [{attrs: {asset_type: Crypto}}]`, "product 0 has no symbol"},
+		{"DuplicateProduct", `products: [{attrs: {symbol: A}}, {attrs: {symbol: A}}]`, "duplicate product A"},
+		{"DuplicatePriceType", `products: [{attrs: {symbol: A}, prices: [{exponent: -8}, {exponent: -5, price_type: 1}]}]`,
+			"product A: duplicate price type 1"},
+		{"UnknownField", `products: [{attrs: {symbol: A}, prices: [{expo: -8}]}]`, "field expo not found"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseCatalog([]byte(tc.catalog))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
+
+func testMinPub(n uint8) *uint8 {
+	return &n
+}
+
+func testCatalogState(t *testing.T, productKey solana.PublicKey, attrs map[string]string, prices ...*PriceAccount) ([]ProductAccountEntry, []PriceAccountEntry) {
+	attrsMap, err := NewAttrsMap(attrs)
+	require.NoError(t, err)
+	products := []ProductAccountEntry{{
+		ProductAccount: &ProductAccount{Attrs: attrsMap},
+		Pubkey:         productKey,
+	}}
+	var priceEntries []PriceAccountEntry
+	for i, price := range prices {
+		price.Product = productKey
+		priceEntries = append(priceEntries, PriceAccountEntry{
+			PriceAccount: price,
+			Pubkey:       solana.PublicKey{0xaa, byte(i)},
+		})
+	}
+	return products, priceEntries
+}
+
+func TestDiffCatalog(t *testing.T) {
+	productKey := solana.PublicKey{0xbb}
+	priceKey := solana.PublicKey{0xaa, 0}
+	keep, remove, add := solana.PublicKey{1}, solana.PublicKey{2}, solana.PublicKey{3}
+
+	price := &PriceAccount{PriceType: PriceTypePrice, Exponent: -5, Num: 2, Drv2: 1}
+	price.Components[0].Publisher = keep
+	price.Components[1].Publisher = remove
+	products, prices := testCatalogState(t, productKey,
+		map[string]string{"symbol": "FX.EUR/USD", "description": "old"}, price)
+	// Unmanaged products and prices are reported, but kept.
+	unmanaged, _ := testCatalogState(t, solana.PublicKey{0xcc}, map[string]string{"symbol": "FX.GBP/USD"})
+	products = append(products, unmanaged...)
+
+	catalog := &Catalog{Products: []CatalogProduct{
+		{
+			Attrs: map[string]string{"symbol": "FX.EUR/USD", "description": "new"},
+			Prices: []CatalogPrice{
+				{Exponent: -5, MinPub: testMinPub(2), Publishers: []solana.PublicKey{keep, add}},
+				{Exponent: -3, PriceType: 2},
+			},
+		},
+		{
+			Attrs:  map[string]string{"symbol": "Crypto.BTC/USD"},
+			Prices: []CatalogPrice{{Exponent: -8}},
+		},
+	}}
+	plan, err := DiffCatalog(catalog, products, prices)
+	require.NoError(t, err)
+
+	var kinds []CatalogChangeKind
+	for _, change := range plan.Changes {
+		kinds = append(kinds, change.Kind)
+	}
+	assert.Equal(t, []CatalogChangeKind{
+		CatalogUpdateProduct,
+		CatalogDelPublisher,
+		CatalogAddPublisher,
+		CatalogSetMinPub,
+		CatalogAddPrice,
+		CatalogCreateProduct,
+	}, kinds)
+	assert.Equal(t, remove, plan.Changes[1].Publisher)
+	assert.Equal(t, priceKey, plan.Changes[1].PriceKey)
+	assert.Equal(t, add, plan.Changes[2].Publisher)
+	assert.Equal(t, uint8(1), plan.Changes[3].OldMinPub)
+	assert.Equal(t, uint8(2), plan.Changes[3].MinPub)
+	assert.Equal(t, uint32(2), plan.Changes[4].Price.PriceType)
+	assert.Equal(t, []string{"product FX.GBP/USD (" + solana.PublicKey{0xcc}.String() + ") is not in the catalog"}, plan.Warnings)
+
+	out := plan.String()
+	assert.Contains(t, out, "~ update attrs of product FX.EUR/USD")
+	assert.Contains(t, out, `    ~ description = "old" -> "new"`)
+	assert.Contains(t, out, "- remove publisher "+remove.String())
+	assert.Contains(t, out, "+ create product Crypto.BTC/USD with 1 price(s)")
+	assert.Contains(t, out, "6 change(s).")
+
+	// Applying the changes converges.
+	price.Components[1].Publisher = add
+	price.Drv2 = 2
+	products[0].Attrs, err = NewAttrsMap(catalog.Products[0].Attrs)
+	require.NoError(t, err)
+	catalog.Products = catalog.Products[:1]
+	catalog.Products[0].Prices = catalog.Products[0].Prices[:1]
+	plan, err = DiffCatalog(catalog, products, prices)
+	require.NoError(t, err)
+	assert.True(t, plan.Empty())
+	assert.Contains(t, plan.String(), "No changes.")
+}
+
+func TestDiffCatalog_Exponent(t *testing.T) {
+	products, prices := testCatalogState(t, solana.PublicKey{0xbb}, map[string]string{"symbol": "A"},
+		&PriceAccount{PriceType: PriceTypePrice, Exponent: -5})
+	plan, err := DiffCatalog(&Catalog{Products: []CatalogProduct{{
+		Attrs:  map[string]string{"symbol": "A"},
+		Prices: []CatalogPrice{{Exponent: -8}},
+	}}}, products, prices)
+	require.NoError(t, err)
+	assert.True(t, plan.Empty())
+	require.Len(t, plan.Warnings, 1)
+	assert.Contains(t, plan.Warnings[0], "has exponent -5 instead of -8")
+}
+
+func TestDiffCatalog_MinPubUnset(t *testing.T) {
+	publisher := solana.MustPublicKeyFromBase58("5U3bH5b6XtG99aVWLqwVzYPVpQiFHytBD68Rz2eFPZd7")
+	price := &PriceAccount{PriceType: PriceTypePrice, Exponent: -8, Drv2: 3}
+	products, prices := testCatalogState(t, solana.PublicKey{0xbb}, map[string]string{"symbol": "A"}, price)
+	catalog, err := ParseCatalog([]byte(`
+products:
+  - attrs: {symbol: A}
+    prices:
+      - exponent: -8
+        publishers: [5U3bH5b6XtG99aVWLqwVzYPVpQiFHytBD68Rz2eFPZd7]
+`))
+	require.NoError(t, err)
+	require.Nil(t, catalog.Products[0].Prices[0].MinPub)
+
+	// An omitted min_pub keeps the on-chain value.
+	plan, err := DiffCatalog(catalog, products, prices)
+	require.NoError(t, err)
+	require.Len(t, plan.Changes, 1)
+	assert.Equal(t, CatalogAddPublisher, plan.Changes[0].Kind)
+
+	price.Components[0].Publisher = publisher
+	price.Num = 1
+	plan, err = DiffCatalog(catalog, products, prices)
+	require.NoError(t, err)
+	assert.True(t, plan.Empty())
+	assert.Equal(t, uint8(3), price.MinPub())
+}
+
+func TestClient_PrepareCatalogPlan(t *testing.T) {
+	funding := NewKeypairSigner(solana.NewWallet().PrivateKey)
+	mapping := NewKeypairSigner(solana.NewWallet().PrivateKey)
+	productKey := solana.PublicKey{0xbb}
+	priceKey := solana.PublicKey{0xaa}
+
+	rpcServer := newFakeRPC(t)
+	rpcServer.handleBlockhash()
+	rpcServer.handleRent(t)
+	// Two new products fill up the mapping account, so the second one needs a new mapping account.
+	rpcServer.handleAccounts(t, map[solana.PublicKey][]byte{
+		mapping.PublicKey(): testMappingAccount(t, MappingAccountCapacity-1, solana.PublicKey{}),
+	})
+	client := NewClient(Devnet, rpcServer.URL, rpcServer.URL)
+
+	newProduct := func(symbol string) *CatalogProduct {
+		return &CatalogProduct{
+			Attrs:  map[string]string{"symbol": symbol},
+			Prices: []CatalogPrice{{Exponent: -8, MinPub: testMinPub(1), Publishers: []solana.PublicKey{{1}}}},
+		}
+	}
+	plan := &CatalogPlan{Changes: []CatalogChange{
+		{Kind: CatalogCreateProduct, Symbol: "A", Product: newProduct("A")},
+		{Kind: CatalogCreateProduct, Symbol: "B", Product: newProduct("B")},
+		{Kind: CatalogDelPublisher, PriceKey: priceKey, Publisher: solana.PublicKey{2}},
+		{Kind: CatalogSetMinPub, PriceKey: priceKey, MinPub: 2},
+		{Kind: CatalogAddPrice, ProductKey: productKey, Price: &CatalogPrice{Exponent: -3}},
+	}}
+	txs, err := client.PrepareCatalogPlan(context.Background(), funding.PublicKey(), mapping.PublicKey(), plan)
+	require.NoError(t, err)
+
+	commands, creates := decodeAdminInstructions(t, txs)
+	assert.Equal(t, []int32{
+		Instruction_AddProduct,
+		Instruction_UpdProduct,
+		Instruction_AddPrice,
+		Instruction_AddPublisher,
+		Instruction_SetMinPub,
+		Instruction_AddMapping,
+		Instruction_AddProduct,
+		Instruction_UpdProduct,
+		Instruction_AddPrice,
+		Instruction_AddPublisher,
+		Instruction_SetMinPub,
+		Instruction_DelPublisher,
+		Instruction_SetMinPub,
+		Instruction_AddPrice,
+	}, commands)
+	// Product and price of A, mapping, product and price of B, and the new price.
+	assert.Len(t, creates, 6)
+
+	// Existing accounts must sign in addition to the funding account.
+	var required []solana.PublicKey
+	for _, tx := range txs {
+		required = append(required, tx.RequiredSigners()...)
+	}
+	assert.Contains(t, required, mapping.PublicKey())
+	assert.Contains(t, required, productKey)
+	assert.Contains(t, required, priceKey)
+}
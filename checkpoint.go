@@ -0,0 +1,248 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+// HandlerCheckpoint is the last-seen state of the named callback registrations of a PriceEventHandler.
+//
+// Checkpoints can be persisted as JSON or in a compact binary format (MarshalBinary).
+type HandlerCheckpoint struct {
+	LatestSlot uint64            `json:"latest_slot"`
+	Entries    []CheckpointEntry `json:"entries"` // sorted by name
+}
+
+// CheckpointEntry is the last price info seen by a named callback registration.
+type CheckpointEntry struct {
+	Name      string           `json:"name"`
+	PriceKey  solana.PublicKey `json:"price_key"`
+	Publisher solana.PublicKey `json:"publisher"` // zero for aggregate price callbacks
+	Price     int64            `json:"price"`
+	Conf      uint64           `json:"conf"`
+	Status    uint32           `json:"status"`
+	CorpAct   uint32           `json:"corp_act"`
+	PubSlot   uint64           `json:"pub_slot"`
+}
+
+// Info returns the price info of the entry.
+func (e *CheckpointEntry) Info() PriceInfo {
+	return PriceInfo{
+		Price:   e.Price,
+		Conf:    e.Conf,
+		Status:  e.Status,
+		CorpAct: e.CorpAct,
+		PubSlot: e.PubSlot,
+	}
+}
+
+func newCheckpointEntry(name string, priceKey, publisher solana.PublicKey, info *PriceInfo) CheckpointEntry {
+	return CheckpointEntry{
+		Name:      name,
+		PriceKey:  priceKey,
+		Publisher: publisher,
+		Price:     info.Price,
+		Conf:      info.Conf,
+		Status:    info.Status,
+		CorpAct:   info.CorpAct,
+		PubSlot:   info.PubSlot,
+	}
+}
+
+// checkpointMagic starts the binary checkpoint format.
+var checkpointMagic = [8]byte{'P', 'Y', 'T', 'H', 'C', 'K', 'P', 1}
+
+// checkpointEntryLen is the binary size of an entry, excluding its name.
+const checkpointEntryLen = 2 + 32 + 32 + 32
+
+// MarshalBinary encodes the checkpoint.
+//
+// The format is the 8-byte magic, the latest slot (u64), the number of entries (u32),
+// followed by each entry as name length (u16), name, price key, publisher and price info.
+// Integers are little-endian.
+func (c *HandlerCheckpoint) MarshalBinary() ([]byte, error) {
+	size := len(checkpointMagic) + 8 + 4
+	for i := range c.Entries {
+		if len(c.Entries[i].Name) > 0xFFFF {
+			return nil, fmt.Errorf("checkpoint name too long: %d bytes", len(c.Entries[i].Name))
+		}
+		size += checkpointEntryLen + len(c.Entries[i].Name)
+	}
+	buf := make([]byte, size)
+	off := copy(buf, checkpointMagic[:])
+	binary.LittleEndian.PutUint64(buf[off:], c.LatestSlot)
+	binary.LittleEndian.PutUint32(buf[off+8:], uint32(len(c.Entries)))
+	off += 12
+	for i := range c.Entries {
+		e := &c.Entries[i]
+		binary.LittleEndian.PutUint16(buf[off:], uint16(len(e.Name)))
+		off += 2
+		off += copy(buf[off:], e.Name)
+		copy(buf[off:], e.PriceKey[:])
+		copy(buf[off+32:], e.Publisher[:])
+		binary.LittleEndian.PutUint64(buf[off+64:], uint64(e.Price))
+		binary.LittleEndian.PutUint64(buf[off+72:], e.Conf)
+		binary.LittleEndian.PutUint32(buf[off+80:], e.Status)
+		binary.LittleEndian.PutUint32(buf[off+84:], e.CorpAct)
+		binary.LittleEndian.PutUint64(buf[off+88:], e.PubSlot)
+		off += 96
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a checkpoint encoded with MarshalBinary.
+func (c *HandlerCheckpoint) UnmarshalBinary(buf []byte) error {
+	if len(buf) < len(checkpointMagic)+12 || string(buf[:len(checkpointMagic)]) != string(checkpointMagic[:]) {
+		return errors.New("not a handler checkpoint")
+	}
+	buf = buf[len(checkpointMagic):]
+	latestSlot := binary.LittleEndian.Uint64(buf[0:])
+	num := binary.LittleEndian.Uint32(buf[8:])
+	buf = buf[12:]
+	if uint64(num)*checkpointEntryLen > uint64(len(buf)) {
+		return errors.New("checkpoint truncated")
+	}
+	entries := make([]CheckpointEntry, num)
+	for i := range entries {
+		if len(buf) < 2 {
+			return errors.New("checkpoint truncated")
+		}
+		nameLen := int(binary.LittleEndian.Uint16(buf))
+		if len(buf) < checkpointEntryLen+nameLen {
+			return errors.New("checkpoint truncated")
+		}
+		buf = buf[2:]
+		e := &entries[i]
+		e.Name = string(buf[:nameLen])
+		buf = buf[nameLen:]
+		copy(e.PriceKey[:], buf[0:32])
+		copy(e.Publisher[:], buf[32:64])
+		e.Price = int64(binary.LittleEndian.Uint64(buf[64:]))
+		e.Conf = binary.LittleEndian.Uint64(buf[72:])
+		e.Status = binary.LittleEndian.Uint32(buf[80:])
+		e.CorpAct = binary.LittleEndian.Uint32(buf[84:])
+		e.PubSlot = binary.LittleEndian.Uint64(buf[88:])
+		buf = buf[96:]
+	}
+	if len(buf) != 0 {
+		return fmt.Errorf("%d trailing bytes after checkpoint", len(buf))
+	}
+	*c = HandlerCheckpoint{LatestSlot: latestSlot, Entries: entries}
+	return nil
+}
+
+// Checkpoint exports the last price info seen by each named callback registration.
+//
+// Registrations that have not seen any update yet are omitted.
+// See CallbackOpts.Name.
+func (p *PriceEventHandler) Checkpoint() HandlerCheckpoint {
+	cp := HandlerCheckpoint{LatestSlot: p.LatestSlot()}
+	add := func(priceKey, publisher solana.PublicKey, container callbackMap) {
+		for _, reg := range container {
+			if reg.name == "" {
+				continue
+			}
+			reg.infoLock.Lock()
+			info := reg.previousInfo
+			reg.infoLock.Unlock()
+			if info != nil {
+				cp.Entries = append(cp.Entries, newCheckpointEntry(reg.name, priceKey, publisher, info))
+			}
+		}
+	}
+	for i := range p.shards {
+		shard := &p.shards[i]
+		shard.lock.Lock()
+		for priceKey, callbacks := range shard.callbacks {
+			add(priceKey, solana.PublicKey{}, callbacks.onPrice)
+			for publisher, container := range callbacks.componentCallbacks {
+				add(priceKey, publisher, container)
+			}
+		}
+		shard.lock.Unlock()
+	}
+	sort.Slice(cp.Entries, func(i, j int) bool {
+		return cp.Entries[i].Name < cp.Entries[j].Name
+	})
+	return cp
+}
+
+// Restore imports a checkpoint created by Checkpoint, usually by a previous process.
+//
+// Named registrations resume from the restored price info,
+// so that an unchanged price does not fire again as a first-seen update.
+// Applies to existing registrations and to registrations made after Restore.
+// Entries only apply if the registration's price key and publisher match.
+//
+// Restore should be called before the stream delivers updates.
+func (p *PriceEventHandler) Restore(cp HandlerCheckpoint) {
+	restored := make(map[string]CheckpointEntry, len(cp.Entries))
+	for _, e := range cp.Entries {
+		restored[e.Name] = e
+	}
+	p.restoreLock.Lock()
+	p.restored = restored
+	p.restoreLock.Unlock()
+
+	for {
+		latest := atomic.LoadUint64(&p.latestSlot)
+		if cp.LatestSlot <= latest || atomic.CompareAndSwapUint64(&p.latestSlot, latest, cp.LatestSlot) {
+			break
+		}
+	}
+
+	apply := func(priceKey, publisher solana.PublicKey, container callbackMap) {
+		for _, reg := range container {
+			if reg.name == "" {
+				continue
+			}
+			if info := p.restoredInfo(reg.name, priceKey, publisher); info != nil {
+				reg.infoLock.Lock()
+				reg.previousInfo = info
+				reg.infoLock.Unlock()
+			}
+		}
+	}
+	for i := range p.shards {
+		shard := &p.shards[i]
+		shard.lock.Lock()
+		for priceKey, callbacks := range shard.callbacks {
+			apply(priceKey, solana.PublicKey{}, callbacks.onPrice)
+			for publisher, container := range callbacks.componentCallbacks {
+				apply(priceKey, publisher, container)
+			}
+		}
+		shard.lock.Unlock()
+	}
+}
+
+// restoredInfo returns a copy of the restored price info of a named registration, if any.
+func (p *PriceEventHandler) restoredInfo(name string, priceKey, publisher solana.PublicKey) *PriceInfo {
+	p.restoreLock.Lock()
+	defer p.restoreLock.Unlock()
+	e, ok := p.restored[name]
+	if !ok || e.PriceKey != priceKey || e.Publisher != publisher {
+		return nil
+	}
+	info := e.Info()
+	return &info
+}
@@ -0,0 +1,106 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testCheckpoint = HandlerCheckpoint{
+	LatestSlot: 1234,
+	Entries: []CheckpointEntry{
+		{
+			Name:     "btc",
+			PriceKey: testPriceKey,
+			Price:    -5,
+			Conf:     6,
+			Status:   PriceStatusTrading,
+			PubSlot:  1230,
+		},
+		{
+			Name:      "btc/publisher",
+			PriceKey:  testPriceKey,
+			Publisher: solana.PublicKey{1, 2, 3},
+			CorpAct:   1,
+		},
+	},
+}
+
+func TestHandlerCheckpoint_Binary(t *testing.T) {
+	buf, err := testCheckpoint.MarshalBinary()
+	require.NoError(t, err)
+
+	var decoded HandlerCheckpoint
+	require.NoError(t, decoded.UnmarshalBinary(buf))
+	assert.Equal(t, testCheckpoint, decoded)
+
+	assert.EqualError(t, decoded.UnmarshalBinary(buf[:len(buf)-1]), "checkpoint truncated")
+	assert.EqualError(t, decoded.UnmarshalBinary(append(buf, 0)), "1 trailing bytes after checkpoint")
+	assert.EqualError(t, decoded.UnmarshalBinary([]byte("PYTHREC\x01")), "not a handler checkpoint")
+}
+
+func TestHandlerCheckpoint_JSON(t *testing.T) {
+	buf, err := json.Marshal(&testCheckpoint)
+	require.NoError(t, err)
+
+	var decoded HandlerCheckpoint
+	require.NoError(t, json.Unmarshal(buf, &decoded))
+	assert.Equal(t, testCheckpoint, decoded)
+}
+
+func TestPriceEventHandler_WarmRestart(t *testing.T) {
+	// First run.
+	src := newTestSource()
+	handler := NewPriceEventHandler(src)
+	handler.OnPriceChangeWithOpts(testPriceKey, &CallbackOpts{Name: "btc"}, func(PriceUpdate) {})
+	handler.OnPriceChange(testPriceKey, func(PriceUpdate) {}) // unnamed, not checkpointed
+	src.send(testPriceKey, 100, 10)
+	src.Close()
+	require.NoError(t, handler.Wait())
+
+	cp := handler.Checkpoint()
+	require.Len(t, cp.Entries, 1)
+	assert.Equal(t, "btc", cp.Entries[0].Name)
+	assert.Equal(t, uint64(10), cp.LatestSlot)
+	assert.Equal(t, uint64(10), cp.Entries[0].PubSlot)
+
+	// Second run resumes from the checkpoint.
+	src = newTestSource()
+	handler = NewPriceEventHandler(src)
+	handler.Restore(cp)
+	assert.Equal(t, uint64(10), handler.LatestSlot())
+
+	var updates []PriceUpdate
+	handler.OnPriceChangeWithOpts(testPriceKey, &CallbackOpts{Name: "btc"}, func(update PriceUpdate) {
+		updates = append(updates, update)
+	})
+	var unnamed int
+	handler.OnPriceChange(testPriceKey, func(PriceUpdate) { unnamed++ })
+
+	src.send(testPriceKey, 100, 10) // unchanged since checkpoint
+	src.send(testPriceKey, 101, 11)
+	src.Close()
+	require.NoError(t, handler.Wait())
+
+	require.Len(t, updates, 1)
+	assert.Equal(t, uint64(10), updates[0].PreviousInfo.PubSlot)
+	assert.Equal(t, int64(100), updates[0].PreviousInfo.Price)
+	assert.Equal(t, 2, unnamed)
+}
@@ -0,0 +1,152 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+// PriceAccountCoalescer keeps only the latest update of each price account.
+//
+// Producers never block when adding updates.
+// Consumers wait for Ready and then Drain the "dirty set" of accounts
+// that changed since the last drain, skipping any intermediate updates.
+// Updates never go backwards: an update below the slot of the last drained update
+// of the same account is dropped, as is a repetition of the last drained update.
+type PriceAccountCoalescer struct {
+	lock      sync.Mutex
+	dirty     map[solana.PublicKey]PriceAccountEntry
+	delivered map[solana.PublicKey]PriceAccountEntry // last drained update of each account
+	ready     chan struct{}                          // holds a token while the dirty set is non-empty
+	closed    chan struct{}
+	once      sync.Once
+}
+
+// NewPriceAccountCoalescer creates an empty coalescer.
+func NewPriceAccountCoalescer() *PriceAccountCoalescer {
+	return &PriceAccountCoalescer{
+		dirty:     make(map[solana.PublicKey]PriceAccountEntry),
+		delivered: make(map[solana.PublicKey]PriceAccountEntry),
+		ready:     make(chan struct{}, 1),
+		closed:    make(chan struct{}),
+	}
+}
+
+// Coalesce consumes all updates of a source into a new coalescer.
+//
+// The coalescer is closed once the source ends.
+func Coalesce(src PriceAccountSource) *PriceAccountCoalescer {
+	c := NewPriceAccountCoalescer()
+	go func() {
+		defer c.Close()
+		for update := range src.Updates() {
+			c.Put(update)
+		}
+	}()
+	return c
+}
+
+// Put records an update, replacing any pending update of the same account.
+//
+// Updates older than the pending one or the last drained one are ignored,
+// as are updates equal to the last drained one.
+// Another write to the account in the slot of the last drained update is recorded.
+func (c *PriceAccountCoalescer) Put(entry PriceAccountEntry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if prev, ok := c.dirty[entry.Pubkey]; ok && prev.Slot > entry.Slot {
+		return
+	}
+	if last, ok := c.delivered[entry.Pubkey]; ok {
+		if entry.Slot < last.Slot || (entry.Slot == last.Slot && sameAccountData(entry, last)) {
+			return
+		}
+	}
+	c.dirty[entry.Pubkey] = entry
+	select {
+	case c.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Ready returns a channel that receives a value whenever there are pending updates.
+func (c *PriceAccountCoalescer) Ready() <-chan struct{} {
+	return c.ready
+}
+
+// Done returns a channel that is closed when no more updates will be added.
+func (c *PriceAccountCoalescer) Done() <-chan struct{} {
+	return c.closed
+}
+
+// Len returns the number of accounts with pending updates.
+func (c *PriceAccountCoalescer) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.dirty)
+}
+
+// Drain returns the latest pending update of each account and clears the dirty set.
+//
+// Updates are ordered by slot, then by pubkey.
+func (c *PriceAccountCoalescer) Drain() []PriceAccountEntry {
+	c.lock.Lock()
+	dirty := c.dirty
+	c.dirty = make(map[solana.PublicKey]PriceAccountEntry, len(dirty))
+	for key, entry := range dirty {
+		c.delivered[key] = entry
+	}
+	select {
+	case <-c.ready:
+	default:
+	}
+	c.lock.Unlock()
+
+	entries := make([]PriceAccountEntry, 0, len(dirty))
+	for _, entry := range dirty {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Slot != entries[j].Slot {
+			return entries[i].Slot < entries[j].Slot
+		}
+		return bytes.Compare(entries[i].Pubkey[:], entries[j].Pubkey[:]) < 0
+	})
+	return entries
+}
+
+// Close signals consumers that no more updates will be added.
+//
+// Pending updates can still be drained after closing.
+func (c *PriceAccountCoalescer) Close() {
+	c.once.Do(func() {
+		close(c.closed)
+	})
+}
+
+// sameAccountData reports whether two updates carry the same account contents.
+func sameAccountData(a, b PriceAccountEntry) bool {
+	if a.Raw != nil && b.Raw != nil {
+		return bytes.Equal(a.Raw, b.Raw)
+	}
+	if a.PriceAccount == nil || b.PriceAccount == nil {
+		return a.PriceAccount == b.PriceAccount
+	}
+	return *a.PriceAccount == *b.PriceAccount
+}
@@ -0,0 +1,123 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriceAccountCoalescer(t *testing.T) {
+	keyA := solana.MustPublicKeyFromBase58("E36MyBbavhYKHVLWR79GiReNNnBDiHj6nWA7htbkNZbh")
+	keyB := solana.MustPublicKeyFromBase58("EdVCmQ9FSPcVe5YySXDPCRmc8aDQLKJ9xvYBMZPie1Vw")
+
+	c := NewPriceAccountCoalescer()
+	select {
+	case <-c.Ready():
+		t.Fatal("empty coalescer must not be ready")
+	default:
+	}
+
+	c.Put(PriceAccountEntry{Pubkey: keyA, Slot: 10})
+	c.Put(PriceAccountEntry{Pubkey: keyA, Slot: 12})
+	c.Put(PriceAccountEntry{Pubkey: keyA, Slot: 11}) // out of order, ignored
+	c.Put(PriceAccountEntry{Pubkey: keyB, Slot: 11})
+	assert.Equal(t, 2, c.Len())
+
+	<-c.Ready()
+	assert.Equal(t, []PriceAccountEntry{
+		{Pubkey: keyB, Slot: 11},
+		{Pubkey: keyA, Slot: 12},
+	}, c.Drain())
+	assert.Equal(t, 0, c.Len())
+	assert.Empty(t, c.Drain())
+
+	select {
+	case <-c.Ready():
+		t.Fatal("drained coalescer must not be ready")
+	default:
+	}
+
+	c.Close()
+	c.Close()
+	<-c.Done()
+}
+
+func TestPriceAccountCoalescer_DrainedSlot(t *testing.T) {
+	key := solana.MustPublicKeyFromBase58("E36MyBbavhYKHVLWR79GiReNNnBDiHj6nWA7htbkNZbh")
+	c := NewPriceAccountCoalescer()
+
+	c.Put(PriceAccountEntry{Pubkey: key, Slot: 12})
+	assert.Equal(t, []PriceAccountEntry{{Pubkey: key, Slot: 12}}, c.Drain())
+
+	// Late updates must not make the price go backwards.
+	c.Put(PriceAccountEntry{Pubkey: key, Slot: 11})
+	c.Put(PriceAccountEntry{Pubkey: key, Slot: 12})
+	assert.Equal(t, 0, c.Len())
+	select {
+	case <-c.Ready():
+		t.Fatal("coalescer must not be ready after dropped updates")
+	default:
+	}
+
+	c.Put(PriceAccountEntry{Pubkey: key, Slot: 13})
+	assert.Equal(t, []PriceAccountEntry{{Pubkey: key, Slot: 13}}, c.Drain())
+}
+
+func TestPriceAccountCoalescer_SameSlot(t *testing.T) {
+	key := solana.MustPublicKeyFromBase58("E36MyBbavhYKHVLWR79GiReNNnBDiHj6nWA7htbkNZbh")
+	c := NewPriceAccountCoalescer()
+
+	first := PriceAccountEntry{Pubkey: key, Slot: 12, PriceAccount: &PriceAccount{Agg: PriceInfo{Price: 1}}}
+	c.Put(first)
+	assert.Equal(t, []PriceAccountEntry{first}, c.Drain())
+
+	// Repeating the drained update is dropped.
+	c.Put(PriceAccountEntry{Pubkey: key, Slot: 12, PriceAccount: &PriceAccount{Agg: PriceInfo{Price: 1}}})
+	assert.Equal(t, 0, c.Len())
+
+	// Another write in the same slot, such as the aggregation after several updates, is kept.
+	second := PriceAccountEntry{Pubkey: key, Slot: 12, PriceAccount: &PriceAccount{Agg: PriceInfo{Price: 2}}}
+	c.Put(second)
+	assert.Equal(t, []PriceAccountEntry{second}, c.Drain())
+
+	// Raw data is compared if known.
+	c.Put(PriceAccountEntry{Pubkey: key, Slot: 13, Raw: []byte{1}})
+	c.Drain()
+	c.Put(PriceAccountEntry{Pubkey: key, Slot: 13, Raw: []byte{1}})
+	assert.Equal(t, 0, c.Len())
+	c.Put(PriceAccountEntry{Pubkey: key, Slot: 13, Raw: []byte{2}})
+	assert.Equal(t, 1, c.Len())
+}
+
+func TestCoalesce(t *testing.T) {
+	recording := makeRecording(t, 50, time.Second)
+	replayer, err := NewReplayer(bytes.NewReader(recording), ReplayOpts{Speed: ReplayAsFastAsPossible})
+	require.NoError(t, err)
+
+	c := Coalesce(replayer)
+	<-c.Done()
+	require.NoError(t, replayer.Err())
+
+	entries := c.Drain()
+	require.Len(t, entries, 1)
+	assert.Equal(t, uint64(117491536), entries[0].Slot)
+	assert.Equal(t, int64(112766), entries[0].Agg.Price)
+}
@@ -0,0 +1,294 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	bin "github.com/gagliardetto/binary"
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc/jsonrpc"
+)
+
+// ProgramError is a custom error code returned by the Pyth on-chain program.
+//
+// Use errors.Is to check for specific errors, e.g. errors.Is(err, ErrPermissionViolation).
+type ProgramError uint32
+
+// Pyth program errors.
+const (
+	ErrGeneric ProgramError = 600 + iota
+	ErrUnrecognizedInstruction
+	ErrInstructionDataTooShort
+	ErrInstructionDataSliceMisaligned
+	ErrAccountTooSmall
+	ErrDeserializationError
+	ErrFailedAuthenticatingUpgradeAuthority
+	ErrInvalidUpgradeAuthority
+	ErrInvalidFundingAccount
+	ErrInvalidSignableAccount
+	ErrInvalidSystemAccount
+	ErrInvalidWritableAccount
+	ErrInvalidFreshAccount
+	ErrInvalidInstructionVersion
+	ErrInvalidAccountHeader
+	ErrPermissionViolation // publisher is not permitted to publish to the price account
+	ErrNeedsSuccesfulAggregation
+	ErrMaxLastFeedIndexReached
+	ErrFeedIndexAlreadyInitialized
+	programErrorEnd // end of known program errors
+)
+
+var programErrorNames = [...]string{
+	"Generic",
+	"UnrecognizedInstruction",
+	"InstructionDataTooShort",
+	"InstructionDataSliceMisaligned",
+	"AccountTooSmall",
+	"DeserializationError",
+	"FailedAuthenticatingUpgradeAuthority",
+	"InvalidUpgradeAuthority",
+	"InvalidFundingAccount",
+	"InvalidSignableAccount",
+	"InvalidSystemAccount",
+	"InvalidWritableAccount",
+	"InvalidFreshAccount",
+	"InvalidInstructionVersion",
+	"InvalidAccountHeader",
+	"PermissionViolation",
+	"NeedsSuccesfulAggregation",
+	"MaxLastFeedIndexReached",
+	"FeedIndexAlreadyInitialized",
+}
+
+// Name returns the name of the error in the Pyth program.
+func (e ProgramError) Name() string {
+	if e < ErrGeneric || e >= programErrorEnd {
+		return fmt.Sprintf("Unknown(%d)", uint32(e))
+	}
+	return programErrorNames[e-ErrGeneric]
+}
+
+// Error returns the name and code of the error.
+func (e ProgramError) Error() string {
+	return fmt.Sprintf("pyth: %s (custom program error 0x%x)", e.Name(), uint32(e))
+}
+
+// CustomError is a custom error code of a program other than Pyth, or outside of the known Pyth errors.
+type CustomError uint32
+
+// Error returns the code of the error.
+func (e CustomError) Error() string {
+	return fmt.Sprintf("custom program error 0x%x", uint32(e))
+}
+
+// BuiltinError is an instruction error of the Solana runtime, such as "InvalidArgument".
+type BuiltinError string
+
+// Error returns the name of the error.
+func (e BuiltinError) Error() string {
+	return string(e)
+}
+
+// TransactionError is a failure of a transaction not caused by a specific instruction,
+// such as "BlockhashNotFound".
+type TransactionError string
+
+// Error returns the name of the error.
+func (e TransactionError) Error() string {
+	return "transaction error: " + string(e)
+}
+
+// InstructionError is the failure of an instruction of a transaction.
+type InstructionError struct {
+	Index   int              // index of the instruction in the transaction
+	Program solana.PublicKey // program of the instruction, zero if unknown
+	Command int32            // Pyth instruction type, -1 if not a Pyth instruction or unknown
+	Err     error            // ProgramError, CustomError or BuiltinError
+	Logs    []string         // log messages of the instruction, if known
+}
+
+// Error describes the failed instruction and the cause.
+func (e *InstructionError) Error() string {
+	if e.Command >= 0 {
+		return fmt.Sprintf("instruction %d (%s) failed: %s", e.Index, InstructionIDToName(e.Command), e.Err)
+	}
+	return fmt.Sprintf("instruction %d failed: %s", e.Index, e.Err)
+}
+
+// Unwrap returns the cause.
+func (e *InstructionError) Unwrap() error {
+	return e.Err
+}
+
+// IsStaleSlot returns whether a price update was rejected
+// because the publisher already published at the same or a later slot.
+//
+// The Pyth program reports stale updates with the builtin InvalidArgument error.
+func IsStaleSlot(err error) bool {
+	var insErr *InstructionError
+	if !errors.As(err, &insErr) {
+		return false
+	}
+	if insErr.Command != Instruction_UpdPrice && insErr.Command != Instruction_UpdPriceNoFailOnError {
+		return false
+	}
+	return errors.Is(insErr.Err, BuiltinError("InvalidArgument"))
+}
+
+// ParseTransactionError converts the "err" field of a transaction status or simulation result,
+// as decoded from JSON, to a typed error.
+//
+// Instruction errors are returned as *InstructionError, with Program and Command unknown.
+// Returns nil if status is nil.
+func ParseTransactionError(status interface{}) error {
+	switch value := status.(type) {
+	case nil:
+		return nil
+	case string:
+		return TransactionError(value)
+	case map[string]interface{}:
+		if detail, ok := value["InstructionError"]; ok {
+			if insErr := parseInstructionError(detail); insErr != nil {
+				return insErr
+			}
+		}
+		// Other errors carry details, such as {"InsufficientFundsForRent": {"account_index": 1}}.
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return TransactionError(strings.Join(keys, ","))
+	default:
+		return TransactionError(fmt.Sprint(status))
+	}
+}
+
+// parseInstructionError parses the [index, error] pair of an InstructionError.
+func parseInstructionError(detail interface{}) *InstructionError {
+	pair, ok := detail.([]interface{})
+	if !ok || len(pair) != 2 {
+		return nil
+	}
+	index, ok := jsonUint(pair[0])
+	if !ok {
+		return nil
+	}
+	insErr := &InstructionError{Index: int(index), Command: -1}
+	switch cause := pair[1].(type) {
+	case string:
+		insErr.Err = BuiltinError(cause)
+	case map[string]interface{}:
+		if custom, ok := cause["Custom"]; ok {
+			if code, ok := jsonUint(custom); ok {
+				insErr.Err = customError(uint32(code))
+				break
+			}
+		}
+		// Builtin errors with details, such as {"BorshIoError": "Unknown"}.
+		// Objects with several keys are unexpected and formatted as a whole below.
+		if len(cause) == 1 {
+			for name, value := range cause {
+				insErr.Err = BuiltinError(fmt.Sprintf("%s: %v", name, value))
+			}
+		}
+	}
+	if insErr.Err == nil {
+		insErr.Err = BuiltinError(fmt.Sprint(pair[1]))
+	}
+	return insErr
+}
+
+// customError returns the Pyth error of known codes, or a CustomError.
+func customError(code uint32) error {
+	if code >= uint32(ErrGeneric) && code < uint32(programErrorEnd) {
+		return ProgramError(code)
+	}
+	return CustomError(code)
+}
+
+func jsonUint(v interface{}) (uint64, bool) {
+	switch n := v.(type) {
+	case float64:
+		if n < 0 {
+			return 0, false
+		}
+		return uint64(n), true
+	case json.Number:
+		i, err := n.Int64()
+		return uint64(i), err == nil && i >= 0
+	default:
+		return 0, false
+	}
+}
+
+// DecodeError extracts the typed transaction error from a failed sendTransaction preflight check.
+//
+// If the transaction is given, the failed instruction is resolved to its program and Pyth instruction type.
+// Errors other than preflight failures are returned unchanged.
+func (c *Client) DecodeError(err error, tx *solana.Transaction) error {
+	var rpcErr *jsonrpc.RPCError
+	if !errors.As(err, &rpcErr) {
+		return err
+	}
+	data, ok := rpcErr.Data.(map[string]interface{})
+	if !ok || data["err"] == nil {
+		return err
+	}
+	var logs []string
+	if rawLogs, ok := data["logs"].([]interface{}); ok {
+		for _, line := range rawLogs {
+			if s, ok := line.(string); ok {
+				logs = append(logs, s)
+			}
+		}
+	}
+	return c.resolveTransactionError(ParseTransactionError(data["err"]), tx, logs)
+}
+
+// resolveTransactionError adds the details of the transaction and logs to an instruction error.
+func (c *Client) resolveTransactionError(err error, tx *solana.Transaction, logs []string) error {
+	var insErr *InstructionError
+	if !errors.As(err, &insErr) {
+		return err
+	}
+	insErr.Logs = instructionLogs(logs, insErr.Index)
+	if tx == nil || insErr.Index >= len(tx.Message.Instructions) {
+		return err
+	}
+	compiled := tx.Message.Instructions[insErr.Index]
+	program, progErr := tx.Message.Program(compiled.ProgramIDIndex)
+	if progErr != nil {
+		return err
+	}
+	insErr.Program = program
+	if program != c.Env.Program {
+		// Custom codes of other programs do not share the meaning of Pyth errors.
+		if code, ok := insErr.Err.(ProgramError); ok {
+			insErr.Err = CustomError(code)
+		}
+		return err
+	}
+	var header CommandHeader
+	if bin.NewBinDecoder(compiled.Data).Decode(&header) == nil && header.Valid() {
+		insErr.Command = header.Cmd
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc/jsonrpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgramError(t *testing.T) {
+	assert.Equal(t, ProgramError(615), ErrPermissionViolation)
+	assert.Equal(t, ProgramError(618), ErrFeedIndexAlreadyInitialized)
+	assert.Equal(t, "pyth: PermissionViolation (custom program error 0x267)", ErrPermissionViolation.Error())
+	assert.Equal(t, "Unknown(700)", ProgramError(700).Name())
+}
+
+func TestParseTransactionError(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string
+		err    error
+	}{
+		{"Nil", `null`, nil},
+		{"Transaction", `"BlockhashNotFound"`, TransactionError("BlockhashNotFound")},
+		{"TransactionDetails", `{"InsufficientFundsForRent": {"account_index": 1}}`, TransactionError("InsufficientFundsForRent")},
+		{"Pyth", `{"InstructionError": [1, {"Custom": 615}]}`,
+			&InstructionError{Index: 1, Command: -1, Err: ErrPermissionViolation}},
+		{"Custom", `{"InstructionError": [0, {"Custom": 1}]}`,
+			&InstructionError{Index: 0, Command: -1, Err: CustomError(1)}},
+		{"Builtin", `{"InstructionError": [2, "InvalidArgument"]}`,
+			&InstructionError{Index: 2, Command: -1, Err: BuiltinError("InvalidArgument")}},
+		{"BuiltinDetails", `{"InstructionError": [0, {"BorshIoError": "Unknown"}]}`,
+			&InstructionError{Index: 0, Command: -1, Err: BuiltinError("BorshIoError: Unknown")}},
+		{"CustomFirst", `{"InstructionError": [0, {"BorshIoError": "Unknown", "Custom": 1}]}`,
+			&InstructionError{Index: 0, Command: -1, Err: CustomError(1)}},
+		{"SeveralDetails", `{"InstructionError": [0, {"B": 2, "A": 1}]}`,
+			&InstructionError{Index: 0, Command: -1, Err: BuiltinError("map[A:1 B:2]")}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var status interface{}
+			require.NoError(t, json.Unmarshal([]byte(tc.status), &status))
+			assert.Equal(t, tc.err, ParseTransactionError(status))
+		})
+	}
+}
+
+func TestClient_DecodeError(t *testing.T) {
+	client := NewClient(Devnet, "http://localhost:1", "ws://localhost:1")
+	publisher := solana.NewWallet().PublicKey()
+	tx, err := solana.NewTransaction([]solana.Instruction{
+		NewInstructionBuilder(Devnet.Program).UpdPrice(publisher, testPriceKey, CommandUpdPrice{}),
+	}, testBlockhash, solana.TransactionPayer(publisher))
+	require.NoError(t, err)
+
+	var preflight interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"err": {"InstructionError": [0, "InvalidArgument"]},
+		"logs": [
+			"Program `+Devnet.Program.String()+` invoke [1]",
+			"Program `+Devnet.Program.String()+` failed: invalid program argument"
+		]
+	}`), &preflight))
+	decoded := client.DecodeError(&jsonrpc.RPCError{
+		Code:    -32002,
+		Message: "Transaction simulation failed",
+		Data:    preflight,
+	}, tx)
+	assert.EqualError(t, decoded, "instruction 0 (upd_price) failed: InvalidArgument")
+	assert.True(t, IsStaleSlot(decoded))
+	assert.False(t, errors.Is(decoded, ErrPermissionViolation))
+	var insErr *InstructionError
+	require.True(t, errors.As(decoded, &insErr))
+	assert.Equal(t, Devnet.Program, insErr.Program)
+	assert.Len(t, insErr.Logs, 2)
+
+	other := errors.New("connection refused")
+	assert.Same(t, other, client.DecodeError(other, tx))
+}
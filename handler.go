@@ -15,33 +15,182 @@
 package pyth
 
 import (
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gagliardetto/solana-go"
 	"github.com/shopspring/decimal"
 )
 
 // PriceEventHandler provides a callback-style interface to Pyth updates.
+//
+// Callbacks are dispatched asynchronously by a pool of workers.
+// All callbacks relating to the same price account are called in order by the same worker.
+// Callbacks may safely register new callbacks or unsubscribe existing ones.
 type PriceEventHandler struct {
-	stream *PriceAccountStream
+	stream PriceAccountSource
+	opts   HandlerOpts
+	done   chan struct{} // closed when all workers and background tasks have exited
+	pooled bool          // whether price accounts must be returned to the pool
+
+	stopped    chan struct{}  // closed when all workers have exited
+	bgLock     sync.Mutex     // lock over starting background tasks
+	bgTasks    sync.WaitGroup // background tasks such as the staleness watchdog
+	bgStarted  map[string]bool
+	latestSlot uint64 // atomic, highest slot of any update
+
+	staleLock    sync.Mutex // lock over staleWatches
+	staleWatches map[*staleWatch]struct{}
+	tipSlot      uint64 // atomic, latest slot of the stream's slot subscription
+
+	slotLock      sync.Mutex // lock over slotCallbacks and slots
+	slotCallbacks map[uint64]func(SlotBatch)
+	slotQueue     chan SlotBatch
+	slots         *slotBatcher
+
+	restoreLock sync.Mutex // lock over restored
+	restored    map[string]CheckpointEntry
+
+	subsLock      sync.RWMutex // lock over subscriptions and symbols
+	subscriptions map[uint64]subscriber
+	subsClosed    bool
+	symbols       map[solana.PublicKey]string // product key to symbol
+
+	regNonce uint64 // atomic
+	shards   [handlerShards]handlerShard
+	workers  []chan dispatchItem
+}
+
+// handlerShards is the number of independently locked partitions of registered callbacks.
+const handlerShards = 16
+
+// handlerShard holds the callbacks of a subset of price accounts.
+type handlerShard struct {
+	lock      sync.Mutex // lock over the callbacks map
+	callbacks map[solana.PublicKey]priceCallbacks
+}
 
-	callbacksLock sync.Mutex // lock over the callbacks map
-	regNonce      uint64
-	callbacks     map[solana.PublicKey]priceCallbacks
+// dispatchItem is a price account update queued for a worker.
+type dispatchItem struct {
+	priceKey solana.PublicKey
+	acc      *PriceAccount
+}
+
+// HandlerOpts configures a PriceEventHandler.
+type HandlerOpts struct {
+	// Workers is the number of goroutines dispatching callbacks.
+	// Callbacks of different price accounts may run concurrently if greater than one.
+	// Defaults to 1.
+	Workers int
+	// QueueSize is the number of updates each worker can buffer
+	// before the handler stops reading from the stream. Defaults to 64.
+	QueueSize int
+	// OnPanic is called with the recovered value if a callback panics.
+	// The handler keeps running afterwards.
+	// The priceKey is zero for panics in slot callbacks.
+	// If nil, panics are recovered and dropped.
+	OnPanic func(priceKey solana.PublicKey, recovered interface{})
+	// StaleCheckInterval is how often staleness conditions are evaluated. Defaults to 1 second.
+	StaleCheckInterval time.Duration
+	// SlotTimeout is how long OnSlot batches are held back waiting for a newer slot.
+	// Defaults to 1 second.
+	SlotTimeout time.Duration
 }
 
 // NewPriceEventHandler creates a new event handler over the stream.
 //
+// The stream is usually a *PriceAccountStream, but any PriceAccountSource works,
+// such as a *Replayer reading from a recording.
+//
 // A stream must not be re-used between event handlers.
-func NewPriceEventHandler(stream *PriceAccountStream) *PriceEventHandler {
+func NewPriceEventHandler(stream PriceAccountSource) *PriceEventHandler {
+	return NewPriceEventHandlerWithOpts(stream, nil)
+}
+
+// NewPriceEventHandlerWithOpts creates a new event handler over the stream with custom options.
+//
+// The opts may be nil.
+func NewPriceEventHandlerWithOpts(stream PriceAccountSource, opts *HandlerOpts) *PriceEventHandler {
+	if opts == nil {
+		opts = new(HandlerOpts)
+	}
 	handler := &PriceEventHandler{
-		stream:    stream,
-		callbacks: make(map[solana.PublicKey]priceCallbacks),
+		stream: stream,
+		opts:   *opts,
+		done:   make(chan struct{}),
+
+		stopped:   make(chan struct{}),
+		bgStarted: make(map[string]bool),
+	}
+	if handler.opts.Workers <= 0 {
+		handler.opts.Workers = 1
+	}
+	if handler.opts.QueueSize <= 0 {
+		handler.opts.QueueSize = 64
+	}
+	if handler.opts.StaleCheckInterval <= 0 {
+		handler.opts.StaleCheckInterval = defaultStaleCheckInterval
 	}
-	go handler.consume(stream.Updates())
+	for i := range handler.shards {
+		handler.shards[i].callbacks = make(map[solana.PublicKey]priceCallbacks)
+	}
+	if s, ok := stream.(*PriceAccountStream); ok {
+		handler.pooled = s.pooled()
+	}
+
+	var wg sync.WaitGroup
+	handler.workers = make([]chan dispatchItem, handler.opts.Workers)
+	for i := range handler.workers {
+		queue := make(chan dispatchItem, handler.opts.QueueSize)
+		handler.workers[i] = queue
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler.work(queue)
+		}()
+	}
+	go func() {
+		handler.consume(stream.Updates())
+		wg.Wait()
+		handler.closeSubscriptions()
+		handler.bgLock.Lock()
+		close(handler.stopped)
+		handler.bgLock.Unlock()
+		handler.bgTasks.Wait()
+		close(handler.done)
+	}()
 	return handler
 }
 
+// startBackground runs a named background task once, until the handler stops.
+//
+// The task must return once the stopped channel is closed.
+func (p *PriceEventHandler) startBackground(name string, task func(stopped <-chan struct{})) {
+	p.bgLock.Lock()
+	defer p.bgLock.Unlock()
+	if p.bgStarted[name] {
+		return
+	}
+	select {
+	case <-p.stopped:
+		return
+	default:
+	}
+	p.bgStarted[name] = true
+	p.bgTasks.Add(1)
+	go func() {
+		defer p.bgTasks.Done()
+		task(p.stopped)
+	}()
+}
+
+// LatestSlot returns the highest slot of any update processed by the handler.
+func (p *PriceEventHandler) LatestSlot() uint64 {
+	return atomic.LoadUint64(&p.latestSlot)
+}
+
 // Err returns the reason why the underlying price account stream is closed.
 //
 // Will block until the stream has actually closed.
@@ -50,38 +199,98 @@ func NewPriceEventHandler(stream *PriceAccountStream) *PriceEventHandler {
 // After this function returns the event handler will not send any more callbacks.
 // You could use this function as a barrier for any cleanup tasks relating to callbacks.
 func (p *PriceEventHandler) Err() error {
+	<-p.done
 	return p.stream.Err()
 }
 
+// Wait blocks until the underlying stream has closed and all callbacks have returned.
+//
+// Returns the same error as Err.
+func (p *PriceEventHandler) Wait() error {
+	return p.Err()
+}
+
+// Close closes the underlying stream and waits until all callbacks have returned.
+//
+// Close must not be called from within a callback.
+func (p *PriceEventHandler) Close() {
+	p.stream.Close()
+	<-p.done
+}
+
 // OnPriceChange registers a callback function to be called
 // whenever the aggregate price of the provided price account changes.
 func (p *PriceEventHandler) OnPriceChange(priceKey solana.PublicKey, callback func(PriceUpdate)) CallbackHandle {
-	p.callbacksLock.Lock()
-	defer p.callbacksLock.Unlock()
-	return p.getPriceCallbacks(priceKey).onPrice.register(p, callback)
+	return p.OnPriceChangeWithPolicy(priceKey, ChangeStatusOrSlot, callback)
+}
+
+// OnPriceChangeWithPolicy is like OnPriceChange, using a custom change detection policy.
+func (p *PriceEventHandler) OnPriceChangeWithPolicy(priceKey solana.PublicKey, policy ChangePolicy, callback func(PriceUpdate)) CallbackHandle {
+	return p.OnPriceChangeWithOpts(priceKey, &CallbackOpts{Policy: policy}, callback)
+}
+
+// OnPriceChangeWithOpts is like OnPriceChange, with custom registration options.
+//
+// The opts may be nil.
+func (p *PriceEventHandler) OnPriceChangeWithOpts(priceKey solana.PublicKey, opts *CallbackOpts, callback func(PriceUpdate)) CallbackHandle {
+	shard := p.shard(priceKey)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	return shard.getPriceCallbacks(priceKey).onPrice.register(p, shard, priceKey, solana.PublicKey{}, opts, callback)
 }
 
 // OnComponentChange registers a callback function to be called
 // whenever the price component of the given (price account, publisher account) pair changes.
 func (p *PriceEventHandler) OnComponentChange(priceKey solana.PublicKey, publisher solana.PublicKey, callback func(PriceUpdate)) CallbackHandle {
-	p.callbacksLock.Lock()
-	defer p.callbacksLock.Unlock()
-	return p.getComponentCallbacks(priceKey, publisher).register(p, callback)
+	return p.OnComponentChangeWithPolicy(priceKey, publisher, ChangeStatusOrSlot, callback)
 }
 
-func (p *PriceEventHandler) getPriceCallbacks(priceKey solana.PublicKey) priceCallbacks {
+// OnComponentChangeWithPolicy is like OnComponentChange, using a custom change detection policy.
+func (p *PriceEventHandler) OnComponentChangeWithPolicy(priceKey solana.PublicKey, publisher solana.PublicKey, policy ChangePolicy, callback func(PriceUpdate)) CallbackHandle {
+	return p.OnComponentChangeWithOpts(priceKey, publisher, &CallbackOpts{Policy: policy}, callback)
+}
+
+// OnComponentChangeWithOpts is like OnComponentChange, with custom registration options.
+//
+// The opts may be nil.
+func (p *PriceEventHandler) OnComponentChangeWithOpts(priceKey solana.PublicKey, publisher solana.PublicKey, opts *CallbackOpts, callback func(PriceUpdate)) CallbackHandle {
+	shard := p.shard(priceKey)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	return shard.getComponentCallbacks(priceKey, publisher).register(p, shard, priceKey, publisher, opts, callback)
+}
+
+// onAccountUpdate registers a callback function to be called on every update of the provided price account.
+func (p *PriceEventHandler) onAccountUpdate(priceKey solana.PublicKey, callback func(PriceUpdate)) CallbackHandle {
+	shard := p.shard(priceKey)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	return shard.getPriceCallbacks(priceKey).onPrice.register(p, shard, priceKey, solana.PublicKey{}, &CallbackOpts{Policy: changeAlways}, callback)
+}
+
+// shardIndex maps a price account to its shard and worker.
+func shardIndex(priceKey solana.PublicKey) int {
+	// Pubkeys are uniformly distributed, so any byte is a good hash.
+	return int(priceKey[0]^priceKey[31]) % handlerShards
+}
+
+func (p *PriceEventHandler) shard(priceKey solana.PublicKey) *handlerShard {
+	return &p.shards[shardIndex(priceKey)]
+}
+
+func (s *handlerShard) getPriceCallbacks(priceKey solana.PublicKey) priceCallbacks {
 	// requires lock
-	res, ok := p.callbacks[priceKey]
+	res, ok := s.callbacks[priceKey]
 	if !ok {
 		res.init()
-		p.callbacks[priceKey] = res
+		s.callbacks[priceKey] = res
 	}
 	return res
 }
 
-func (p *PriceEventHandler) getComponentCallbacks(priceKey solana.PublicKey, publisherKey solana.PublicKey) callbackMap {
+func (s *handlerShard) getComponentCallbacks(priceKey solana.PublicKey, publisherKey solana.PublicKey) callbackMap {
 	// requires lock
-	price := p.getPriceCallbacks(priceKey)
+	price := s.getPriceCallbacks(priceKey)
 	res, ok := price.componentCallbacks[publisherKey]
 	if !ok {
 		res = make(callbackMap)
@@ -91,28 +300,107 @@ func (p *PriceEventHandler) getComponentCallbacks(priceKey solana.PublicKey, pub
 }
 
 func (p *PriceEventHandler) consume(updates <-chan PriceAccountEntry) {
+	defer func() {
+		if slots := p.slotBatcher(); slots != nil {
+			slots.close()
+		}
+		for _, queue := range p.workers {
+			close(queue)
+		}
+	}()
 	for update := range updates {
-		p.processUpdate(update.Pubkey, update.PriceAccount)
+		if update.Slot > atomic.LoadUint64(&p.latestSlot) {
+			atomic.StoreUint64(&p.latestSlot, update.Slot)
+		}
+		if slots := p.slotBatcher(); slots != nil {
+			entry := update
+			if p.pooled {
+				// Pooled accounts are released by the workers.
+				acc := *update.PriceAccount
+				entry.PriceAccount = &acc
+			}
+			slots.add(entry)
+		}
+		// Updates of the same price account always go to the same worker to preserve ordering.
+		queue := p.workers[shardIndex(update.Pubkey)%len(p.workers)]
+		metricsDispatchQueueDepth.Inc()
+		queue <- dispatchItem{
+			priceKey: update.Pubkey,
+			acc:      update.PriceAccount,
+		}
 	}
 }
 
-func (p *PriceEventHandler) processUpdate(priceKey solana.PublicKey, acc *PriceAccount) {
-	p.callbacksLock.Lock()
-	defer p.callbacksLock.Unlock()
+// retainable returns an account that may be kept after the callback returns,
+// which is a copy if the stream pools accounts.
+func (p *PriceEventHandler) retainable(acc *PriceAccount) *PriceAccount {
+	if !p.pooled || acc == nil {
+		return acc
+	}
+	accCopy := *acc
+	return &accCopy
+}
 
-	callbacks := p.callbacks[priceKey]
+func (p *PriceEventHandler) work(queue <-chan dispatchItem) {
+	for item := range queue {
+		metricsDispatchQueueDepth.Dec()
+		p.processUpdate(item.priceKey, item.acc)
+		if p.pooled {
+			ReleasePriceAccount(item.acc)
+		}
+	}
+}
+
+// pendingCallback is a callback invocation collected while holding the shard lock.
+type pendingCallback struct {
+	reg       *callbackRegistration
+	publisher solana.PublicKey // zero for aggregate callbacks
+	info      *PriceInfo
+}
+
+func (p *PriceEventHandler) processUpdate(priceKey solana.PublicKey, acc *PriceAccount) {
+	// Collect callbacks under lock, but call them without holding it,
+	// so callbacks can (un)register other callbacks.
+	var pending []pendingCallback
+	shard := p.shard(priceKey)
+	shard.lock.Lock()
+	callbacks := shard.callbacks[priceKey]
 	for _, onPrice := range callbacks.onPrice {
-		onPrice.inform(acc, &acc.Agg)
+		pending = append(pending, pendingCallback{onPrice, solana.PublicKey{}, &acc.Agg})
 	}
-	for _, comp := range acc.Components {
+	for i := range acc.Components {
+		comp := &acc.Components[i]
 		if comp.Publisher.IsZero() {
 			continue
 		}
 		compCbs := callbacks.componentCallbacks[comp.Publisher]
 		for _, onPrice := range compCbs {
-			onPrice.inform(acc, &comp.Latest)
+			pending = append(pending, pendingCallback{onPrice, comp.Publisher, &comp.Latest})
 		}
 	}
+	shard.lock.Unlock()
+
+	// Call callbacks in order of registration.
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].reg.handle.key < pending[j].reg.handle.key
+	})
+	for _, cb := range pending {
+		p.safeInform(priceKey, cb, acc)
+	}
+	p.deliverSubscriptions(priceKey, acc)
+}
+
+// safeInform runs a callback, recovering from panics.
+func (p *PriceEventHandler) safeInform(priceKey solana.PublicKey, cb pendingCallback, acc *PriceAccount) {
+	defer func() {
+		if r := recover(); r != nil {
+			metricsCallbackPanicsTotal.Inc()
+			if p.opts.OnPanic != nil {
+				p.opts.OnPanic(priceKey, r)
+			}
+		}
+	}()
+	cb.reg.inform(priceKey, cb.publisher, acc, cb.info)
 }
 
 type priceCallbacks struct {
@@ -127,42 +415,89 @@ func (p *priceCallbacks) init() {
 
 type callbackMap map[uint64]*callbackRegistration
 
-func (container callbackMap) register(p *PriceEventHandler, callback func(PriceUpdate)) CallbackHandle {
+// CallbackOpts configures a callback registration.
+type CallbackOpts struct {
+	// Name identifies the registration in checkpoints, see PriceEventHandler.Checkpoint.
+	// Names must be unique and stable across restarts. Unnamed registrations are not checkpointed.
+	Name string
+	// Policy decides which updates are reported. Defaults to ChangeStatusOrSlot.
+	Policy ChangePolicy
+}
+
+func (container callbackMap) register(
+	p *PriceEventHandler,
+	shard *handlerShard,
+	priceKey, publisher solana.PublicKey,
+	opts *CallbackOpts,
+	callback func(PriceUpdate),
+) CallbackHandle {
 	// requires lock
-	p.regNonce += 1
-	key := p.regNonce
+	if opts == nil {
+		opts = new(CallbackOpts)
+	}
+	key := atomic.AddUint64(&p.regNonce, 1)
 
 	handle := CallbackHandle{
 		handler:   p,
+		shard:     shard,
 		container: container,
 		key:       key,
 	}
-	container[key] = &callbackRegistration{
-		handle:   handle,
+	reg := &callbackRegistration{
+		name:     opts.Name,
+		policy:   opts.Policy.orDefault(),
 		callback: callback,
+		handle:   handle,
 	}
+	if reg.name != "" {
+		reg.previousInfo = p.restoredInfo(reg.name, priceKey, publisher)
+	}
+	container[key] = reg
 	return handle
 }
 
 type callbackRegistration struct {
-	previousInfo *PriceInfo
+	name         string
+	infoLock     sync.Mutex // lock over previousInfo, for checkpoints
+	previousInfo *PriceInfo // copy owned by the registration
+	policy       ChangePolicy
 	callback     func(PriceUpdate)
 	handle       CallbackHandle
+	removed      uint32 // atomic, set when unsubscribed
 }
 
-func (r *callbackRegistration) inform(acc *PriceAccount, newInfo *PriceInfo) {
-	if r.previousInfo.HasChanged(newInfo) {
+func (r *callbackRegistration) inform(priceKey, publisher solana.PublicKey, acc *PriceAccount, newInfo *PriceInfo) {
+	if atomic.LoadUint32(&r.removed) != 0 {
+		return
+	}
+	r.infoLock.Lock()
+	prev := r.previousInfo
+	r.infoLock.Unlock()
+	if r.policy.hasChanged(prev, newInfo) {
 		r.callback(PriceUpdate{
+			PriceKey:     priceKey,
+			Publisher:    publisher,
+			Policy:       r.policy.Name,
 			Account:      acc,
-			PreviousInfo: r.previousInfo,
+			PreviousInfo: prev,
 			CurrentInfo:  newInfo,
 		})
 	}
-	r.previousInfo = newInfo
+	// Copy the info, as the account might get re-used after processing.
+	info := *newInfo
+	r.infoLock.Lock()
+	r.previousInfo = &info
+	r.infoLock.Unlock()
 }
 
 // PriceUpdate is returned to callbacks when an aggregate or component price has been updated.
+//
+// If the underlying stream uses StreamOpts.PoolAccounts,
+// Account and CurrentInfo must not be retained after the callback returns.
 type PriceUpdate struct {
+	PriceKey     solana.PublicKey
+	Publisher    solana.PublicKey // zero for aggregate price updates
+	Policy       string           // name of the change detection policy that reported the update
 	Account      *PriceAccount
 	PreviousInfo *PriceInfo
 	CurrentInfo  *PriceInfo
@@ -173,7 +508,7 @@ type PriceUpdate struct {
 // If ok is false, the value is invalid.
 func (p PriceUpdate) Previous() (price decimal.Decimal, conf decimal.Decimal, ok bool) {
 	if !p.PreviousInfo.IsZero() && p.Account != nil {
-		p.PreviousInfo.Value(p.Account.Exponent)
+		return p.PreviousInfo.Value(p.Account.Exponent)
 	}
 	return
 }
@@ -191,18 +526,32 @@ func (p PriceUpdate) Current() (price decimal.Decimal, conf decimal.Decimal, ok
 // CallbackHandle tracks the lifetime of a callback registration.
 type CallbackHandle struct {
 	handler   *PriceEventHandler
+	shard     *handlerShard
 	container callbackMap
 	key       uint64
+	cleanup   func() // optional, called on Unsubscribe
 }
 
 // Unsubscribe de-registers a callback from the handler.
 //
+// The callback will not be called after Unsubscribe returns,
+// unless it is already running on another goroutine.
+// It is safe to call Unsubscribe from within a callback.
+//
 // Calling Unsubscribe is optional.
 // The handler calls it automatically when the underlying stream closes.
 func (c CallbackHandle) Unsubscribe() {
-	lock := &c.handler.callbacksLock
-	lock.Lock()
-	defer lock.Unlock()
+	if c.shard != nil {
+		lock := &c.shard.lock
+		lock.Lock()
+		defer lock.Unlock()
 
-	delete(c.container, c.key)
+		if reg, ok := c.container[c.key]; ok {
+			atomic.StoreUint32(&reg.removed, 1)
+			delete(c.container, c.key)
+		}
+	}
+	if c.cleanup != nil {
+		c.cleanup()
+	}
 }
@@ -15,10 +15,15 @@
 package pyth
 
 import (
+	"bytes"
 	"log"
+	"sync"
+	"testing"
 	"time"
 
 	"github.com/gagliardetto/solana-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func ExamplePriceEventHandler() {
@@ -40,5 +45,157 @@ func ExamplePriceEventHandler() {
 
 	// Close stream after a while.
 	<-time.After(10 * time.Second)
-	stream.Close()
+	handler.Close()
+}
+
+func TestPriceEventHandler_Replay(t *testing.T) {
+	recording := makeRecording(t, 5, time.Second)
+	replayer, err := NewReplayer(bytes.NewReader(recording), ReplayOpts{Speed: ReplayAsFastAsPossible})
+	require.NoError(t, err)
+
+	handler := NewPriceEventHandler(replayer)
+	var prices []int64
+	handler.OnPriceChange(solana.MustPublicKeyFromBase58("E36MyBbavhYKHVLWR79GiReNNnBDiHj6nWA7htbkNZbh"), func(update PriceUpdate) {
+		prices = append(prices, update.CurrentInfo.Price)
+	})
+	require.NoError(t, handler.Wait())
+
+	// The first updates may be consumed before the callback was registered.
+	require.NotEmpty(t, prices)
+	assert.Equal(t, int64(112721), prices[len(prices)-1])
+}
+
+func TestPriceEventHandler_Close(t *testing.T) {
+	recording := makeRecording(t, 5, time.Hour)
+	replayer, err := NewReplayer(bytes.NewReader(recording), ReplayOpts{Speed: ReplayRealTime})
+	require.NoError(t, err)
+
+	handler := NewPriceEventHandler(replayer)
+	handler.Close()
+	assert.NoError(t, handler.Err())
+}
+
+// testSource is a PriceAccountSource fed by the test.
+type testSource struct {
+	updates chan PriceAccountEntry
+	once    sync.Once
+}
+
+func newTestSource() *testSource {
+	return &testSource{updates: make(chan PriceAccountEntry)}
+}
+
+func (s *testSource) Updates() <-chan PriceAccountEntry {
+	return s.updates
+}
+
+func (s *testSource) Err() error {
+	return nil
+}
+
+func (s *testSource) Close() {
+	s.once.Do(func() {
+		close(s.updates)
+	})
+}
+
+// send delivers a copy of the test price account with the given aggregate price and slot.
+func (s *testSource) send(priceKey solana.PublicKey, price int64, slot uint64) {
+	acc := priceAccount_E36MyBbavhYKHVLWR79GiReNNnBDiHj6nWA7htbkNZbh
+	acc.Agg.Price = price
+	acc.Agg.PubSlot = slot
+	s.updates <- PriceAccountEntry{PriceAccount: &acc, Pubkey: priceKey, Slot: slot}
+}
+
+var testPriceKey = solana.MustPublicKeyFromBase58("E36MyBbavhYKHVLWR79GiReNNnBDiHj6nWA7htbkNZbh")
+
+func TestPriceEventHandler_UnsubscribeFromCallback(t *testing.T) {
+	src := newTestSource()
+	handler := NewPriceEventHandler(src)
+
+	var calls int
+	var handle CallbackHandle
+	handle = handler.OnPriceChange(testPriceKey, func(PriceUpdate) {
+		calls++
+		handle.Unsubscribe()
+	})
+	src.send(testPriceKey, 1, 1)
+	src.send(testPriceKey, 2, 2)
+	src.Close()
+	require.NoError(t, handler.Wait())
+	assert.Equal(t, 1, calls)
+}
+
+func TestPriceEventHandler_SubscribeFromCallback(t *testing.T) {
+	src := newTestSource()
+	handler := NewPriceEventHandler(src)
+
+	var nested []int64
+	var once sync.Once
+	handler.OnPriceChange(testPriceKey, func(PriceUpdate) {
+		once.Do(func() {
+			handler.OnPriceChange(testPriceKey, func(update PriceUpdate) {
+				nested = append(nested, update.CurrentInfo.Price)
+			})
+		})
+	})
+	src.send(testPriceKey, 1, 1)
+	src.send(testPriceKey, 2, 2)
+	src.Close()
+	require.NoError(t, handler.Wait())
+	assert.Equal(t, []int64{2}, nested)
+}
+
+func TestPriceEventHandler_Panic(t *testing.T) {
+	src := newTestSource()
+	var panics []interface{}
+	handler := NewPriceEventHandlerWithOpts(src, &HandlerOpts{
+		OnPanic: func(priceKey solana.PublicKey, recovered interface{}) {
+			assert.Equal(t, testPriceKey, priceKey)
+			panics = append(panics, recovered)
+		},
+	})
+
+	var prices []int64
+	handler.OnPriceChange(testPriceKey, func(update PriceUpdate) {
+		prices = append(prices, update.CurrentInfo.Price)
+		if update.CurrentInfo.Price == 1 {
+			panic("oops")
+		}
+	})
+	src.send(testPriceKey, 1, 1)
+	src.send(testPriceKey, 2, 2)
+	src.Close()
+	require.NoError(t, handler.Wait())
+	assert.Equal(t, []interface{}{"oops"}, panics)
+	assert.Equal(t, []int64{1, 2}, prices)
+}
+
+func TestPriceEventHandler_Ordering(t *testing.T) {
+	src := newTestSource()
+	handler := NewPriceEventHandlerWithOpts(src, &HandlerOpts{Workers: 4, QueueSize: 1})
+
+	keys := make([]solana.PublicKey, 8)
+	prices := make([][]int64, len(keys))
+	for i := range keys {
+		i := i
+		keys[i] = solana.PublicKey{byte(i), 1, 2, 3}
+		handler.OnPriceChange(keys[i], func(update PriceUpdate) {
+			prices[i] = append(prices[i], update.CurrentInfo.Price)
+		})
+	}
+	for slot := uint64(1); slot <= 50; slot++ {
+		for _, key := range keys {
+			src.send(key, int64(slot), slot)
+		}
+	}
+	src.Close()
+	require.NoError(t, handler.Wait())
+
+	for i := range keys {
+		require.Len(t, prices[i], 50)
+		for j, price := range prices[i] {
+			assert.Equal(t, int64(j+1), price)
+		}
+	}
 }
@@ -45,6 +45,12 @@ const (
 	Instruction_UpdTest
 	Instruction_SetMinPub
 	Instruction_UpdPriceNoFailOnError
+	Instruction_ResizePriceAccount
+	Instruction_DelPrice
+	Instruction_DelProduct
+	Instruction_UpdPermissions
+	Instruction_SetMaxLatency
+	Instruction_InitPriceFeedIndex
 	instruction_count // number of different instruction types
 )
 
@@ -79,6 +85,18 @@ func InstructionIDToName(id int32) string {
 		return "set_min_pub"
 	case Instruction_UpdPriceNoFailOnError:
 		return "upd_price_no_fail_on_error"
+	case Instruction_ResizePriceAccount:
+		return "resize_price_account"
+	case Instruction_DelPrice:
+		return "del_price"
+	case Instruction_DelProduct:
+		return "del_product"
+	case Instruction_UpdPermissions:
+		return "upd_permissions"
+	case Instruction_SetMaxLatency:
+		return "set_max_latency"
+	case Instruction_InitPriceFeedIndex:
+		return "init_price_feed_index"
 	default:
 		return fmt.Sprintf("unsupported (%d)", id)
 	}
@@ -192,6 +210,19 @@ type CommandUpdTest struct {
 	Conf     [32]uint64
 }
 
+// CommandUpdPermissions is the payload of Instruction_UpdPermissions.
+type CommandUpdPermissions struct {
+	MasterAuthority       solana.PublicKey
+	DataCurationAuthority solana.PublicKey
+	SecurityAuthority     solana.PublicKey
+}
+
+// CommandSetMaxLatency is the payload of Instruction_SetMaxLatency.
+type CommandSetMaxLatency struct {
+	MaxLatency uint8
+	Padding    [3]byte
+}
+
 func newInstructionDecoder(programKey solana.PublicKey) func(accounts []*solana.AccountMeta, data []byte) (interface{}, error) {
 	return func(accounts []*solana.AccountMeta, data []byte) (interface{}, error) {
 		return DecodeInstruction(programKey, accounts, data)
@@ -259,6 +290,20 @@ func DecodeInstruction(
 	case Instruction_SetMinPub:
 		impl = new(CommandSetMinPub)
 		numAccounts = 2
+	case Instruction_ResizePriceAccount:
+		numAccounts = 3
+	case Instruction_DelPrice:
+		numAccounts = 3
+	case Instruction_DelProduct:
+		numAccounts = 3
+	case Instruction_UpdPermissions:
+		impl = new(CommandUpdPermissions)
+		numAccounts = 4
+	case Instruction_SetMaxLatency:
+		impl = new(CommandSetMaxLatency)
+		numAccounts = 3
+	case Instruction_InitPriceFeedIndex:
+		numAccounts = 3
 	default:
 		return nil, fmt.Errorf("unsupported instruction type (%d)", hdr.Cmd)
 	}
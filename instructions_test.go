@@ -42,6 +42,18 @@ var (
 	caseDelPublisher []byte
 	//go:embed tests/instruction/set_min_pub.bin
 	caseSetMinPub []byte
+	//go:embed tests/instruction/resize_price_account.bin
+	caseResizePriceAccount []byte
+	//go:embed tests/instruction/del_price.bin
+	caseDelPrice []byte
+	//go:embed tests/instruction/del_product.bin
+	caseDelProduct []byte
+	//go:embed tests/instruction/upd_permissions.bin
+	caseUpdPermissions []byte
+	//go:embed tests/instruction/set_max_latency.bin
+	caseSetMaxLatency []byte
+	//go:embed tests/instruction/init_price_feed_index.bin
+	caseInitPriceFeedIndex []byte
 )
 
 func TestInstruction_InitMapping(t *testing.T) {
@@ -364,6 +376,233 @@ func TestInstruction_SetMinPub(t *testing.T) {
 	assert.Equal(t, actualIns, rebuiltIns)
 }
 
+func TestInstruction_ResizePriceAccount(t *testing.T) {
+	var env = Devnet
+	var accs = []*solana.AccountMeta{
+		solana.Meta(solana.MustPublicKeyFromBase58("7cVfgArCheMR6Cs4t6vz5rfnqd56vZq4ndaBrY5xkxXy")).SIGNER().WRITE(),
+		solana.Meta(solana.MustPublicKeyFromBase58("E36MyBbavhYKHVLWR79GiReNNnBDiHj6nWA7htbkNZbh")).SIGNER().WRITE(),
+		solana.Meta(solana.SystemProgramID),
+	}
+
+	actualIns, err := DecodeInstruction(env.Program, accs, caseResizePriceAccount)
+	require.NoError(t, err)
+
+	assert.Equal(t, env.Program, actualIns.ProgramID())
+	assert.Equal(t, accs, actualIns.Accounts())
+	assert.Equal(t, CommandHeader{
+		Version: V2,
+		Cmd:     Instruction_ResizePriceAccount,
+	}, actualIns.Header)
+	assert.Equal(t, "resize_price_account", InstructionIDToName(actualIns.Header.Cmd))
+	assert.Nil(t, actualIns.Payload)
+
+	data, err := actualIns.Data()
+	assert.NoError(t, err)
+	assert.Len(t, data, 8)
+	require.Equal(t, caseResizePriceAccount, data)
+
+	rebuiltIns := NewInstructionBuilder(env.Program).ResizePriceAccount(
+		accs[0].PublicKey,
+		accs[1].PublicKey,
+	)
+	assert.Equal(t, actualIns, rebuiltIns)
+}
+
+func TestInstruction_DelPrice(t *testing.T) {
+	var env = Devnet
+	var accs = []*solana.AccountMeta{
+		solana.Meta(solana.MustPublicKeyFromBase58("7cVfgArCheMR6Cs4t6vz5rfnqd56vZq4ndaBrY5xkxXy")).SIGNER().WRITE(),
+		solana.Meta(solana.MustPublicKeyFromBase58("EWxGfxoPQSNA2744AYdAKmsQZ8F9o9M7oKkvL3VM1dko")).SIGNER().WRITE(),
+		solana.Meta(solana.MustPublicKeyFromBase58("E36MyBbavhYKHVLWR79GiReNNnBDiHj6nWA7htbkNZbh")).SIGNER().WRITE(),
+	}
+
+	actualIns, err := DecodeInstruction(env.Program, accs, caseDelPrice)
+	require.NoError(t, err)
+
+	assert.Equal(t, env.Program, actualIns.ProgramID())
+	assert.Equal(t, accs, actualIns.Accounts())
+	assert.Equal(t, CommandHeader{
+		Version: V2,
+		Cmd:     Instruction_DelPrice,
+	}, actualIns.Header)
+	assert.Equal(t, "del_price", InstructionIDToName(actualIns.Header.Cmd))
+	assert.Nil(t, actualIns.Payload)
+
+	data, err := actualIns.Data()
+	assert.NoError(t, err)
+	assert.Len(t, data, 8)
+	require.Equal(t, caseDelPrice, data)
+
+	rebuiltIns := NewInstructionBuilder(env.Program).DelPrice(
+		accs[0].PublicKey,
+		accs[1].PublicKey,
+		accs[2].PublicKey,
+	)
+	assert.Equal(t, actualIns, rebuiltIns)
+}
+
+func TestInstruction_DelProduct(t *testing.T) {
+	var env = Devnet
+	var accs = []*solana.AccountMeta{
+		solana.Meta(solana.MustPublicKeyFromBase58("7cVfgArCheMR6Cs4t6vz5rfnqd56vZq4ndaBrY5xkxXy")).SIGNER().WRITE(),
+		solana.Meta(env.Mapping).SIGNER().WRITE(),
+		solana.Meta(solana.MustPublicKeyFromBase58("EWxGfxoPQSNA2744AYdAKmsQZ8F9o9M7oKkvL3VM1dko")).SIGNER().WRITE(),
+	}
+
+	actualIns, err := DecodeInstruction(env.Program, accs, caseDelProduct)
+	require.NoError(t, err)
+
+	assert.Equal(t, env.Program, actualIns.ProgramID())
+	assert.Equal(t, accs, actualIns.Accounts())
+	assert.Equal(t, CommandHeader{
+		Version: V2,
+		Cmd:     Instruction_DelProduct,
+	}, actualIns.Header)
+	assert.Equal(t, "del_product", InstructionIDToName(actualIns.Header.Cmd))
+	assert.Nil(t, actualIns.Payload)
+
+	data, err := actualIns.Data()
+	assert.NoError(t, err)
+	assert.Len(t, data, 8)
+	require.Equal(t, caseDelProduct, data)
+
+	rebuiltIns := NewInstructionBuilder(env.Program).DelProduct(
+		accs[0].PublicKey,
+		accs[1].PublicKey,
+		accs[2].PublicKey,
+	)
+	assert.Equal(t, actualIns, rebuiltIns)
+}
+
+func TestInstruction_UpdPermissions(t *testing.T) {
+	var env = Devnet
+	programData, err := FindProgramDataAccount(env.Program)
+	require.NoError(t, err)
+	permissions, err := FindPermissionsAccount(env.Program)
+	require.NoError(t, err)
+	var accs = []*solana.AccountMeta{
+		solana.Meta(solana.MustPublicKeyFromBase58("7cVfgArCheMR6Cs4t6vz5rfnqd56vZq4ndaBrY5xkxXy")).SIGNER().WRITE(),
+		solana.Meta(programData),
+		solana.Meta(permissions).WRITE(),
+		solana.Meta(solana.SystemProgramID),
+	}
+
+	actualIns, err := DecodeInstruction(env.Program, accs, caseUpdPermissions)
+	require.NoError(t, err)
+
+	assert.Equal(t, env.Program, actualIns.ProgramID())
+	assert.Equal(t, accs, actualIns.Accounts())
+	assert.Equal(t, CommandHeader{
+		Version: V2,
+		Cmd:     Instruction_UpdPermissions,
+	}, actualIns.Header)
+	assert.Equal(t, "upd_permissions", InstructionIDToName(actualIns.Header.Cmd))
+	require.Equal(t, &CommandUpdPermissions{
+		MasterAuthority:       solana.MustPublicKeyFromBase58("5U3bH5b6XtG99aVWLqwVzYPVpQiFHytBD68Rz2eFPZd7"),
+		DataCurationAuthority: solana.MustPublicKeyFromBase58("7cVfgArCheMR6Cs4t6vz5rfnqd56vZq4ndaBrY5xkxXy"),
+		SecurityAuthority:     solana.MustPublicKeyFromBase58("E36MyBbavhYKHVLWR79GiReNNnBDiHj6nWA7htbkNZbh"),
+	}, actualIns.Payload)
+
+	data, err := actualIns.Data()
+	assert.NoError(t, err)
+	assert.Len(t, data, 104)
+	require.Equal(t, caseUpdPermissions, data)
+
+	rebuiltIns := NewInstructionBuilder(env.Program).UpdPermissions(
+		accs[0].PublicKey,
+		accs[1].PublicKey,
+		accs[2].PublicKey,
+		*actualIns.Payload.(*CommandUpdPermissions),
+	)
+	assert.Equal(t, actualIns, rebuiltIns)
+}
+
+func TestInstruction_SetMaxLatency(t *testing.T) {
+	var env = Devnet
+	permissions, err := FindPermissionsAccount(env.Program)
+	require.NoError(t, err)
+	var accs = []*solana.AccountMeta{
+		solana.Meta(solana.MustPublicKeyFromBase58("5U3bH5b6XtG99aVWLqwVzYPVpQiFHytBD68Rz2eFPZd7")).SIGNER().WRITE(),
+		solana.Meta(solana.MustPublicKeyFromBase58("E36MyBbavhYKHVLWR79GiReNNnBDiHj6nWA7htbkNZbh")).SIGNER().WRITE(),
+		solana.Meta(permissions),
+	}
+
+	actualIns, err := DecodeInstruction(env.Program, accs, caseSetMaxLatency)
+	require.NoError(t, err)
+
+	assert.Equal(t, env.Program, actualIns.ProgramID())
+	assert.Equal(t, accs, actualIns.Accounts())
+	assert.Equal(t, CommandHeader{
+		Version: V2,
+		Cmd:     Instruction_SetMaxLatency,
+	}, actualIns.Header)
+	assert.Equal(t, "set_max_latency", InstructionIDToName(actualIns.Header.Cmd))
+	require.Equal(t, &CommandSetMaxLatency{
+		MaxLatency: 10,
+		Padding:    [...]byte{0, 0, 0},
+	}, actualIns.Payload)
+
+	data, err := actualIns.Data()
+	assert.NoError(t, err)
+	assert.Len(t, data, 12)
+	require.Equal(t, caseSetMaxLatency, data)
+
+	rebuiltIns := NewInstructionBuilder(env.Program).SetMaxLatency(
+		accs[0].PublicKey,
+		accs[1].PublicKey,
+		accs[2].PublicKey,
+		*actualIns.Payload.(*CommandSetMaxLatency),
+	)
+	assert.Equal(t, actualIns, rebuiltIns)
+}
+
+func TestInstruction_InitPriceFeedIndex(t *testing.T) {
+	var env = Devnet
+	permissions, err := FindPermissionsAccount(env.Program)
+	require.NoError(t, err)
+	var accs = []*solana.AccountMeta{
+		solana.Meta(solana.MustPublicKeyFromBase58("5U3bH5b6XtG99aVWLqwVzYPVpQiFHytBD68Rz2eFPZd7")).SIGNER().WRITE(),
+		solana.Meta(solana.MustPublicKeyFromBase58("E36MyBbavhYKHVLWR79GiReNNnBDiHj6nWA7htbkNZbh")).SIGNER().WRITE(),
+		solana.Meta(permissions).WRITE(),
+	}
+
+	actualIns, err := DecodeInstruction(env.Program, accs, caseInitPriceFeedIndex)
+	require.NoError(t, err)
+
+	assert.Equal(t, env.Program, actualIns.ProgramID())
+	assert.Equal(t, accs, actualIns.Accounts())
+	assert.Equal(t, CommandHeader{
+		Version: V2,
+		Cmd:     Instruction_InitPriceFeedIndex,
+	}, actualIns.Header)
+	assert.Equal(t, "init_price_feed_index", InstructionIDToName(actualIns.Header.Cmd))
+	assert.Nil(t, actualIns.Payload)
+
+	data, err := actualIns.Data()
+	assert.NoError(t, err)
+	assert.Len(t, data, 8)
+	require.Equal(t, caseInitPriceFeedIndex, data)
+
+	rebuiltIns := NewInstructionBuilder(env.Program).InitPriceFeedIndex(
+		accs[0].PublicKey,
+		accs[1].PublicKey,
+		accs[2].PublicKey,
+	)
+	assert.Equal(t, actualIns, rebuiltIns)
+}
+
+func TestInstruction_WrongAccounts(t *testing.T) {
+	var env = Devnet
+	var accs = []*solana.AccountMeta{
+		solana.Meta(solana.MustPublicKeyFromBase58("5U3bH5b6XtG99aVWLqwVzYPVpQiFHytBD68Rz2eFPZd7")).SIGNER().WRITE(),
+		solana.Meta(solana.MustPublicKeyFromBase58("E36MyBbavhYKHVLWR79GiReNNnBDiHj6nWA7htbkNZbh")).SIGNER().WRITE(),
+	}
+
+	actualIns, err := DecodeInstruction(env.Program, accs, caseUpdPermissions)
+	require.EqualError(t, err, "expected 4 accounts for upd_permissions but got 2")
+	assert.Nil(t, actualIns)
+}
+
 func TestInstruction_WrongVersion(t *testing.T) {
 	var env = Devnet
 	var accs = []*solana.AccountMeta{
@@ -0,0 +1,127 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+// PublisherEvent is passed to callbacks when the publisher set of a price account changes.
+type PublisherEvent struct {
+	PriceKey  solana.PublicKey
+	Publisher solana.PublicKey
+	Joined    bool          // false if the publisher has left
+	Account   *PriceAccount // a copy if the stream uses StreamOpts.PoolAccounts
+}
+
+// QuorumEvent is passed to callbacks when a price account loses or restores its quorum.
+type QuorumEvent struct {
+	PriceKey  solana.PublicKey
+	NumQt     uint32        // number of quoters that make up the aggregate
+	MinQuorum uint32        // threshold passed to OnQuorumChange
+	HasQuorum bool          // false if the quorum was lost
+	Account   *PriceAccount // a copy if the stream uses StreamOpts.PoolAccounts
+}
+
+// Publishers returns the keys of all publishers of the price account.
+func (p *PriceAccount) Publishers() []solana.PublicKey {
+	num := int(p.Num)
+	if num > len(p.Components) {
+		num = len(p.Components)
+	}
+	publishers := make([]solana.PublicKey, 0, num)
+	for i := 0; i < num; i++ {
+		if !p.Components[i].Publisher.IsZero() {
+			publishers = append(publishers, p.Components[i].Publisher)
+		}
+	}
+	return publishers
+}
+
+// OnPublisherChange registers a callback function to be called
+// whenever a publisher joins or leaves the provided price account.
+//
+// The first update seen establishes the initial publisher set and does not fire any events.
+// Events of a single update are ordered by publisher key, leaving publishers first.
+func (p *PriceEventHandler) OnPublisherChange(priceKey solana.PublicKey, callback func(PublisherEvent)) CallbackHandle {
+	var known map[solana.PublicKey]struct{}
+	return p.onAccountUpdate(priceKey, func(update PriceUpdate) {
+		current := make(map[solana.PublicKey]struct{}, update.Account.Num)
+		for _, publisher := range update.Account.Publishers() {
+			current[publisher] = struct{}{}
+		}
+		previous := known
+		known = current
+		if previous == nil {
+			return
+		}
+
+		var left, joined []solana.PublicKey
+		for publisher := range previous {
+			if _, ok := current[publisher]; !ok {
+				left = append(left, publisher)
+			}
+		}
+		for publisher := range current {
+			if _, ok := previous[publisher]; !ok {
+				joined = append(joined, publisher)
+			}
+		}
+		if len(left)+len(joined) == 0 {
+			return
+		}
+		sortPublicKeys(left)
+		sortPublicKeys(joined)
+		acc := p.retainable(update.Account)
+		for _, publisher := range left {
+			callback(PublisherEvent{PriceKey: priceKey, Publisher: publisher, Joined: false, Account: acc})
+		}
+		for _, publisher := range joined {
+			callback(PublisherEvent{PriceKey: priceKey, Publisher: publisher, Joined: true, Account: acc})
+		}
+	})
+}
+
+// OnQuorumChange registers a callback function to be called
+// whenever the number of quoters (PriceAccount.NumQt) of the provided price account
+// drops below minQuorum, or reaches it again.
+//
+// If the quorum is already lost on the first update seen, the callback fires immediately.
+func (p *PriceEventHandler) OnQuorumChange(priceKey solana.PublicKey, minQuorum uint32, callback func(QuorumEvent)) CallbackHandle {
+	hasQuorum := true
+	return p.onAccountUpdate(priceKey, func(update PriceUpdate) {
+		current := update.Account.NumQt >= minQuorum
+		if current == hasQuorum {
+			return
+		}
+		hasQuorum = current
+		callback(QuorumEvent{
+			PriceKey:  priceKey,
+			NumQt:     update.Account.NumQt,
+			MinQuorum: minQuorum,
+			HasQuorum: current,
+			Account:   p.retainable(update.Account),
+		})
+	})
+}
+
+func sortPublicKeys(keys []solana.PublicKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i][:], keys[j][:]) < 0
+	})
+}
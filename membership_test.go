@@ -0,0 +1,145 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"testing"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriceAccount_Publishers(t *testing.T) {
+	acc := priceAccount_E36MyBbavhYKHVLWR79GiReNNnBDiHj6nWA7htbkNZbh
+	publishers := acc.Publishers()
+	require.Len(t, publishers, 10)
+	assert.Equal(t, solana.MustPublicKeyFromBase58("5U3bH5b6XtG99aVWLqwVzYPVpQiFHytBD68Rz2eFPZd7"), publishers[0])
+}
+
+func TestPriceEventHandler_OnPublisherChange(t *testing.T) {
+	src := newTestSource()
+	handler := NewPriceEventHandler(src)
+
+	var events []PublisherEvent
+	handler.OnPublisherChange(testPriceKey, func(event PublisherEvent) {
+		events = append(events, event)
+	})
+
+	base := priceAccount_E36MyBbavhYKHVLWR79GiReNNnBDiHj6nWA7htbkNZbh
+	first := base.Components[0].Publisher
+	newcomer := solana.PublicKey{1, 2, 3}
+
+	// Initial state.
+	acc1 := base
+	src.updates <- PriceAccountEntry{PriceAccount: &acc1, Pubkey: testPriceKey, Slot: 1}
+	// Same publisher set, nothing happens.
+	acc2 := base
+	src.updates <- PriceAccountEntry{PriceAccount: &acc2, Pubkey: testPriceKey, Slot: 2}
+	// First publisher leaves, its slot is replaced by the last one.
+	acc3 := base
+	acc3.Components[0] = acc3.Components[9]
+	acc3.Components[9] = PriceComp{}
+	acc3.Num = 9
+	src.updates <- PriceAccountEntry{PriceAccount: &acc3, Pubkey: testPriceKey, Slot: 3}
+	// New publisher joins.
+	acc4 := acc3
+	acc4.Components[9] = PriceComp{Publisher: newcomer}
+	acc4.Num = 10
+	src.updates <- PriceAccountEntry{PriceAccount: &acc4, Pubkey: testPriceKey, Slot: 4}
+
+	src.Close()
+	require.NoError(t, handler.Wait())
+
+	require.Len(t, events, 2)
+	assert.Equal(t, first, events[0].Publisher)
+	assert.False(t, events[0].Joined)
+	assert.Equal(t, newcomer, events[1].Publisher)
+	assert.True(t, events[1].Joined)
+}
+
+func TestPriceEventHandler_OnQuorumChange(t *testing.T) {
+	src := newTestSource()
+	handler := NewPriceEventHandler(src)
+
+	var events []QuorumEvent
+	handler.OnQuorumChange(testPriceKey, 3, func(event QuorumEvent) {
+		events = append(events, event)
+	})
+
+	for slot, numQt := range []uint32{5, 3, 2, 1, 3, 4} {
+		acc := priceAccount_E36MyBbavhYKHVLWR79GiReNNnBDiHj6nWA7htbkNZbh
+		acc.NumQt = numQt
+		src.updates <- PriceAccountEntry{PriceAccount: &acc, Pubkey: testPriceKey, Slot: uint64(slot)}
+	}
+	src.Close()
+	require.NoError(t, handler.Wait())
+
+	require.Len(t, events, 2)
+	assert.False(t, events[0].HasQuorum)
+	assert.Equal(t, uint32(2), events[0].NumQt)
+	assert.True(t, events[1].HasQuorum)
+	assert.Equal(t, uint32(3), events[1].NumQt)
+	assert.Equal(t, uint32(3), events[1].MinQuorum)
+}
+
+func TestPriceEventHandler_PooledEvents(t *testing.T) {
+	src := newTestSource()
+	handler := NewPriceEventHandler(src)
+	// As with a stream using StreamOpts.PoolAccounts, accounts are released after processing.
+	handler.pooled = true
+
+	var (
+		publisherEvents []PublisherEvent
+		quorumEvents    []QuorumEvent
+		triggerEvents   []TriggerEvent
+	)
+	handler.OnPublisherChange(testPriceKey, func(event PublisherEvent) {
+		publisherEvents = append(publisherEvents, event)
+	})
+	handler.OnQuorumChange(testPriceKey, 3, func(event QuorumEvent) {
+		quorumEvents = append(quorumEvents, event)
+	})
+	handler.OnTrigger(testPriceKey, Trigger{Condition: PriceMoveBps(100)}, func(event TriggerEvent) {
+		triggerEvents = append(triggerEvents, event)
+	})
+
+	acc1 := priceAccount_E36MyBbavhYKHVLWR79GiReNNnBDiHj6nWA7htbkNZbh
+	acc1.Agg.Status = PriceStatusTrading
+	acc1.NumQt = 5
+	acc2 := acc1
+	acc2.Agg.Price *= 2
+	acc2.Agg.PubSlot++
+	acc2.NumQt = 1
+	acc2.Num--
+	expected := acc2
+	sent := []*PriceAccount{&acc1, &acc2}
+	for i, acc := range sent {
+		src.updates <- PriceAccountEntry{PriceAccount: acc, Pubkey: testPriceKey, Slot: uint64(i + 1)}
+	}
+	src.Close()
+	require.NoError(t, handler.Wait())
+
+	// The released accounts are reused.
+	for _, acc := range sent {
+		*acc = PriceAccount{}
+	}
+	require.Len(t, publisherEvents, 1)
+	assert.Equal(t, &expected, publisherEvents[0].Account)
+	require.Len(t, quorumEvents, 1)
+	assert.Equal(t, &expected, quorumEvents[0].Account)
+	require.Len(t, triggerEvents, 1)
+	assert.Equal(t, &expected, triggerEvents[0].Account)
+}
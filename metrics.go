@@ -22,7 +22,9 @@ import (
 const (
 	namespace = "pyth"
 
-	subsystemClient = "client"
+	subsystemClient    = "client"
+	subsystemHandler   = "handler"
+	subsystemPublisher = "publisher"
 )
 
 var (
@@ -38,4 +40,96 @@ var (
 		Name:      "ws_events_total",
 		Help:      "Number of WebSocket events delivered from RPC nodes to Pyth client",
 	})
+	metricsWsReconnectsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystemClient,
+		Name:      "ws_reconnects_total",
+		Help:      "Number of WebSocket reconnects by program and reason",
+	}, []string{"program", "reason"})
+	metricsDecodeFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystemClient,
+		Name:      "decode_failures_total",
+		Help:      "Number of streamed price accounts that failed to decode, by program",
+	}, []string{"program"})
+	metricsLastUpdateTimestamp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystemClient,
+		Name:      "last_update_timestamp_seconds",
+		Help:      "Unix time of the last streamed price account update, by program",
+	}, []string{"program"})
+	metricsUpdateSlotLag = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: subsystemClient,
+		Name:      "update_slot_lag",
+		Help:      "Number of slots between the aggregate publish slot and the slot of a price account update, by program",
+		Buckets:   []float64{0, 1, 2, 3, 5, 10, 25, 50, 100},
+	}, []string{"program"})
+	metricsTipSlotLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystemClient,
+		Name:      "tip_slot_lag",
+		Help:      "Number of slots between the cluster tip and the last price account update, by program",
+	}, []string{"program"})
+	metricsDispatchQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystemHandler,
+		Name:      "dispatch_queue_depth",
+		Help:      "Number of price account updates waiting to be dispatched to callbacks",
+	})
+	metricsCallbackPanicsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystemHandler,
+		Name:      "callback_panics_total",
+		Help:      "Number of callbacks that panicked",
+	})
+	metricsPublisherTxSentTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystemPublisher,
+		Name:      "tx_sent_total",
+		Help:      "Number of price update transactions sent to RPC nodes",
+	})
+	metricsPublisherTxLandedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystemPublisher,
+		Name:      "tx_landed_total",
+		Help:      "Number of price update transactions confirmed on-chain",
+	})
+	metricsPublisherTxFailedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystemPublisher,
+		Name:      "tx_failed_total",
+		Help:      "Number of price update transactions that failed, by stage",
+	}, []string{"stage"})
+	metricsPublisherOutcomesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystemPublisher,
+		Name:      "tx_outcomes_total",
+		Help:      "Number of tracked price update transactions, by cross-checked outcome",
+	}, []string{"outcome"})
+	metricsPublisherConfirmationLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: subsystemPublisher,
+		Name:      "confirmation_latency_seconds",
+		Help:      "Time from sending a price update transaction until it was seen landed",
+		Buckets:   prometheus.ExponentialBuckets(0.4, 2, 10),
+	})
+	metricsPublisherLandingRatio = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystemPublisher,
+		Name:      "landing_ratio",
+		Help:      "Share of tracked price update transactions that landed in the publisher's component, by program and publisher",
+	}, []string{"program", "publisher"})
+	metricsPublisherOutcomesDroppedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystemPublisher,
+		Name:      "tx_outcomes_dropped_total",
+		Help:      "Number of price update transaction outcomes dropped because the landing tracker fell behind",
+	})
+	metricsSchedulerSlotsSkippedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystemPublisher,
+		Name:      "slots_skipped_total",
+		Help:      "Number of scheduled slots without any update sent, by reason",
+	}, []string{"reason"})
 )
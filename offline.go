@@ -0,0 +1,664 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	bin "github.com/gagliardetto/binary"
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/programs/system"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// OfflineBundleVersion is the version of the offline bundle file format.
+const OfflineBundleVersion = 1
+
+// OfflineBundle is a portable set of transactions collecting signatures away from the network,
+// such as on air-gapped machines or from the members of a multisig.
+//
+// Bundles are stored as JSON. Each signer signs its own copy of the bundle,
+// the copies are then merged with CombineOfflineBundles and sent with Client.SubmitOffline.
+type OfflineBundle struct {
+	Version      int                   `json:"version"`
+	Transactions []*OfflineTransaction `json:"transactions"`
+}
+
+// OfflineTransaction is a serialized transaction message with the signatures collected so far.
+type OfflineTransaction struct {
+	Message    []byte                                `json:"message"` // base64 in JSON
+	Signatures map[solana.PublicKey]solana.Signature `json:"signatures,omitempty"`
+	// LastValidBlockHeight is the last block height at which a transaction using a recent blockhash can land.
+	// Zero if unknown or if the transaction uses a durable nonce.
+	LastValidBlockHeight uint64 `json:"last_valid_block_height,omitempty"`
+}
+
+// OfflineOpts configures PrepareOffline.
+type OfflineOpts struct {
+	// NonceAccounts are initialized durable nonce accounts, one for each transaction of the bundle.
+	// Transactions using a durable nonce do not expire until the nonce is advanced.
+	// If empty, transactions use a recent blockhash and expire after about a minute.
+	NonceAccounts []solana.PublicKey
+	// Signers sign the transactions right away where required,
+	// such as the keypairs of accounts created by the instructions.
+	Signers []Signer
+}
+
+// PrepareOffline packs instructions into unsigned transactions for offline signing.
+//
+// With durable nonces, each transaction starts with an AdvanceNonceAccount instruction,
+// which must be signed by the nonce authority in addition to the signers of the instructions.
+// The opts may be nil.
+//
+// Instructions may be split across transactions at any point,
+// use PrepareOfflineGroups to keep related instructions together.
+func (c *Client) PrepareOffline(
+	ctx context.Context,
+	funding solana.PublicKey,
+	instructions []solana.Instruction,
+	opts *OfflineOpts,
+) (*OfflineBundle, error) {
+	groups := make([][]solana.Instruction, len(instructions))
+	for i, ins := range instructions {
+		groups[i] = []solana.Instruction{ins}
+	}
+	return c.PrepareOfflineGroups(ctx, funding, groups, opts)
+}
+
+// PrepareOfflineGroups is like PrepareOffline, but never splits a group of instructions across transactions,
+// such as the creation of an account and its initialization.
+//
+// Fails if a group does not fit into a single transaction.
+func (c *Client) PrepareOfflineGroups(
+	ctx context.Context,
+	funding solana.PublicKey,
+	groups [][]solana.Instruction,
+	opts *OfflineOpts,
+) (*OfflineBundle, error) {
+	if opts == nil {
+		opts = new(OfflineOpts)
+	}
+	var nonEmpty [][]solana.Instruction
+	for _, group := range groups {
+		if len(group) > 0 {
+			nonEmpty = append(nonEmpty, group)
+		}
+	}
+	groups = nonEmpty
+
+	var txs []*solana.Transaction
+	var lastValidBlockHeight uint64
+	if len(opts.NonceAccounts) == 0 {
+		packed, err := PackInstructionGroups(funding, groups, nil)
+		if err != nil {
+			return nil, err
+		}
+		blockhash, err := c.RPC.GetLatestBlockhash(ctx, rpc.CommitmentConfirmed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get blockhash: %w", err)
+		}
+		lastValidBlockHeight = blockhash.Value.LastValidBlockHeight
+		for _, instructions := range packed {
+			tx, err := solana.NewTransaction(instructions, blockhash.Value.Blockhash, solana.TransactionPayer(funding))
+			if err != nil {
+				return nil, fmt.Errorf("failed to build transaction: %w", err)
+			}
+			txs = append(txs, tx)
+		}
+	} else {
+		for i, nonceKey := range opts.NonceAccounts {
+			if len(groups) == 0 {
+				break
+			}
+			nonce, err := c.getNonceAccount(ctx, nonceKey)
+			if err != nil {
+				return nil, err
+			}
+			advance := system.NewAdvanceNonceAccountInstruction(
+				nonceKey, solana.SysVarRecentBlockHashesPubkey, nonce.AuthorizedPubkey).Build()
+			// Fill the transaction with as many groups as fit after the nonce instruction.
+			packed, err := PackInstructionGroups(funding, append([][]solana.Instruction{{advance}}, groups...), nil)
+			if err != nil {
+				return nil, err
+			}
+			instructions := packed[0]
+			if len(instructions) < 2 {
+				return nil, fmt.Errorf("instruction group does not fit into transaction %d with durable nonce", i)
+			}
+			for n := len(instructions) - 1; n > 0; groups = groups[1:] {
+				n -= len(groups[0])
+			}
+			tx, err := solana.NewTransaction(instructions, solana.Hash(nonce.Nonce), solana.TransactionPayer(funding))
+			if err != nil {
+				return nil, fmt.Errorf("failed to build transaction: %w", err)
+			}
+			txs = append(txs, tx)
+		}
+		if len(groups) > 0 {
+			return nil, fmt.Errorf("not enough nonce accounts (%d), %d instruction groups left", len(opts.NonceAccounts), len(groups))
+		}
+	}
+
+	bundle := &OfflineBundle{Version: OfflineBundleVersion}
+	for _, tx := range txs {
+		offline, err := newOfflineTransaction(tx)
+		if err != nil {
+			return nil, err
+		}
+		offline.LastValidBlockHeight = lastValidBlockHeight
+		bundle.Transactions = append(bundle.Transactions, offline)
+	}
+	if _, err := bundle.Sign(ctx, opts.Signers...); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// getNonceAccount returns the state of an initialized durable nonce account.
+func (c *Client) getNonceAccount(ctx context.Context, key solana.PublicKey) (*system.NonceAccount, error) {
+	info, err := c.RPC.GetAccountInfoWithOpts(ctx, key, &rpc.GetAccountInfoOpts{Commitment: rpc.CommitmentConfirmed})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get nonce account %s: %w", key, err)
+	}
+	nonce := new(system.NonceAccount)
+	if err := nonce.UnmarshalWithDecoder(bin.NewBinDecoder(info.Value.Data.GetBinary())); err != nil {
+		return nil, fmt.Errorf("invalid nonce account %s: %w", key, err)
+	}
+	if nonce.State != 1 {
+		return nil, fmt.Errorf("nonce account %s is not initialized", key)
+	}
+	return nonce, nil
+}
+
+// NewOfflineBundle exports prepared transactions of admin workflows, such as PrepareCreateProduct.
+//
+// The transactions are signed by the accounts they create.
+// They use a recent blockhash and must be signed and submitted before it expires,
+// use PrepareOffline with durable nonces to sign at leisure.
+func NewOfflineBundle(ctx context.Context, txs ...*PreparedTransaction) (*OfflineBundle, error) {
+	bundle := &OfflineBundle{Version: OfflineBundleVersion}
+	for _, prepared := range txs {
+		offline, err := newOfflineTransaction(prepared.Transaction)
+		if err != nil {
+			return nil, err
+		}
+		offline.LastValidBlockHeight = prepared.lastValidBlockHeight
+		if _, err := offline.Sign(ctx, prepared.signers...); err != nil {
+			return nil, err
+		}
+		bundle.Transactions = append(bundle.Transactions, offline)
+	}
+	return bundle, nil
+}
+
+func newOfflineTransaction(tx *solana.Transaction) (*OfflineTransaction, error) {
+	message, err := tx.Message.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode message: %w", err)
+	}
+	return &OfflineTransaction{
+		Message:    message,
+		Signatures: make(map[solana.PublicKey]solana.Signature),
+	}, nil
+}
+
+// ReadOfflineBundle reads a bundle file.
+func ReadOfflineBundle(path string) (*OfflineBundle, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	bundle := new(OfflineBundle)
+	if err := json.Unmarshal(data, bundle); err != nil {
+		return nil, fmt.Errorf("invalid offline bundle %s: %w", path, err)
+	}
+	if bundle.Version != OfflineBundleVersion {
+		return nil, fmt.Errorf("unsupported offline bundle version %d", bundle.Version)
+	}
+	return bundle, nil
+}
+
+// WriteFile stores the bundle as JSON.
+func (b *OfflineBundle) WriteFile(path string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// Sign adds the signatures of the given signers to each transaction requiring them.
+//
+// Signers not required by a transaction are skipped. Returns the number of signatures added.
+func (b *OfflineBundle) Sign(ctx context.Context, signers ...Signer) (int, error) {
+	total := 0
+	for i, tx := range b.Transactions {
+		n, err := tx.Sign(ctx, signers...)
+		if err != nil {
+			return total, fmt.Errorf("transaction %d: %w", i, err)
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// Verify checks the signatures of all transactions. Missing signatures are not an error.
+func (b *OfflineBundle) Verify() error {
+	for i, tx := range b.Transactions {
+		if err := tx.Verify(); err != nil {
+			return fmt.Errorf("transaction %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// MissingSigners returns the required signers that have not signed all of their transactions yet.
+func (b *OfflineBundle) MissingSigners() ([]solana.PublicKey, error) {
+	var missing []solana.PublicKey
+	seen := make(map[solana.PublicKey]struct{})
+	for i, tx := range b.Transactions {
+		keys, err := tx.MissingSigners()
+		if err != nil {
+			return nil, fmt.Errorf("transaction %d: %w", i, err)
+		}
+		for _, key := range keys {
+			if _, ok := seen[key]; !ok {
+				seen[key] = struct{}{}
+				missing = append(missing, key)
+			}
+		}
+	}
+	return missing, nil
+}
+
+// Review returns a human-readable description of all transactions for review before signing.
+//
+// Instructions of the given Pyth program are decoded.
+func (b *OfflineBundle) Review(program solana.PublicKey) (string, error) {
+	var out strings.Builder
+	for i, tx := range b.Transactions {
+		review, err := tx.Review(program)
+		if err != nil {
+			return "", fmt.Errorf("transaction %d: %w", i, err)
+		}
+		fmt.Fprintf(&out, "Transaction %d/%d\n%s", i+1, len(b.Transactions), review)
+	}
+	return out.String(), nil
+}
+
+// CombineOfflineBundles merges the signatures of copies of the same bundle.
+func CombineOfflineBundles(bundles ...*OfflineBundle) (*OfflineBundle, error) {
+	if len(bundles) == 0 {
+		return nil, errors.New("no bundles to combine")
+	}
+	combined := &OfflineBundle{Version: OfflineBundleVersion}
+	for _, tx := range bundles[0].Transactions {
+		combined.Transactions = append(combined.Transactions, &OfflineTransaction{
+			Message:              tx.Message,
+			Signatures:           make(map[solana.PublicKey]solana.Signature),
+			LastValidBlockHeight: tx.LastValidBlockHeight,
+		})
+	}
+	for j, bundle := range bundles {
+		if len(bundle.Transactions) != len(combined.Transactions) {
+			return nil, fmt.Errorf("bundle %d has %d transactions instead of %d", j, len(bundle.Transactions), len(combined.Transactions))
+		}
+		for i, tx := range bundle.Transactions {
+			target := combined.Transactions[i]
+			if !bytes.Equal(tx.Message, target.Message) {
+				return nil, fmt.Errorf("bundle %d: transaction %d differs", j, i)
+			}
+			if err := tx.Verify(); err != nil {
+				return nil, fmt.Errorf("bundle %d: transaction %d: %w", j, i, err)
+			}
+			for key, sig := range tx.Signatures {
+				target.Signatures[key] = sig
+			}
+		}
+	}
+	return combined, nil
+}
+
+// DecodeMessage returns the transaction message.
+func (t *OfflineTransaction) DecodeMessage() (*solana.Message, error) {
+	msg := new(solana.Message)
+	if err := msg.UnmarshalWithDecoder(bin.NewBinDecoder(t.Message)); err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+	return msg, nil
+}
+
+// Sign adds the signatures of the given signers required by the transaction.
+//
+// Returns the number of signatures added.
+func (t *OfflineTransaction) Sign(ctx context.Context, signers ...Signer) (int, error) {
+	msg, err := t.DecodeMessage()
+	if err != nil {
+		return 0, err
+	}
+	if t.Signatures == nil {
+		t.Signatures = make(map[solana.PublicKey]solana.Signature)
+	}
+	n := 0
+	for _, key := range msg.Signers() {
+		signer := findSigner(signers, key)
+		if signer == nil {
+			continue
+		}
+		sig, err := signer.SignMessage(ctx, t.Message)
+		if err != nil {
+			return n, fmt.Errorf("failed to sign with %s: %w", key, err)
+		}
+		t.Signatures[key] = sig
+		n++
+	}
+	return n, nil
+}
+
+// Verify checks that each signature is valid and belongs to a required signer.
+// Missing signatures are not an error.
+func (t *OfflineTransaction) Verify() error {
+	msg, err := t.DecodeMessage()
+	if err != nil {
+		return err
+	}
+	required := make(map[solana.PublicKey]struct{})
+	for _, key := range msg.Signers() {
+		required[key] = struct{}{}
+	}
+	for key, sig := range t.Signatures {
+		if _, ok := required[key]; !ok {
+			return fmt.Errorf("signature of %s is not required", key)
+		}
+		if !sig.Verify(key, t.Message) {
+			return fmt.Errorf("invalid signature of %s", key)
+		}
+	}
+	return nil
+}
+
+// MissingSigners returns the required signers that have not signed yet.
+func (t *OfflineTransaction) MissingSigners() ([]solana.PublicKey, error) {
+	msg, err := t.DecodeMessage()
+	if err != nil {
+		return nil, err
+	}
+	var missing []solana.PublicKey
+	for _, key := range msg.Signers() {
+		if _, ok := t.Signatures[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	return missing, nil
+}
+
+// Transaction returns the fully signed transaction.
+func (t *OfflineTransaction) Transaction() (*solana.Transaction, error) {
+	if err := t.Verify(); err != nil {
+		return nil, err
+	}
+	msg, err := t.DecodeMessage()
+	if err != nil {
+		return nil, err
+	}
+	tx := &solana.Transaction{Message: *msg}
+	for _, key := range msg.Signers() {
+		sig, ok := t.Signatures[key]
+		if !ok {
+			return nil, fmt.Errorf("missing signature of %s", key)
+		}
+		tx.Signatures = append(tx.Signatures, sig)
+	}
+	return tx, nil
+}
+
+// Review returns a human-readable description of the transaction for review before signing.
+//
+// Instructions of the given Pyth program are decoded with their payloads.
+func (t *OfflineTransaction) Review(program solana.PublicKey) (string, error) {
+	msg, err := t.DecodeMessage()
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	fmt.Fprintf(&out, "  fee payer: %s\n", msg.AccountKeys[0])
+	fmt.Fprintf(&out, "  blockhash: %s\n", msg.RecentBlockhash)
+	out.WriteString("  signers:\n")
+	for _, key := range msg.Signers() {
+		status := "missing"
+		if _, ok := t.Signatures[key]; ok {
+			status = "signed"
+		}
+		fmt.Fprintf(&out, "    %s (%s)\n", key, status)
+	}
+	out.WriteString("  instructions:\n")
+	for i, compiled := range msg.Instructions {
+		programKey, err := msg.Program(compiled.ProgramIDIndex)
+		if err != nil {
+			return "", err
+		}
+		accounts, err := compiled.ResolveInstructionAccounts(msg)
+		if err != nil {
+			return "", err
+		}
+		switch programKey {
+		case program:
+			ins, err := DecodeInstruction(program, accounts, compiled.Data)
+			if err != nil {
+				return "", fmt.Errorf("instruction %d: %w", i, err)
+			}
+			fmt.Fprintf(&out, "    %d: Pyth %s\n", i, InstructionIDToName(ins.Header.Cmd))
+			if ins.Payload != nil {
+				fmt.Fprintf(&out, "       payload: %+v\n", reflect.Indirect(reflect.ValueOf(ins.Payload)))
+			}
+		case solana.SystemProgramID:
+			ins, err := system.DecodeInstruction(accounts, compiled.Data)
+			if err != nil {
+				return "", fmt.Errorf("instruction %d: %w", i, err)
+			}
+			name := system.InstructionIDToName(ins.TypeID.Uint32())
+			if i == 0 && name == "AdvanceNonceAccount" {
+				name += " (durable nonce)"
+			}
+			fmt.Fprintf(&out, "    %d: System %s\n", i, name)
+			if create, ok := ins.Impl.(*system.CreateAccount); ok {
+				fmt.Fprintf(&out, "       lamports: %d, space: %d, owner: %s\n", *create.Lamports, *create.Space, *create.Owner)
+			}
+		default:
+			fmt.Fprintf(&out, "    %d: program %s (%d bytes of data)\n", i, programKey, len(compiled.Data))
+		}
+		for _, meta := range accounts {
+			var flags []string
+			if meta.IsSigner {
+				flags = append(flags, "signer")
+			}
+			if meta.IsWritable {
+				flags = append(flags, "writable")
+			}
+			fmt.Fprintf(&out, "       account %s %s\n", meta.PublicKey, strings.Join(flags, ","))
+		}
+	}
+	return out.String(), nil
+}
+
+// offlineConfirmInterval is the interval at which SubmitOffline polls for confirmations.
+const offlineConfirmInterval = 500 * time.Millisecond
+
+// offlineConfirmTimeout bounds the wait for a transaction whose expiry cannot be tracked,
+// such as a blockhash transaction of a bundle without LastValidBlockHeight.
+const offlineConfirmTimeout = 2 * time.Minute
+
+// TransactionExpiredError is returned by SubmitOffline if a sent transaction can no longer land,
+// because its blockhash expired or its durable nonce was advanced, or it was not seen in time.
+type TransactionExpiredError struct {
+	Reason string
+}
+
+func (e *TransactionExpiredError) Error() string {
+	return "transaction expired: " + e.Reason
+}
+
+// SubmitOffline sends the fully signed transactions of a bundle in order.
+//
+// Each transaction is confirmed before the next is sent, as later transactions may depend on earlier ones.
+// A transaction that can no longer land fails with a *TransactionExpiredError.
+// Returns the signatures of the transactions confirmed so far.
+func (c *Client) SubmitOffline(ctx context.Context, bundle *OfflineBundle) ([]solana.Signature, error) {
+	// Check all transactions upfront to avoid submitting a bundle partially.
+	txs := make([]*solana.Transaction, len(bundle.Transactions))
+	for i, offline := range bundle.Transactions {
+		tx, err := offline.Transaction()
+		if err != nil {
+			return nil, fmt.Errorf("transaction %d: %w", i, err)
+		}
+		txs[i] = tx
+	}
+	sigs := make([]solana.Signature, 0, len(txs))
+	for i, tx := range txs {
+		sig, err := c.RPC.SendTransactionWithOpts(ctx, tx, rpc.TransactionOpts{
+			PreflightCommitment: rpc.CommitmentConfirmed,
+		})
+		if err != nil {
+			return sigs, fmt.Errorf("failed to send transaction %d: %w", i, err)
+		}
+		expired := c.offlineExpiry(tx, bundle.Transactions[i].LastValidBlockHeight)
+		if err := c.waitConfirmed(ctx, sig, expired); err != nil {
+			return sigs, fmt.Errorf("transaction %d (%s): %w", i, sig, err)
+		}
+		sigs = append(sigs, sig)
+	}
+	return sigs, nil
+}
+
+// offlineExpiry returns a check reporting why a sent transaction can no longer land, or "" if it still can.
+func (c *Client) offlineExpiry(tx *solana.Transaction, lastValidBlockHeight uint64) func(context.Context) (string, error) {
+	if nonceKey, ok := durableNonceAccount(&tx.Message); ok {
+		return func(ctx context.Context) (string, error) {
+			nonce, err := c.getNonceAccount(ctx, nonceKey)
+			if err != nil {
+				return "", err
+			}
+			if solana.Hash(nonce.Nonce) != tx.Message.RecentBlockhash {
+				return fmt.Sprintf("nonce account %s was advanced", nonceKey), nil
+			}
+			return "", nil
+		}
+	}
+	if lastValidBlockHeight > 0 {
+		return func(ctx context.Context) (string, error) {
+			height, err := c.RPC.GetBlockHeight(ctx, rpc.CommitmentConfirmed)
+			if err != nil {
+				return "", fmt.Errorf("failed to get block height: %w", err)
+			}
+			if height > lastValidBlockHeight {
+				return fmt.Sprintf("block height %d exceeds last valid block height %d", height, lastValidBlockHeight), nil
+			}
+			return "", nil
+		}
+	}
+	deadline := time.Now().Add(offlineConfirmTimeout)
+	return func(context.Context) (string, error) {
+		if time.Now().After(deadline) {
+			return fmt.Sprintf("not confirmed within %s", offlineConfirmTimeout), nil
+		}
+		return "", nil
+	}
+}
+
+// durableNonceAccount returns the nonce account of a transaction starting with an AdvanceNonceAccount instruction.
+func durableNonceAccount(msg *solana.Message) (solana.PublicKey, bool) {
+	if len(msg.Instructions) == 0 {
+		return solana.PublicKey{}, false
+	}
+	compiled := msg.Instructions[0]
+	if program, err := msg.Program(compiled.ProgramIDIndex); err != nil || program != solana.SystemProgramID {
+		return solana.PublicKey{}, false
+	}
+	accounts, err := compiled.ResolveInstructionAccounts(msg)
+	if err != nil {
+		return solana.PublicKey{}, false
+	}
+	ins, err := system.DecodeInstruction(accounts, compiled.Data)
+	if err != nil {
+		return solana.PublicKey{}, false
+	}
+	advance, ok := ins.Impl.(*system.AdvanceNonceAccount)
+	if !ok {
+		return solana.PublicKey{}, false
+	}
+	return advance.GetNonceAccount().PublicKey, true
+}
+
+// waitConfirmed polls the status of a transaction until it is confirmed.
+//
+// While the transaction is not seen, the expired check is polled as well.
+func (c *Client) waitConfirmed(ctx context.Context, sig solana.Signature, expired func(context.Context) (string, error)) error {
+	ticker := time.NewTicker(offlineConfirmInterval)
+	defer ticker.Stop()
+	for {
+		status, err := c.signatureStatus(ctx, sig)
+		if err != nil {
+			return err
+		}
+		if status == nil {
+			reason, err := expired(ctx)
+			if err != nil {
+				return err
+			}
+			if reason != "" {
+				// The transaction may have landed right before the check.
+				if status, err = c.signatureStatus(ctx, sig); err != nil {
+					return err
+				}
+				if status == nil {
+					return &TransactionExpiredError{Reason: reason}
+				}
+			}
+		}
+		if status != nil {
+			if status.Err != nil {
+				return fmt.Errorf("transaction failed: %v", status.Err)
+			}
+			if status.ConfirmationStatus != rpc.ConfirmationStatusProcessed {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// signatureStatus returns the status of a transaction, nil if not seen.
+func (c *Client) signatureStatus(ctx context.Context, sig solana.Signature) (*rpc.SignatureStatusesResult, error) {
+	res, err := c.RPC.GetSignatureStatuses(ctx, false, sig)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Value) == 0 {
+		return nil, nil
+	}
+	return res.Value[0], nil
+}
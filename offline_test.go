@@ -0,0 +1,316 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	bin "github.com/gagliardetto/binary"
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/programs/system"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testNonceAccount(t *testing.T, authority solana.PublicKey, nonce solana.Hash) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, bin.NewBinEncoder(&buf).Encode(&system.NonceAccount{
+		Version:          1,
+		State:            1,
+		AuthorizedPubkey: authority,
+		Nonce:            solana.PublicKey(nonce),
+	}))
+	return buf.Bytes()
+}
+
+func (f *fakeRPC) handleConfirmed(t *testing.T) {
+	f.handle("getSignatureStatuses", func(params json.RawMessage) interface{} {
+		var args []json.RawMessage
+		require.NoError(t, json.Unmarshal(params, &args))
+		var sigs []solana.Signature
+		require.NoError(t, json.Unmarshal(args[0], &sigs))
+		values := make([]interface{}, len(sigs))
+		for i := range sigs {
+			values[i] = map[string]interface{}{"slot": 101, "err": nil, "confirmationStatus": "confirmed"}
+		}
+		return map[string]interface{}{
+			"context": map[string]interface{}{"slot": 101},
+			"value":   values,
+		}
+	})
+}
+
+func TestClient_PrepareOffline_Multisig(t *testing.T) {
+	funding := NewKeypairSigner(solana.NewWallet().PrivateKey)
+	price := NewKeypairSigner(solana.NewWallet().PrivateKey)
+	authority := NewKeypairSigner(solana.NewWallet().PrivateKey)
+	nonceKey := solana.NewWallet().PublicKey()
+	nonce := solana.Hash{0x42}
+
+	rpcServer := newFakeRPC(t)
+	rpcServer.handleAccounts(t, map[solana.PublicKey][]byte{
+		nonceKey: testNonceAccount(t, authority.PublicKey(), nonce),
+	})
+	rpcServer.handleSend(t)
+	rpcServer.handleConfirmed(t)
+	client := NewClient(Devnet, rpcServer.URL, rpcServer.URL)
+
+	builder := NewInstructionBuilder(Devnet.Program)
+	instructions := []solana.Instruction{
+		builder.AddPublisher(funding.PublicKey(), price.PublicKey(), CommandAddPublisher{Publisher: solana.PublicKey{1}}),
+		builder.SetMinPub(funding.PublicKey(), price.PublicKey(), CommandSetMinPub{MinPub: 1}),
+	}
+	bundle, err := client.PrepareOffline(context.Background(), funding.PublicKey(), instructions, &OfflineOpts{
+		NonceAccounts: []solana.PublicKey{nonceKey},
+	})
+	require.NoError(t, err)
+	require.Len(t, bundle.Transactions, 1)
+	msg, err := bundle.Transactions[0].DecodeMessage()
+	require.NoError(t, err)
+	assert.Equal(t, nonce, msg.RecentBlockhash)
+	require.Len(t, msg.Instructions, 3)
+
+	review, err := bundle.Review(Devnet.Program)
+	require.NoError(t, err)
+	assert.Contains(t, review, "Transaction 1/1")
+	assert.Contains(t, review, "0: System AdvanceNonceAccount (durable nonce)")
+	assert.Contains(t, review, "1: Pyth add_publisher")
+	assert.Contains(t, review, "2: Pyth set_min_pub")
+	assert.Contains(t, review, "payload: {MinPub:1")
+	assert.Contains(t, review, funding.PublicKey().String()+" (missing)")
+
+	// Each signer signs its own copy of the bundle file.
+	dir := t.TempDir()
+	path := filepath.Join(dir, "bundle.json")
+	require.NoError(t, bundle.WriteFile(path))
+	var copies []*OfflineBundle
+	for _, signer := range []Signer{funding, price, authority} {
+		signed, err := ReadOfflineBundle(path)
+		require.NoError(t, err)
+		n, err := signed.Sign(context.Background(), signer)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		copies = append(copies, signed)
+	}
+
+	_, err = client.SubmitOffline(context.Background(), copies[0])
+	assert.EqualError(t, err, "transaction 0: missing signature of "+price.PublicKey().String())
+
+	combined, err := CombineOfflineBundles(copies...)
+	require.NoError(t, err)
+	missing, err := combined.MissingSigners()
+	require.NoError(t, err)
+	assert.Empty(t, missing)
+
+	sigs, err := client.SubmitOffline(context.Background(), combined)
+	require.NoError(t, err)
+	require.Len(t, sigs, 1)
+	require.Len(t, rpcServer.sentTransactions(), 1)
+	assert.Equal(t, sigs[0], rpcServer.sentTransactions()[0].Signatures[0])
+}
+
+func TestClient_PrepareOffline_NotEnoughNonces(t *testing.T) {
+	funding := solana.NewWallet().PublicKey()
+	authority := solana.NewWallet().PublicKey()
+	nonceKey := solana.NewWallet().PublicKey()
+
+	rpcServer := newFakeRPC(t)
+	rpcServer.handleAccounts(t, map[solana.PublicKey][]byte{
+		nonceKey: testNonceAccount(t, authority, solana.Hash{1}),
+	})
+	client := NewClient(Devnet, rpcServer.URL, rpcServer.URL)
+
+	// Distinct price accounts make the instructions span several transactions.
+	builder := NewInstructionBuilder(Devnet.Program)
+	var instructions []solana.Instruction
+	for i := 0; i < 40; i++ {
+		instructions = append(instructions, builder.SetMinPub(funding, solana.NewWallet().PublicKey(), CommandSetMinPub{MinPub: 1}))
+	}
+	_, err := client.PrepareOffline(context.Background(), funding, instructions, &OfflineOpts{
+		NonceAccounts: []solana.PublicKey{nonceKey},
+	})
+	assert.ErrorContains(t, err, "not enough nonce accounts (1)")
+}
+
+func TestClient_PrepareOfflineGroups(t *testing.T) {
+	funding := solana.NewWallet().PublicKey()
+	product := solana.NewWallet().PublicKey()
+	authority := solana.NewWallet().PublicKey()
+
+	nonces := make(map[solana.PublicKey][]byte)
+	var nonceKeys []solana.PublicKey
+	for i := 0; i < 8; i++ {
+		key := solana.NewWallet().PublicKey()
+		nonces[key] = testNonceAccount(t, authority, solana.Hash{byte(i)})
+		nonceKeys = append(nonceKeys, key)
+	}
+	rpcServer := newFakeRPC(t)
+	rpcServer.handleAccounts(t, nonces)
+	client := NewClient(Devnet, rpcServer.URL, rpcServer.URL)
+	opts := &OfflineOpts{NonceAccounts: nonceKeys}
+
+	builder := NewInstructionBuilder(Devnet.Program)
+	newGroups := func(filler int) [][]solana.Instruction {
+		var groups [][]solana.Instruction
+		for i := 0; i < filler; i++ {
+			groups = append(groups, []solana.Instruction{
+				builder.SetMinPub(funding, solana.NewWallet().PublicKey(), CommandSetMinPub{MinPub: 1}),
+			})
+		}
+		for i := 0; i < 12; i++ {
+			price := solana.NewWallet().PublicKey()
+			groups = append(groups, []solana.Instruction{
+				system.NewCreateAccountInstruction(1, PriceAccountLen, Devnet.Program, funding, price).Build(),
+				builder.AddPrice(funding, product, price, CommandAddPrice{Exponent: -8, PriceType: PriceTypePrice}),
+			})
+		}
+		return groups
+	}
+	// Reports whether a transaction other than the last one ends with an account creation.
+	splitsPair := func(bundle *OfflineBundle) bool {
+		for _, tx := range bundle.Transactions[:len(bundle.Transactions)-1] {
+			msg, err := tx.DecodeMessage()
+			require.NoError(t, err)
+			last := msg.Instructions[len(msg.Instructions)-1]
+			if program, _ := msg.Program(last.ProgramIDIndex); program == solana.SystemProgramID {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Find a layout where packing single instructions splits a create+init pair.
+	var groups [][]solana.Instruction
+	for filler := 0; filler < 32 && groups == nil; filler++ {
+		candidate := newGroups(filler)
+		var instructions []solana.Instruction
+		for _, group := range candidate {
+			instructions = append(instructions, group...)
+		}
+		bundle, err := client.PrepareOffline(context.Background(), funding, instructions, opts)
+		require.NoError(t, err)
+		if splitsPair(bundle) {
+			groups = candidate
+		}
+	}
+	require.NotNil(t, groups, "no layout splits a create+init pair")
+
+	bundle, err := client.PrepareOfflineGroups(context.Background(), funding, groups, opts)
+	require.NoError(t, err)
+	require.Greater(t, len(bundle.Transactions), 1)
+	assert.False(t, splitsPair(bundle))
+	var total int
+	for _, tx := range bundle.Transactions {
+		msg, err := tx.DecodeMessage()
+		require.NoError(t, err)
+		program, err := msg.Program(msg.Instructions[0].ProgramIDIndex)
+		require.NoError(t, err)
+		assert.Equal(t, solana.SystemProgramID, program) // AdvanceNonceAccount
+		total += len(msg.Instructions) - 1
+	}
+	var expected int
+	for _, group := range groups {
+		expected += len(group)
+	}
+	assert.Equal(t, expected, total)
+}
+
+func TestClient_SubmitOffline_Expired(t *testing.T) {
+	funding := NewKeypairSigner(solana.NewWallet().PrivateKey)
+	price := NewKeypairSigner(solana.NewWallet().PrivateKey)
+	authority := NewKeypairSigner(solana.NewWallet().PrivateKey)
+	nonceKey := solana.NewWallet().PublicKey()
+
+	rpcServer := newFakeRPC(t)
+	rpcServer.handleBlockhash()
+	rpcServer.handleSend(t)
+	rpcServer.handle("getSignatureStatuses", func(json.RawMessage) interface{} {
+		return map[string]interface{}{
+			"context": map[string]interface{}{"slot": 101},
+			"value":   []interface{}{nil},
+		}
+	})
+	rpcServer.handle("getBlockHeight", func(json.RawMessage) interface{} {
+		return 201
+	})
+	rpcServer.handleAccounts(t, map[solana.PublicKey][]byte{
+		nonceKey: testNonceAccount(t, authority.PublicKey(), solana.Hash{0x42}),
+	})
+	client := NewClient(Devnet, rpcServer.URL, rpcServer.URL)
+
+	builder := NewInstructionBuilder(Devnet.Program)
+	prepare := func(opts *OfflineOpts) *OfflineBundle {
+		opts.Signers = []Signer{funding, price, authority}
+		bundle, err := client.PrepareOffline(context.Background(), funding.PublicKey(), []solana.Instruction{
+			builder.SetMinPub(funding.PublicKey(), price.PublicKey(), CommandSetMinPub{MinPub: 1}),
+		}, opts)
+		require.NoError(t, err)
+		return bundle
+	}
+
+	// The blockhash expires at the block height returned by getLatestBlockhash.
+	bundle := prepare(&OfflineOpts{})
+	assert.Equal(t, uint64(200), bundle.Transactions[0].LastValidBlockHeight)
+	_, err := client.SubmitOffline(context.Background(), bundle)
+	var expired *TransactionExpiredError
+	require.ErrorAs(t, err, &expired)
+	assert.Equal(t, "block height 201 exceeds last valid block height 200", expired.Reason)
+
+	// The durable nonce was advanced by another transaction.
+	bundle = prepare(&OfflineOpts{NonceAccounts: []solana.PublicKey{nonceKey}})
+	rpcServer.handleAccounts(t, map[solana.PublicKey][]byte{
+		nonceKey: testNonceAccount(t, authority.PublicKey(), solana.Hash{0x43}),
+	})
+	_, err = client.SubmitOffline(context.Background(), bundle)
+	require.ErrorAs(t, err, &expired)
+	assert.Equal(t, "nonce account "+nonceKey.String()+" was advanced", expired.Reason)
+}
+
+func TestCombineOfflineBundles_Invalid(t *testing.T) {
+	rpcServer := newFakeRPC(t)
+	rpcServer.handleBlockhash()
+	client := NewClient(Devnet, rpcServer.URL, rpcServer.URL)
+
+	funding := NewKeypairSigner(solana.NewWallet().PrivateKey)
+	price := NewKeypairSigner(solana.NewWallet().PrivateKey)
+	builder := NewInstructionBuilder(Devnet.Program)
+	prepare := func(minPub uint8) *OfflineBundle {
+		bundle, err := client.PrepareOffline(context.Background(), funding.PublicKey(), []solana.Instruction{
+			builder.SetMinPub(funding.PublicKey(), price.PublicKey(), CommandSetMinPub{MinPub: minPub}),
+		}, &OfflineOpts{Signers: []Signer{funding}})
+		require.NoError(t, err)
+		return bundle
+	}
+
+	_, err := CombineOfflineBundles(prepare(1), prepare(2))
+	assert.EqualError(t, err, "bundle 1: transaction 0 differs")
+
+	// A signature of another key is rejected.
+	bundle := prepare(1)
+	impostor := NewKeypairSigner(solana.NewWallet().PrivateKey)
+	sig, err := impostor.SignMessage(context.Background(), bundle.Transactions[0].Message)
+	require.NoError(t, err)
+	bundle.Transactions[0].Signatures[price.PublicKey()] = sig
+	_, err = CombineOfflineBundles(prepare(1), bundle)
+	assert.EqualError(t, err, "bundle 1: transaction 0: invalid signature of "+price.PublicKey().String())
+
+	bundle = prepare(1)
+	bundle.Transactions[0].Signatures[impostor.PublicKey()] = sig
+	assert.EqualError(t, bundle.Verify(), "transaction 0: signature of "+impostor.PublicKey().String()+" is not required")
+}
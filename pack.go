@@ -0,0 +1,292 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+// MaxTransactionSize is the maximum size of a serialized Solana transaction, including signatures.
+const MaxTransactionSize = 1232
+
+// MaxComputeUnitLimit is the maximum compute budget of a Solana transaction.
+const MaxComputeUnitLimit = 1_400_000
+
+// ComputeBudgetProgramID is the native program setting compute limits and priority fees.
+var ComputeBudgetProgramID = solana.MustPublicKeyFromBase58("ComputeBudget111111111111111111111111111111")
+
+// Compute budget instruction discriminators.
+const (
+	computeBudgetSetComputeUnitLimit = 2
+	computeBudgetSetComputeUnitPrice = 3
+)
+
+// NewSetComputeUnitLimitInstruction requests a compute budget for the transaction.
+func NewSetComputeUnitLimitInstruction(units uint32) solana.Instruction {
+	data := make([]byte, 5)
+	data[0] = computeBudgetSetComputeUnitLimit
+	binary.LittleEndian.PutUint32(data[1:], units)
+	return solana.NewInstruction(ComputeBudgetProgramID, solana.AccountMetaSlice{}, data)
+}
+
+// NewSetComputeUnitPriceInstruction sets a priority fee in micro-lamports per compute unit.
+func NewSetComputeUnitPriceInstruction(microLamports uint64) solana.Instruction {
+	data := make([]byte, 9)
+	data[0] = computeBudgetSetComputeUnitPrice
+	binary.LittleEndian.PutUint64(data[1:], microLamports)
+	return solana.NewInstruction(ComputeBudgetProgramID, solana.AccountMetaSlice{}, data)
+}
+
+// DefaultUnitsPerUpdate is the default compute estimate of a single UpdPrice instruction.
+const DefaultUnitsPerUpdate = 20_000
+
+// PackOpts configures PackInstructions.
+type PackOpts struct {
+	// UnitsPerInstruction is the estimated compute units consumed by each packed instruction.
+	// Defaults to DefaultUnitsPerUpdate.
+	UnitsPerInstruction uint32
+	// MaxComputeUnits is the compute budget of a transaction. Defaults to MaxComputeUnitLimit.
+	MaxComputeUnits uint32
+	// SetComputeUnitLimit prepends a SetComputeUnitLimit instruction to each transaction,
+	// requesting only the estimated units of the packed instructions.
+	SetComputeUnitLimit bool
+	// ComputeUnitPrice prepends a SetComputeUnitPrice instruction to each transaction if non-zero.
+	// The priority fee is given in micro-lamports per compute unit.
+	ComputeUnitPrice uint64
+}
+
+// PackInstructions distributes instructions over as few transactions as possible.
+//
+// Each group of instructions fits into a single transaction paid by the payer,
+// within MaxTransactionSize and the compute budget.
+// Account metas shared between instructions, such as the funding key and the clock sysvar,
+// are only counted once, as a transaction lists each account once.
+// Instructions keep their order.
+//
+// Typically used with the output of InstructionBuilder.UpdPrice.
+// The opts may be nil.
+func PackInstructions(payer solana.PublicKey, instructions []solana.Instruction, opts *PackOpts) ([][]solana.Instruction, error) {
+	groups := make([][]solana.Instruction, len(instructions))
+	for i, ins := range instructions {
+		groups[i] = []solana.Instruction{ins}
+	}
+	return packGroups(payer, groups, opts, "instruction")
+}
+
+// PackInstructionGroups is like PackInstructions,
+// but never splits a group of instructions across transactions.
+//
+// Useful for instructions that must succeed or fail together,
+// such as creating an account and initializing it.
+// Fails if a group does not fit into a single transaction.
+func PackInstructionGroups(payer solana.PublicKey, groups [][]solana.Instruction, opts *PackOpts) ([][]solana.Instruction, error) {
+	return packGroups(payer, groups, opts, "instruction group")
+}
+
+// packGroups packs groups of instructions, naming a group that does not fit by what.
+func packGroups(payer solana.PublicKey, groups [][]solana.Instruction, opts *PackOpts, what string) ([][]solana.Instruction, error) {
+	if opts == nil {
+		opts = new(PackOpts)
+	}
+	units := opts.UnitsPerInstruction
+	if units == 0 {
+		units = DefaultUnitsPerUpdate
+	}
+	maxUnits := opts.MaxComputeUnits
+	if maxUnits == 0 || maxUnits > MaxComputeUnitLimit {
+		maxUnits = MaxComputeUnitLimit
+	}
+	if units > maxUnits {
+		return nil, fmt.Errorf("instruction exceeds compute budget (%d > %d units)", units, maxUnits)
+	}
+	maxPerTx := int(maxUnits / units)
+
+	// newTx starts a transaction with the compute budget instructions.
+	newTx := func() ([]solana.Instruction, *txSizer, error) {
+		sizer := newTxSizer(payer)
+		var tx []solana.Instruction
+		if opts.SetComputeUnitLimit {
+			// The limit is patched once the transaction is complete, size does not depend on the value.
+			ins := NewSetComputeUnitLimitInstruction(0)
+			if err := sizer.add(ins); err != nil {
+				return nil, nil, err
+			}
+			tx = append(tx, ins)
+		}
+		if opts.ComputeUnitPrice != 0 {
+			ins := NewSetComputeUnitPriceInstruction(opts.ComputeUnitPrice)
+			if err := sizer.add(ins); err != nil {
+				return nil, nil, err
+			}
+			tx = append(tx, ins)
+		}
+		return tx, sizer, nil
+	}
+	finish := func(tx []solana.Instruction, n int) []solana.Instruction {
+		if opts.SetComputeUnitLimit {
+			tx[0] = NewSetComputeUnitLimitInstruction(uint32(n) * units)
+		}
+		return tx
+	}
+
+	var packed [][]solana.Instruction
+	tx, sizer, err := newTx()
+	if err != nil {
+		return nil, err
+	}
+	n := 0
+	for i, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		if len(group) > maxPerTx {
+			return nil, fmt.Errorf("%s %d exceeds compute budget (%d > %d units)", what, i, len(group)*int(units), maxUnits)
+		}
+		size, err := sizer.sizeWith(group...)
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 && (size > MaxTransactionSize || n+len(group) > maxPerTx) {
+			packed = append(packed, finish(tx, n))
+			if tx, sizer, err = newTx(); err != nil {
+				return nil, err
+			}
+			n = 0
+			if size, err = sizer.sizeWith(group...); err != nil {
+				return nil, err
+			}
+		}
+		if size > MaxTransactionSize {
+			return nil, fmt.Errorf("%s %d does not fit into a transaction (%d > %d bytes)", what, i, size, MaxTransactionSize)
+		}
+		for _, ins := range group {
+			if err := sizer.add(ins); err != nil {
+				return nil, err
+			}
+		}
+		tx = append(tx, group...)
+		n += len(group)
+	}
+	if n > 0 {
+		packed = append(packed, finish(tx, n))
+	}
+	return packed, nil
+}
+
+// txSizer computes the serialized size of a legacy transaction while instructions are added.
+type txSizer struct {
+	keys    map[solana.PublicKey]struct{}
+	signers map[solana.PublicKey]struct{}
+	numIx   int
+	ixBytes int
+}
+
+func newTxSizer(payer solana.PublicKey) *txSizer {
+	return &txSizer{
+		keys:    map[solana.PublicKey]struct{}{payer: {}},
+		signers: map[solana.PublicKey]struct{}{payer: {}},
+	}
+}
+
+// sizeWith returns the transaction size if the instructions were added.
+func (s *txSizer) sizeWith(instructions ...solana.Instruction) (int, error) {
+	seenKeys := make(map[solana.PublicKey]struct{})
+	seenSigners := make(map[solana.PublicKey]struct{})
+	var newKeys, newSigners, ixBytes int
+	for _, ins := range instructions {
+		ixLen, keys, signers, err := s.measure(ins, seenKeys, seenSigners)
+		if err != nil {
+			return 0, err
+		}
+		newKeys += keys
+		newSigners += signers
+		ixBytes += ixLen
+	}
+	return s.size(len(s.keys)+newKeys, len(s.signers)+newSigners, s.numIx+len(instructions), s.ixBytes+ixBytes), nil
+}
+
+// add records the instruction.
+func (s *txSizer) add(ins solana.Instruction) error {
+	ixLen, _, _, err := s.measure(ins, make(map[solana.PublicKey]struct{}), make(map[solana.PublicKey]struct{}))
+	if err != nil {
+		return err
+	}
+	s.keys[ins.ProgramID()] = struct{}{}
+	for _, meta := range ins.Accounts() {
+		s.keys[meta.PublicKey] = struct{}{}
+		if meta.IsSigner {
+			s.signers[meta.PublicKey] = struct{}{}
+		}
+	}
+	s.numIx++
+	s.ixBytes += ixLen
+	return nil
+}
+
+// measure returns the compiled size of an instruction and the number of keys it adds.
+//
+// Keys already in seenKeys and seenSigners, such as of preceding instructions of a group,
+// are not counted again. The new keys are added to both sets.
+func (s *txSizer) measure(ins solana.Instruction, seenKeys, seenSigners map[solana.PublicKey]struct{}) (ixLen, newKeys, newSigners int, err error) {
+	data, err := ins.Data()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	accounts := ins.Accounts()
+	addKey := func(key solana.PublicKey, signer bool) {
+		if _, ok := seenKeys[key]; !ok {
+			seenKeys[key] = struct{}{}
+			if _, ok := s.keys[key]; !ok {
+				newKeys++
+			}
+		}
+		if _, ok := seenSigners[key]; signer && !ok {
+			seenSigners[key] = struct{}{}
+			if _, ok := s.signers[key]; !ok {
+				newSigners++
+			}
+		}
+	}
+	addKey(ins.ProgramID(), false)
+	for _, meta := range accounts {
+		addKey(meta.PublicKey, meta.IsSigner)
+	}
+	// program index, account indexes, data
+	ixLen = 1 + compactU16Len(len(accounts)) + len(accounts) + compactU16Len(len(data)) + len(data)
+	return ixLen, newKeys, newSigners, nil
+}
+
+func (s *txSizer) size(numKeys, numSigners, numIx, ixBytes int) int {
+	return compactU16Len(numSigners) + numSigners*64 + // signatures
+		3 + // message header
+		compactU16Len(numKeys) + numKeys*32 +
+		32 + // recent blockhash
+		compactU16Len(numIx) + ixBytes
+}
+
+// compactU16Len returns the size of a length prefix in Solana's compact-u16 encoding.
+func compactU16Len(n int) int {
+	switch {
+	case n < 0x80:
+		return 1
+	case n < 0x4000:
+		return 2
+	default:
+		return 3
+	}
+}
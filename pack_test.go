@@ -0,0 +1,146 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"testing"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testUpdPrices(funding solana.PublicKey, n int) []solana.Instruction {
+	builder := NewInstructionBuilder(Devnet.Program)
+	instructions := make([]solana.Instruction, n)
+	for i := range instructions {
+		priceKey := solana.PublicKey{byte(i), byte(i >> 8), 1}
+		instructions[i] = builder.UpdPrice(funding, priceKey, CommandUpdPrice{
+			Status:  PriceStatusTrading,
+			Price:   int64(i),
+			Conf:    1,
+			PubSlot: 1234,
+		})
+	}
+	return instructions
+}
+
+// txSize returns the size of a signed transaction with the given instructions.
+func txSize(t *testing.T, payer solana.PrivateKey, instructions []solana.Instruction) int {
+	tx, err := solana.NewTransaction(instructions, testBlockhash, solana.TransactionPayer(payer.PublicKey()))
+	require.NoError(t, err)
+	_, err = tx.Sign(func(solana.PublicKey) *solana.PrivateKey { return &payer })
+	require.NoError(t, err)
+	buf, err := tx.MarshalBinary()
+	require.NoError(t, err)
+	return len(buf)
+}
+
+func TestPackInstructions(t *testing.T) {
+	payer := solana.NewWallet().PrivateKey
+	instructions := testUpdPrices(payer.PublicKey(), 100)
+
+	packed, err := PackInstructions(payer.PublicKey(), instructions, nil)
+	require.NoError(t, err)
+	require.Greater(t, len(packed), 1)
+
+	var total int
+	for i, group := range packed {
+		total += len(group)
+		size := txSize(t, payer, group)
+		assert.LessOrEqual(t, size, MaxTransactionSize)
+
+		// Predicted size is exact.
+		sizer := newTxSizer(payer.PublicKey())
+		for _, ins := range group {
+			require.NoError(t, sizer.add(ins))
+		}
+		assert.Equal(t, size, sizer.size(len(sizer.keys), len(sizer.signers), sizer.numIx, sizer.ixBytes))
+
+		// Groups are full.
+		if i < len(packed)-1 {
+			next := append(append([]solana.Instruction(nil), group...), packed[i+1][0])
+			assert.Greater(t, txSize(t, payer, next), MaxTransactionSize)
+		}
+	}
+	assert.Equal(t, len(instructions), total)
+	assert.Same(t, instructions[0], packed[0][0])
+}
+
+func TestPackInstructionGroups(t *testing.T) {
+	payer := solana.NewWallet().PrivateKey
+	instructions := testUpdPrices(payer.PublicKey(), 100)
+
+	flat, err := PackInstructions(payer.PublicKey(), instructions, nil)
+	require.NoError(t, err)
+	// Pick a group size that makes the flat split fall inside a group.
+	groupSize := 2
+	for len(flat[0])%groupSize == 0 {
+		groupSize++
+	}
+	var groups [][]solana.Instruction
+	for i := 0; i < len(instructions); i += groupSize {
+		end := i + groupSize
+		if end > len(instructions) {
+			end = len(instructions)
+		}
+		groups = append(groups, instructions[i:end])
+	}
+
+	packed, err := PackInstructionGroups(payer.PublicKey(), groups, nil)
+	require.NoError(t, err)
+	require.Greater(t, len(packed), 1)
+	var total int
+	for i, tx := range packed {
+		assert.LessOrEqual(t, txSize(t, payer, tx), MaxTransactionSize)
+		total += len(tx)
+		if i < len(packed)-1 {
+			assert.Zero(t, total%groupSize, "transaction %d splits a group", i)
+		}
+	}
+	assert.Equal(t, len(instructions), total)
+
+	_, err = PackInstructionGroups(payer.PublicKey(), [][]solana.Instruction{instructions[:1], instructions[:len(flat[0])+1]}, nil)
+	assert.ErrorContains(t, err, "instruction group 1 does not fit into a transaction")
+}
+
+func TestPackInstructions_ComputeBudget(t *testing.T) {
+	payer := solana.NewWallet().PrivateKey
+	instructions := testUpdPrices(payer.PublicKey(), 10)
+
+	packed, err := PackInstructions(payer.PublicKey(), instructions, &PackOpts{
+		UnitsPerInstruction: 300_000,
+		SetComputeUnitLimit: true,
+		ComputeUnitPrice:    1000,
+	})
+	require.NoError(t, err)
+	require.Len(t, packed, 3) // 4 + 4 + 2
+
+	last := packed[2]
+	require.Len(t, last, 4)
+	assert.Equal(t, ComputeBudgetProgramID, last[0].ProgramID())
+	data, err := last[0].Data()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{2, 0xC0, 0x27, 0x09, 0x00}, data) // 600000 units
+	data, err = last[1].Data()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{3, 0xE8, 0x03, 0, 0, 0, 0, 0, 0}, data)
+	for _, group := range packed {
+		assert.LessOrEqual(t, txSize(t, payer, group), MaxTransactionSize)
+	}
+
+	_, err = PackInstructions(payer.PublicKey(), instructions, &PackOpts{UnitsPerInstruction: 2_000_000})
+	assert.EqualError(t, err, "instruction exceeds compute budget (2000000 > 1400000 units)")
+}
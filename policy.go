@@ -0,0 +1,84 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+// ChangePolicy decides whether a price update is reported to a callback.
+//
+// The first update seen by a registration is always reported.
+type ChangePolicy struct {
+	// Name identifies the policy in PriceUpdate.Policy.
+	Name string
+	// Changed compares the previously seen and the new price info.
+	// Both arguments are non-nil.
+	Changed func(prev, next *PriceInfo) bool
+}
+
+// Built-in change detection policies.
+var (
+	// ChangeStatusOrSlot reports updates with a new status or publish slot.
+	// This is the default policy, see PriceInfo.HasChanged.
+	ChangeStatusOrSlot = ChangePolicy{
+		Name:    "status_or_slot",
+		Changed: (*PriceInfo).HasChanged,
+	}
+	// ChangeAnyField reports updates where any field of the price info differs.
+	ChangeAnyField = ChangePolicy{
+		Name: "any_field",
+		Changed: func(prev, next *PriceInfo) bool {
+			return *prev != *next
+		},
+	}
+	// ChangePriceOrConf reports updates where the price or confidence interval differs,
+	// ignoring updates that only advance the slot.
+	ChangePriceOrConf = ChangePolicy{
+		Name: "price_or_conf",
+		Changed: func(prev, next *PriceInfo) bool {
+			return prev.Price != next.Price || prev.Conf != next.Conf
+		},
+	}
+	// ChangeSlot reports updates with a new publish slot.
+	ChangeSlot = ChangePolicy{
+		Name: "slot",
+		Changed: func(prev, next *PriceInfo) bool {
+			return prev.PubSlot != next.PubSlot
+		},
+	}
+)
+
+// changeAlways reports every update, used by internal registrations.
+var changeAlways = ChangePolicy{
+	Name: "always",
+	Changed: func(_, _ *PriceInfo) bool {
+		return true
+	},
+}
+
+// CustomChangePolicy creates a policy from a comparator.
+func CustomChangePolicy(name string, changed func(prev, next *PriceInfo) bool) ChangePolicy {
+	return ChangePolicy{Name: name, Changed: changed}
+}
+
+// orDefault returns the default policy if none is set.
+func (c ChangePolicy) orDefault() ChangePolicy {
+	if c.Changed == nil {
+		return ChangeStatusOrSlot
+	}
+	return c
+}
+
+// hasChanged applies the policy, always reporting the first update.
+func (c ChangePolicy) hasChanged(prev, next *PriceInfo) bool {
+	return prev == nil || c.Changed(prev, next)
+}
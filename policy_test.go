@@ -0,0 +1,80 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangePolicy(t *testing.T) {
+	base := PriceInfo{Price: 100, Conf: 1, Status: PriceStatusTrading, PubSlot: 10}
+	priceMoved := base
+	priceMoved.Price = 101
+	slotMoved := base
+	slotMoved.PubSlot = 11
+	corpAct := base
+	corpAct.CorpAct = 1
+
+	cases := []struct {
+		policy ChangePolicy
+		next   PriceInfo
+		want   bool
+	}{
+		{ChangeStatusOrSlot, priceMoved, false},
+		{ChangeStatusOrSlot, slotMoved, true},
+		{ChangeAnyField, base, false},
+		{ChangeAnyField, corpAct, true},
+		{ChangePriceOrConf, priceMoved, true},
+		{ChangePriceOrConf, slotMoved, false},
+		{ChangeSlot, slotMoved, true},
+		{ChangeSlot, priceMoved, false},
+	}
+	for _, c := range cases {
+		next := c.next
+		assert.Equal(t, c.want, c.policy.hasChanged(&base, &next), "%s: %+v", c.policy.Name, next)
+		assert.True(t, c.policy.hasChanged(nil, &next), "%s: first update", c.policy.Name)
+	}
+}
+
+func TestPriceEventHandler_OnPriceChangeWithPolicy(t *testing.T) {
+	src := newTestSource()
+	handler := NewPriceEventHandler(src)
+
+	var updates []PriceUpdate
+	policy := CustomChangePolicy("price_up", func(prev, next *PriceInfo) bool {
+		return next.Price > prev.Price
+	})
+	handler.OnPriceChangeWithPolicy(testPriceKey, policy, func(update PriceUpdate) {
+		updates = append(updates, update)
+	})
+
+	for slot, price := range []int64{100000, 100000, 99000, 101000} {
+		src.send(testPriceKey, price, uint64(slot))
+	}
+	src.Close()
+	require.NoError(t, handler.Wait())
+
+	require.Len(t, updates, 2)
+	assert.Equal(t, "price_up", updates[1].Policy)
+	// Test account has exponent -5.
+	price, _, _ := updates[1].Current()
+	assert.True(t, decimal.RequireFromString("1.01").Equal(price), price.String())
+	prevPrice, _, _ := updates[1].Previous()
+	assert.True(t, decimal.RequireFromString("0.99").Equal(prevPrice), prevPrice.String())
+}
@@ -0,0 +1,624 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/gagliardetto/solana-go/rpc/ws"
+	"go.uber.org/zap"
+)
+
+// PublisherOpts configures a Publisher.
+type PublisherOpts struct {
+	// Commitment is used to fetch blockhashes and slots. Defaults to confirmed.
+	Commitment rpc.CommitmentType
+	// BlockhashRefresh is how often the cached blockhash is replaced. Defaults to 10 seconds.
+	BlockhashRefresh time.Duration
+	// StatusInterval is how often the status of sent transactions is checked. Defaults to 2 seconds.
+	StatusInterval time.Duration
+	// Expiry is how long a sent transaction may go unseen on-chain before it is counted as failed.
+	// Transactions already seen at processed commitment remain pending until confirmed or dropped.
+	// Defaults to 90 seconds, roughly the lifetime of a blockhash.
+	Expiry time.Duration
+	// NoFailOnError uses UpdPriceNoFailOnError instead of UpdPrice.
+	NoFailOnError bool
+	// SkipPreflight disables transaction simulation by the RPC node.
+	SkipPreflight bool
+	// Pack configures how PublishBatch packs updates into transactions.
+	Pack PackOpts
+	// Stream shares the slot subscription of a price account stream opened with StreamOpts.TrackSlots,
+	// instead of opening a separate WebSocket connection for slot updates.
+	// If the stream does not track slots, the publisher opens its own connection.
+	Stream *PriceAccountStream
+}
+
+// Publisher submits component prices to the Pyth on-chain program.
+//
+// It keeps a recent blockhash and the current slot in the background,
+// so that Publish only signs and sends a transaction.
+// Slots are followed over a WebSocket connection of its own,
+// or over the connection of a PriceAccountStream (see PublisherOpts.Stream).
+type Publisher struct {
+	client    *Client
+	signer    Signer
+	opts      PublisherOpts
+	builder   *InstructionBuilder
+	priceKeys map[solana.PublicKey]struct{}
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	slot uint64 // atomic, latest slot seen by the slot subscription
+
+	blockhashLock sync.Mutex // lock over blockhash and blockhashTime
+	blockhash     solana.Hash
+	blockhashTime time.Time
+
+	pendingLock sync.Mutex // lock over pending
+	pending     map[solana.Signature]*pendingTx
+
+	hooksLock   sync.Mutex // lock over resultHooks and slotWatch
+	resultHooks map[uint64]func(TxResult)
+	slotWatch   map[uint64]chan uint64
+	hookNonce   uint64
+}
+
+// pendingTx is a sent transaction that has not been resolved yet.
+type pendingTx struct {
+	sentAt    time.Time
+	pubSlot   uint64
+	priceKeys []solana.PublicKey
+	notify    func(TxResult) // optional, called with the outcome
+}
+
+// TxOutcome classifies the result of a sent transaction.
+type TxOutcome int
+
+const (
+	TxLanded         TxOutcome = iota // confirmed without error
+	TxExpired                         // not seen on-chain before PublisherOpts.Expiry, usually an expired blockhash
+	TxProgramError                    // confirmed with an error
+	TxNotInComponent                  // confirmed, but the publisher's component did not advance (see LandingTracker)
+)
+
+// String returns the name of the outcome, as used in metric labels.
+func (o TxOutcome) String() string {
+	switch o {
+	case TxLanded:
+		return "landed"
+	case TxExpired:
+		return "expired"
+	case TxProgramError:
+		return "program_error"
+	case TxNotInComponent:
+		return "not_in_component"
+	default:
+		return "unknown"
+	}
+}
+
+// TxResult describes the outcome of a price update transaction.
+type TxResult struct {
+	Signature solana.Signature
+	Outcome   TxOutcome
+	PriceKeys []solana.PublicKey // updated price accounts, in order
+	PubSlot   uint64             // publish slot of the updates
+	Slot      uint64             // slot the transaction was processed in, zero if expired
+	Latency   time.Duration      // time from sending to resolution
+	Err       error              // cause of TxProgramError, see ParseTransactionError
+}
+
+// NewPublisher creates a publisher for the given price accounts.
+//
+// The signer must be a publisher of all price accounts and pays transaction fees.
+// A private key held in memory can be used with NewKeypairSigner.
+// Background tasks run until the context is canceled or Close is called.
+// The opts may be nil.
+func (c *Client) NewPublisher(ctx context.Context, signer Signer, priceKeys []solana.PublicKey, opts *PublisherOpts) *Publisher {
+	if opts == nil {
+		opts = new(PublisherOpts)
+	}
+	p := &Publisher{
+		client:    c,
+		signer:    signer,
+		opts:      *opts,
+		builder:   NewInstructionBuilder(c.Env.Program),
+		priceKeys: make(map[solana.PublicKey]struct{}, len(priceKeys)),
+		pending:   make(map[solana.Signature]*pendingTx),
+	}
+	if p.opts.Commitment == "" {
+		p.opts.Commitment = rpc.CommitmentConfirmed
+	}
+	if p.opts.BlockhashRefresh <= 0 {
+		p.opts.BlockhashRefresh = 10 * time.Second
+	}
+	if p.opts.StatusInterval <= 0 {
+		p.opts.StatusInterval = 2 * time.Second
+	}
+	if p.opts.Expiry <= 0 {
+		p.opts.Expiry = 90 * time.Second
+	}
+	for _, key := range priceKeys {
+		p.priceKeys[key] = struct{}{}
+	}
+
+	ctx, p.cancel = context.WithCancel(ctx)
+	p.wg.Add(3)
+	go func() {
+		defer p.wg.Done()
+		p.refreshBlockhashes(ctx)
+	}()
+	if stream := p.opts.Stream; stream != nil && stream.opts.TrackSlots {
+		stopSlots := stream.onSlot(p.observeSlot)
+		if tip := stream.Health().TipSlot; tip != 0 {
+			p.observeSlot(tip)
+		}
+		go func() {
+			defer p.wg.Done()
+			<-ctx.Done()
+			stopSlots()
+		}()
+	} else {
+		go func() {
+			defer p.wg.Done()
+			p.trackSlots(ctx)
+		}()
+	}
+	go func() {
+		defer p.wg.Done()
+		p.checkStatuses(ctx)
+	}()
+	return p
+}
+
+// PublicKey returns the key of the publisher.
+func (p *Publisher) PublicKey() solana.PublicKey {
+	return p.signer.PublicKey()
+}
+
+// PriceKeys returns the price accounts the publisher is configured for.
+func (p *Publisher) PriceKeys() []solana.PublicKey {
+	keys := make([]solana.PublicKey, 0, len(p.priceKeys))
+	for key := range p.priceKeys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i][:], keys[j][:]) < 0
+	})
+	return keys
+}
+
+// Slot returns the latest slot seen, or zero if none is known yet.
+func (p *Publisher) Slot() uint64 {
+	return atomic.LoadUint64(&p.slot)
+}
+
+// Close stops all background tasks.
+//
+// Transactions still pending are no longer tracked.
+func (p *Publisher) Close() {
+	p.cancel()
+	p.wg.Wait()
+}
+
+// Publish sends a transaction updating the component price of the publisher.
+//
+// The price and conf are in the fixed-point format of the price account (see PriceAccount.Exponent).
+// The publish slot is taken from the slot subscription.
+// Returns once the transaction has been sent, without waiting for it to land.
+func (p *Publisher) Publish(ctx context.Context, priceKey solana.PublicKey, price int64, conf uint64, status uint32) (solana.Signature, error) {
+	sigs, err := p.PublishBatch(ctx, []PriceUpdateRequest{{
+		PriceKey: priceKey,
+		Price:    price,
+		Conf:     conf,
+		Status:   status,
+	}})
+	if err != nil {
+		return solana.Signature{}, err
+	}
+	return sigs[0], nil
+}
+
+// PriceUpdateRequest is a component price to publish with PublishBatch.
+type PriceUpdateRequest struct {
+	PriceKey solana.PublicKey
+	Price    int64
+	Conf     uint64
+	Status   uint32
+}
+
+// PublishBatch sends the updates in as few transactions as possible, see PackInstructions.
+//
+// Returns the signatures of all transactions sent until the first error.
+func (p *Publisher) PublishBatch(ctx context.Context, updates []PriceUpdateRequest) ([]solana.Signature, error) {
+	for _, update := range updates {
+		if _, ok := p.priceKeys[update.PriceKey]; !ok {
+			return nil, fmt.Errorf("price account %s not configured for publisher", update.PriceKey)
+		}
+	}
+	pubSlot, err := p.pubSlot(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get slot: %w", err)
+	}
+	sigs, _, err := p.publishAt(ctx, updates, pubSlot, nil)
+	return sigs, err
+}
+
+// Track adds a transaction sent by other means to the tracked transactions,
+// so that its outcome is reported like those sent by Publish.
+//
+// The price keys and publish slot are those of the updates in the transaction.
+func (p *Publisher) Track(sig solana.Signature, priceKeys []solana.PublicKey, pubSlot uint64) {
+	p.pendingLock.Lock()
+	defer p.pendingLock.Unlock()
+	p.pending[sig] = &pendingTx{
+		sentAt:    time.Now(),
+		pubSlot:   pubSlot,
+		priceKeys: priceKeys,
+	}
+}
+
+// publishAt packs and sends updates with the given publish slot.
+//
+// Returns the signatures and the number of updates covered by sent transactions.
+// The optional notify function is called with the outcome of each sent transaction.
+func (p *Publisher) publishAt(ctx context.Context, updates []PriceUpdateRequest, pubSlot uint64, notify func(TxResult)) (sigs []solana.Signature, sent int, err error) {
+	instructions := make([]solana.Instruction, len(updates))
+	for i, update := range updates {
+		instructions[i] = p.updPrice(update.PriceKey, CommandUpdPrice{
+			Status:  update.Status,
+			Price:   update.Price,
+			Conf:    update.Conf,
+			PubSlot: pubSlot,
+		})
+	}
+	packed, err := PackInstructions(p.signer.PublicKey(), instructions, &p.opts.Pack)
+	if err != nil {
+		return nil, 0, err
+	}
+	sigs = make([]solana.Signature, 0, len(packed))
+	for _, group := range packed {
+		// Groups contain the updates in order, after any compute budget instructions.
+		var priceKeys []solana.PublicKey
+		for _, ins := range group {
+			if ins.ProgramID() == p.client.Env.Program {
+				priceKeys = append(priceKeys, updates[sent+len(priceKeys)].PriceKey)
+			}
+		}
+		sig, err := p.send(ctx, group, &pendingTx{priceKeys: priceKeys, pubSlot: pubSlot, notify: notify})
+		if err != nil {
+			return sigs, sent, err
+		}
+		sigs = append(sigs, sig)
+		sent += len(priceKeys)
+	}
+	return sigs, sent, nil
+}
+
+// updPrice builds the update instruction selected by PublisherOpts.NoFailOnError.
+func (p *Publisher) updPrice(priceKey solana.PublicKey, payload CommandUpdPrice) *Instruction {
+	if p.opts.NoFailOnError {
+		return p.builder.UpdPriceNoFailOnError(p.signer.PublicKey(), priceKey, payload)
+	}
+	return p.builder.UpdPrice(p.signer.PublicKey(), priceKey, payload)
+}
+
+// send signs and sends a transaction, tracking it until it lands.
+func (p *Publisher) send(ctx context.Context, instructions []solana.Instruction, pending *pendingTx) (solana.Signature, error) {
+	blockhash, err := p.recentBlockhash(ctx)
+	if err != nil {
+		metricsPublisherTxFailedTotal.WithLabelValues("blockhash").Inc()
+		return solana.Signature{}, fmt.Errorf("failed to get blockhash: %w", err)
+	}
+	tx, err := solana.NewTransaction(instructions, blockhash, solana.TransactionPayer(p.signer.PublicKey()))
+	if err != nil {
+		metricsPublisherTxFailedTotal.WithLabelValues("build").Inc()
+		return solana.Signature{}, fmt.Errorf("failed to build transaction: %w", err)
+	}
+	if err := SignTransaction(ctx, tx, p.signer); err != nil {
+		metricsPublisherTxFailedTotal.WithLabelValues("sign").Inc()
+		return solana.Signature{}, fmt.Errorf("failed to sign transaction: %w", err)
+	}
+
+	sig, err := p.client.RPC.SendTransactionWithOpts(ctx, tx, rpc.TransactionOpts{
+		SkipPreflight:       p.opts.SkipPreflight,
+		PreflightCommitment: p.opts.Commitment,
+	})
+	if err != nil {
+		metricsPublisherTxFailedTotal.WithLabelValues("send").Inc()
+		return solana.Signature{}, p.client.DecodeError(err, tx)
+	}
+	metricsPublisherTxSentTotal.Inc()
+
+	pending.sentAt = time.Now()
+	p.pendingLock.Lock()
+	p.pending[sig] = pending
+	p.pendingLock.Unlock()
+	return sig, nil
+}
+
+// pubSlot returns the slot to publish at, querying the RPC node if no slot was streamed yet.
+func (p *Publisher) pubSlot(ctx context.Context) (uint64, error) {
+	if slot := p.Slot(); slot != 0 {
+		return slot, nil
+	}
+	slot, err := p.client.RPC.GetSlot(ctx, p.opts.Commitment)
+	if err != nil {
+		return 0, err
+	}
+	p.observeSlot(slot)
+	return slot, nil
+}
+
+func (p *Publisher) observeSlot(slot uint64) {
+	for {
+		prev := atomic.LoadUint64(&p.slot)
+		if slot <= prev {
+			return
+		}
+		if atomic.CompareAndSwapUint64(&p.slot, prev, slot) {
+			p.notifySlot(slot)
+			return
+		}
+	}
+}
+
+// recentBlockhash returns the cached blockhash, fetching a new one if it is outdated.
+func (p *Publisher) recentBlockhash(ctx context.Context) (solana.Hash, error) {
+	p.blockhashLock.Lock()
+	defer p.blockhashLock.Unlock()
+	if !p.blockhash.IsZero() && time.Since(p.blockhashTime) < p.opts.BlockhashRefresh {
+		return p.blockhash, nil
+	}
+	res, err := p.client.RPC.GetLatestBlockhash(ctx, p.opts.Commitment)
+	if err != nil {
+		return solana.Hash{}, err
+	}
+	if res == nil || res.Value == nil {
+		return solana.Hash{}, errors.New("empty blockhash response")
+	}
+	p.blockhash = res.Value.Blockhash
+	p.blockhashTime = time.Now()
+	return p.blockhash, nil
+}
+
+func (p *Publisher) refreshBlockhashes(ctx context.Context) {
+	ticker := time.NewTicker(p.opts.BlockhashRefresh / 2)
+	defer ticker.Stop()
+	for {
+		if _, err := p.recentBlockhash(ctx); err != nil && ctx.Err() == nil {
+			p.client.Log.Warn("Failed to refresh blockhash", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// trackSlots follows the cluster tip using a WebSocket slot subscription.
+func (p *Publisher) trackSlots(ctx context.Context) {
+	const retryInterval = 3 * time.Second
+	_ = backoff.Retry(func() error {
+		err := p.trackSlotsConn(ctx)
+		if ctx.Err() != nil {
+			return backoff.Permanent(ctx.Err())
+		}
+		p.client.Log.Warn("Slot subscription failed, restarting", zap.Error(err))
+		return err
+	}, backoff.WithContext(backoff.NewConstantBackOff(retryInterval), ctx))
+}
+
+func (p *Publisher) trackSlotsConn(ctx context.Context) error {
+	client, err := ws.Connect(ctx, p.client.WebSocketURL)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	sub, err := client.SlotSubscribe()
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+
+	// Make sure the subscription cannot outlive the context.
+	connCtx, connCancel := context.WithCancel(ctx)
+	defer connCancel()
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		<-connCtx.Done()
+		client.Close()
+	}()
+
+	for {
+		slot, err := sub.Recv()
+		if err != nil {
+			return err
+		}
+		if slot == nil {
+			return errors.New("slot subscription closed")
+		}
+		p.observeSlot(slot.Slot)
+	}
+}
+
+// checkStatuses periodically resolves pending transactions into landed or failed.
+func (p *Publisher) checkStatuses(ctx context.Context) {
+	ticker := time.NewTicker(p.opts.StatusInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := p.checkPending(ctx); err != nil && ctx.Err() == nil {
+			p.client.Log.Warn("Failed to check transaction statuses", zap.Error(err))
+		}
+	}
+}
+
+// maxSignatureStatuses is the maximum number of signatures per getSignatureStatuses request.
+const maxSignatureStatuses = 256
+
+func (p *Publisher) checkPending(ctx context.Context) error {
+	p.pendingLock.Lock()
+	sigs := make([]solana.Signature, 0, len(p.pending))
+	for sig := range p.pending {
+		sigs = append(sigs, sig)
+	}
+	p.pendingLock.Unlock()
+
+	for len(sigs) > 0 {
+		batch := sigs
+		if len(batch) > maxSignatureStatuses {
+			batch = batch[:maxSignatureStatuses]
+		}
+		sigs = sigs[len(batch):]
+
+		res, err := p.client.RPC.GetSignatureStatuses(ctx, false, batch...)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		var results []pendingResult
+		p.pendingLock.Lock()
+		for i, sig := range batch {
+			var status *rpc.SignatureStatusesResult
+			if i < len(res.Value) {
+				status = res.Value[i]
+			}
+			tx := p.pending[sig]
+			result := pendingResult{TxResult: TxResult{
+				Signature: sig,
+				PriceKeys: tx.priceKeys,
+				PubSlot:   tx.pubSlot,
+				Latency:   now.Sub(tx.sentAt),
+			}, notify: tx.notify}
+			switch {
+			case status != nil && status.Err != nil:
+				result.Outcome = TxProgramError
+				result.Slot = status.Slot
+				result.Err = ParseTransactionError(status.Err)
+			case status != nil && status.ConfirmationStatus != rpc.ConfirmationStatusProcessed:
+				result.Outcome = TxLanded
+				result.Slot = status.Slot
+			case status == nil && result.Latency > p.opts.Expiry:
+				// Processed transactions can still land, they remain pending until confirmed or dropped.
+				result.Outcome = TxExpired
+			default:
+				continue
+			}
+			delete(p.pending, sig)
+			results = append(results, result)
+		}
+		p.pendingLock.Unlock()
+
+		for _, result := range results {
+			p.resolve(result.TxResult)
+			if result.notify != nil {
+				result.notify(result.TxResult)
+			}
+		}
+	}
+	return nil
+}
+
+type pendingResult struct {
+	TxResult
+	notify func(TxResult)
+}
+
+// resolve records the outcome of a transaction.
+func (p *Publisher) resolve(result TxResult) {
+	if result.Outcome == TxLanded {
+		metricsPublisherTxLandedTotal.Inc()
+	} else {
+		metricsPublisherTxFailedTotal.WithLabelValues(result.Outcome.String()).Inc()
+	}
+	p.hooksLock.Lock()
+	hooks := make([]func(TxResult), 0, len(p.resultHooks))
+	for _, hook := range p.resultHooks {
+		hooks = append(hooks, hook)
+	}
+	p.hooksLock.Unlock()
+	for _, hook := range hooks {
+		hook(result)
+	}
+}
+
+// onResult registers a function called with the outcome of each sent transaction.
+func (p *Publisher) onResult(hook func(TxResult)) (cancel func()) {
+	p.hooksLock.Lock()
+	defer p.hooksLock.Unlock()
+	if p.resultHooks == nil {
+		p.resultHooks = make(map[uint64]func(TxResult))
+	}
+	p.hookNonce++
+	key := p.hookNonce
+	p.resultHooks[key] = hook
+	return func() {
+		p.hooksLock.Lock()
+		defer p.hooksLock.Unlock()
+		delete(p.resultHooks, key)
+	}
+}
+
+// watchSlots returns a channel receiving new slots.
+//
+// Slots are dropped if the receiver falls behind, only the latest slot is kept.
+func (p *Publisher) watchSlots() (slots <-chan uint64, cancel func()) {
+	ch := make(chan uint64, 1)
+	p.hooksLock.Lock()
+	defer p.hooksLock.Unlock()
+	if p.slotWatch == nil {
+		p.slotWatch = make(map[uint64]chan uint64)
+	}
+	p.hookNonce++
+	key := p.hookNonce
+	p.slotWatch[key] = ch
+	return ch, func() {
+		p.hooksLock.Lock()
+		defer p.hooksLock.Unlock()
+		delete(p.slotWatch, key)
+	}
+}
+
+func (p *Publisher) notifySlot(slot uint64) {
+	p.hooksLock.Lock()
+	defer p.hooksLock.Unlock()
+	for _, ch := range p.slotWatch {
+		// Replace an unread slot with the newer one.
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- slot
+	}
+}
@@ -0,0 +1,297 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	bin "github.com/gagliardetto/binary"
+	"github.com/gagliardetto/solana-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRPC is a minimal Solana JSON-RPC server.
+type fakeRPC struct {
+	*httptest.Server
+	lock    sync.Mutex
+	methods map[string]func(params json.RawMessage) interface{}
+	sent    []*solana.Transaction // transactions received by sendTransaction
+}
+
+func newFakeRPC(t *testing.T) *fakeRPC {
+	f := &fakeRPC{methods: make(map[string]func(json.RawMessage) interface{})}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+		var call struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&call))
+		f.lock.Lock()
+		method, ok := f.methods[call.Method]
+		f.lock.Unlock()
+		if !ok {
+			t.Errorf("unexpected RPC call %s", call.Method)
+			http.Error(wr, "unexpected call", http.StatusNotFound)
+			return
+		}
+		require.NoError(t, json.NewEncoder(wr).Encode(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      call.ID,
+			"result":  method(call.Params),
+		}))
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeRPC) handle(method string, fn func(params json.RawMessage) interface{}) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.methods[method] = fn
+}
+
+// handleSend accepts all transactions and returns their first signature.
+func (f *fakeRPC) handleSend(t *testing.T) {
+	f.handle("sendTransaction", func(params json.RawMessage) interface{} {
+		var args []json.RawMessage
+		require.NoError(t, json.Unmarshal(params, &args))
+		var encoded string
+		require.NoError(t, json.Unmarshal(args[0], &encoded))
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		require.NoError(t, err)
+		tx, err := solana.TransactionFromDecoder(bin.NewBinDecoder(raw))
+		require.NoError(t, err)
+		require.NoError(t, tx.VerifySignatures())
+		f.lock.Lock()
+		f.sent = append(f.sent, tx)
+		f.lock.Unlock()
+		return tx.Signatures[0].String()
+	})
+}
+
+func (f *fakeRPC) sentTransactions() []*solana.Transaction {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]*solana.Transaction(nil), f.sent...)
+}
+
+var testBlockhash = solana.MustHashFromBase58("EkSnNWid2cvwEVnVx9aBqawnmiCNiDgp3gUdkDPTKN1N")
+
+func (f *fakeRPC) handleBlockhash() {
+	f.handle("getLatestBlockhash", func(json.RawMessage) interface{} {
+		return map[string]interface{}{
+			"context": map[string]interface{}{"slot": 100},
+			"value": map[string]interface{}{
+				"blockhash":            testBlockhash.String(),
+				"lastValidBlockHeight": 200,
+			},
+		}
+	})
+}
+
+func (f *fakeRPC) handleSlot(slot uint64) {
+	f.handle("getSlot", func(json.RawMessage) interface{} {
+		return slot
+	})
+}
+
+func TestPublisher_Publish(t *testing.T) {
+	rpcServer := newFakeRPC(t)
+	rpcServer.handleBlockhash()
+	rpcServer.handleSlot(1234)
+	rpcServer.handleSend(t)
+
+	signer := solana.NewWallet().PrivateKey
+	client := NewClient(Devnet, rpcServer.URL, rpcServer.URL)
+	publisher := client.NewPublisher(context.Background(), NewKeypairSigner(signer), []solana.PublicKey{testPriceKey}, nil)
+	defer publisher.Close()
+
+	sig, err := publisher.Publish(context.Background(), testPriceKey, 12345, 6, PriceStatusTrading)
+	require.NoError(t, err)
+
+	sent := rpcServer.sentTransactions()
+	require.Len(t, sent, 1)
+	tx := sent[0]
+	assert.Equal(t, sig, tx.Signatures[0])
+	assert.Equal(t, testBlockhash, tx.Message.RecentBlockhash)
+	assert.Equal(t, signer.PublicKey(), tx.Message.AccountKeys[0])
+
+	require.Len(t, tx.Message.Instructions, 1)
+	accounts, err := tx.Message.Instructions[0].ResolveInstructionAccounts(&tx.Message)
+	require.NoError(t, err)
+	ins, err := DecodeInstruction(Devnet.Program, accounts, tx.Message.Instructions[0].Data)
+	require.NoError(t, err)
+	assert.Equal(t, Instruction_UpdPrice, ins.Header.Cmd)
+	assert.Equal(t, &CommandUpdPrice{
+		Status:  PriceStatusTrading,
+		Price:   12345,
+		Conf:    6,
+		PubSlot: 1234,
+	}, ins.Payload)
+	assert.Equal(t, uint64(1234), publisher.Slot())
+}
+
+func TestPublisher_UnknownPriceKey(t *testing.T) {
+	rpcServer := newFakeRPC(t)
+	rpcServer.handleBlockhash()
+
+	client := NewClient(Devnet, rpcServer.URL, rpcServer.URL)
+	publisher := client.NewPublisher(context.Background(), NewKeypairSigner(solana.NewWallet().PrivateKey), nil, nil)
+	defer publisher.Close()
+
+	_, err := publisher.Publish(context.Background(), testPriceKey, 1, 1, PriceStatusTrading)
+	assert.EqualError(t, err, "price account "+testPriceKey.String()+" not configured for publisher")
+}
+
+func TestPublisher_CheckPending(t *testing.T) {
+	landed, failed, expired, pending := solana.Signature{1}, solana.Signature{2}, solana.Signature{3}, solana.Signature{4}
+	processed := solana.Signature{5}
+	statuses := map[solana.Signature]interface{}{
+		landed:    map[string]interface{}{"slot": 99, "err": nil, "confirmationStatus": "confirmed"},
+		processed: map[string]interface{}{"slot": 99, "err": nil, "confirmationStatus": "processed"},
+		failed:    map[string]interface{}{"slot": 99, "err": map[string]interface{}{"InstructionError": []interface{}{0, "Custom"}}, "confirmationStatus": "confirmed"},
+	}
+
+	rpcServer := newFakeRPC(t)
+	rpcServer.handleBlockhash()
+	rpcServer.handle("getSignatureStatuses", func(params json.RawMessage) interface{} {
+		var args []json.RawMessage
+		require.NoError(t, json.Unmarshal(params, &args))
+		var sigs []solana.Signature
+		require.NoError(t, json.Unmarshal(args[0], &sigs))
+		values := make([]interface{}, len(sigs))
+		for i, sig := range sigs {
+			values[i] = statuses[sig]
+		}
+		return map[string]interface{}{
+			"context": map[string]interface{}{"slot": 100},
+			"value":   values,
+		}
+	})
+
+	client := NewClient(Devnet, rpcServer.URL, rpcServer.URL)
+	publisher := client.NewPublisher(context.Background(), NewKeypairSigner(solana.NewWallet().PrivateKey), nil, &PublisherOpts{
+		StatusInterval: time.Hour,
+		Expiry:         time.Minute,
+	})
+	defer publisher.Close()
+
+	var resultsLock sync.Mutex
+	results := make(map[solana.Signature]TxResult)
+	defer publisher.onResult(func(result TxResult) {
+		resultsLock.Lock()
+		defer resultsLock.Unlock()
+		results[result.Signature] = result
+	})()
+
+	now := time.Now()
+	stillPending := &pendingTx{sentAt: now, pubSlot: 98}
+	// Seen at processed, but not confirmed yet: it may still land after the expiry.
+	stillProcessed := &pendingTx{sentAt: now.Add(-2 * time.Minute), pubSlot: 10}
+	publisher.pendingLock.Lock()
+	publisher.pending[landed] = &pendingTx{sentAt: now, pubSlot: 98, priceKeys: []solana.PublicKey{testPriceKey}}
+	publisher.pending[failed] = &pendingTx{sentAt: now, pubSlot: 98}
+	publisher.pending[expired] = &pendingTx{sentAt: now.Add(-2 * time.Minute), pubSlot: 10}
+	publisher.pending[pending] = stillPending
+	publisher.pending[processed] = stillProcessed
+	publisher.pendingLock.Unlock()
+
+	require.NoError(t, publisher.checkPending(context.Background()))
+	publisher.pendingLock.Lock()
+	assert.Equal(t, map[solana.Signature]*pendingTx{pending: stillPending, processed: stillProcessed}, publisher.pending)
+	publisher.pendingLock.Unlock()
+
+	resultsLock.Lock()
+	defer resultsLock.Unlock()
+	require.Len(t, results, 3)
+	assert.Equal(t, TxLanded, results[landed].Outcome)
+	assert.Equal(t, uint64(99), results[landed].Slot)
+	assert.Equal(t, []solana.PublicKey{testPriceKey}, results[landed].PriceKeys)
+	assert.Equal(t, TxProgramError, results[failed].Outcome)
+	assert.Equal(t, TxExpired, results[expired].Outcome)
+	assert.Equal(t, uint64(10), results[expired].PubSlot)
+}
+
+func TestPublisher_PublishBatch(t *testing.T) {
+	rpcServer := newFakeRPC(t)
+	rpcServer.handleBlockhash()
+	rpcServer.handleSlot(1234)
+	rpcServer.handleSend(t)
+
+	signer := solana.NewWallet().PrivateKey
+	var priceKeys []solana.PublicKey
+	var updates []PriceUpdateRequest
+	for i := 0; i < 30; i++ {
+		key := solana.PublicKey{byte(i), 1}
+		priceKeys = append(priceKeys, key)
+		updates = append(updates, PriceUpdateRequest{PriceKey: key, Price: int64(i), Conf: 1, Status: PriceStatusTrading})
+	}
+	client := NewClient(Devnet, rpcServer.URL, rpcServer.URL)
+	publisher := client.NewPublisher(context.Background(), NewKeypairSigner(signer), priceKeys, &PublisherOpts{
+		Pack: PackOpts{ComputeUnitPrice: 100},
+	})
+	defer publisher.Close()
+
+	sigs, err := publisher.PublishBatch(context.Background(), updates)
+	require.NoError(t, err)
+	sent := rpcServer.sentTransactions()
+	require.Len(t, sent, len(sigs))
+	require.Greater(t, len(sent), 1)
+
+	var numUpdates int
+	for _, tx := range sent {
+		for _, ins := range tx.Message.Instructions {
+			program, err := tx.Message.Program(ins.ProgramIDIndex)
+			require.NoError(t, err)
+			if program == Devnet.Program {
+				numUpdates++
+			}
+		}
+	}
+	assert.Equal(t, len(updates), numUpdates)
+}
+
+func TestPublisher_StreamSlots(t *testing.T) {
+	rpcServer := newFakeRPC(t)
+	rpcServer.handleBlockhash()
+	client := NewClient(Devnet, rpcServer.URL, rpcServer.URL)
+
+	stream := &PriceAccountStream{
+		opts:    StreamOpts{TrackSlots: true},
+		metrics: newStreamMetrics(Devnet.Program),
+	}
+	stream.observeTip(100)
+	publisher := client.NewPublisher(context.Background(), NewKeypairSigner(solana.NewWallet().PrivateKey), nil,
+		&PublisherOpts{Stream: stream})
+	assert.Equal(t, uint64(100), publisher.Slot())
+
+	// Slots are taken from the stream's connection.
+	stream.observeTip(101)
+	assert.Equal(t, uint64(101), publisher.Slot())
+
+	publisher.Close()
+	stream.observeTip(102)
+	assert.Equal(t, uint64(101), publisher.Slot())
+	assert.Empty(t, stream.slotHooks)
+}
@@ -0,0 +1,446 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Recordings store price account updates in a compact file format.
+//
+// A recording starts with the 8-byte recordMagic, followed by a zstd stream of records.
+// Each record is prefixed with its length as an unsigned varint, and contains:
+//
+//	uint64    slot
+//	int64     receive time (Unix nanoseconds)
+//	[32]byte  account pubkey
+//	[]byte    raw account data (remainder of record)
+//
+// All integers are little-endian.
+var recordMagic = [8]byte{'P', 'Y', 'T', 'H', 'R', 'E', 'C', 1}
+
+// recordHeaderLen is the length of a record excluding its account data.
+const recordHeaderLen = 8 + 8 + 32
+
+// maxRecordLen limits the size of a single record when reading.
+const maxRecordLen = recordHeaderLen + 1<<20
+
+// RecordedUpdate is a single account update stored in a recording.
+type RecordedUpdate struct {
+	Slot     uint64
+	Pubkey   solana.PublicKey
+	RecvTime time.Time // zero if unknown
+	Data     []byte    // raw account data
+}
+
+// Entry decodes the recorded price account.
+func (r *RecordedUpdate) Entry() (PriceAccountEntry, error) {
+	acc := new(PriceAccount)
+	if err := acc.UnmarshalBinary(r.Data); err != nil {
+		return PriceAccountEntry{}, err
+	}
+	return PriceAccountEntry{
+		PriceAccount: acc,
+		Pubkey:       r.Pubkey,
+		Slot:         r.Slot,
+		Raw:          append([]byte(nil), r.Data...),
+	}, nil
+}
+
+// Recorder writes price account updates to a recording.
+//
+// It is safe to use from multiple goroutines.
+type Recorder struct {
+	lock sync.Mutex
+	zw   *zstd.Encoder
+	buf  []byte
+}
+
+// NewRecorder starts a new recording on the given writer.
+//
+// Close must be called to flush the recording.
+func NewRecorder(wr io.Writer) (*Recorder, error) {
+	if _, err := wr.Write(recordMagic[:]); err != nil {
+		return nil, err
+	}
+	zw, err := zstd.NewWriter(wr)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{zw: zw}, nil
+}
+
+// Write appends an update to the recording.
+func (r *Recorder) Write(update RecordedUpdate) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	recordLen := recordHeaderLen + len(update.Data)
+	if cap(r.buf) < binary.MaxVarintLen64+recordLen {
+		r.buf = make([]byte, binary.MaxVarintLen64+recordLen)
+	}
+	buf := r.buf[:cap(r.buf)]
+	n := binary.PutUvarint(buf, uint64(recordLen))
+	binary.LittleEndian.PutUint64(buf[n:], update.Slot)
+	var recvTime int64 // zero times are recorded as 0, as UnixNano is undefined for them
+	if !update.RecvTime.IsZero() {
+		recvTime = update.RecvTime.UnixNano()
+	}
+	binary.LittleEndian.PutUint64(buf[n+8:], uint64(recvTime))
+	copy(buf[n+16:], update.Pubkey[:])
+	copy(buf[n+recordHeaderLen:], update.Data)
+	buf = buf[:n+recordLen]
+
+	_, err := r.zw.Write(buf)
+	return err
+}
+
+// WriteEntry appends a price account update to the recording.
+//
+// The raw account data of the entry is recorded as is.
+// Entries without raw data are re-encoded, which drops any data not modeled by PriceAccount.
+func (r *Recorder) WriteEntry(entry PriceAccountEntry, recvTime time.Time) error {
+	data := entry.Raw
+	if data == nil {
+		var err error
+		if data, err = entry.PriceAccount.MarshalBinary(); err != nil {
+			return err
+		}
+	}
+	return r.Write(RecordedUpdate{
+		Slot:     entry.Slot,
+		Pubkey:   entry.Pubkey,
+		RecvTime: recvTime,
+		Data:     data,
+	})
+}
+
+// Flush writes all buffered updates to the underlying writer.
+func (r *Recorder) Flush() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.zw.Flush()
+}
+
+// Close flushes and finishes the recording.
+//
+// The underlying writer is not closed.
+func (r *Recorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.zw.Close()
+}
+
+// Tee returns a source that records every update it passes through from src.
+//
+// Recording failures terminate the returned source.
+func (r *Recorder) Tee(src PriceAccountSource) PriceAccountSource {
+	tee := &recordingSource{
+		src:      src,
+		recorder: r,
+		updates:  make(chan PriceAccountEntry),
+		done:     make(chan struct{}),
+	}
+	tee.errLock.Lock()
+	go tee.runWrapper()
+	return tee
+}
+
+type recordingSource struct {
+	src      PriceAccountSource
+	recorder *Recorder
+	updates  chan PriceAccountEntry
+	done     chan struct{}
+	err      error
+	errLock  sync.Mutex
+}
+
+func (t *recordingSource) Updates() <-chan PriceAccountEntry {
+	return t.updates
+}
+
+func (t *recordingSource) Err() error {
+	t.errLock.Lock()
+	defer t.errLock.Unlock()
+	return t.err
+}
+
+func (t *recordingSource) Close() {
+	t.src.Close()
+	for range t.updates {
+		// drain
+	}
+	<-t.done
+}
+
+func (t *recordingSource) runWrapper() {
+	defer close(t.done)
+	defer t.errLock.Unlock()
+	t.err = t.run()
+}
+
+func (t *recordingSource) run() error {
+	defer close(t.updates)
+	for update := range t.src.Updates() {
+		if err := t.recorder.WriteEntry(update, time.Now()); err != nil {
+			t.src.Close()
+			return fmt.Errorf("failed to record update: %w", err)
+		}
+		t.updates <- update
+	}
+	return t.src.Err()
+}
+
+// RecordReader reads updates from a recording.
+type RecordReader struct {
+	zr  *zstd.Decoder
+	rd  *bufio.Reader
+	buf []byte
+}
+
+// NewRecordReader opens a recording for reading.
+func NewRecordReader(rd io.Reader) (*RecordReader, error) {
+	var magic [8]byte
+	if _, err := io.ReadFull(rd, magic[:]); err != nil {
+		return nil, fmt.Errorf("failed to read recording header: %w", err)
+	}
+	if magic != recordMagic {
+		return nil, errors.New("not a recording")
+	}
+	zr, err := zstd.NewReader(rd, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return &RecordReader{
+		zr: zr,
+		rd: bufio.NewReader(zr),
+	}, nil
+}
+
+// Next returns the next update in the recording.
+//
+// Returns io.EOF once the recording has been read completely.
+// The returned Data slice is only valid until the next call to Next.
+func (r *RecordReader) Next() (RecordedUpdate, error) {
+	recordLen, err := binary.ReadUvarint(r.rd)
+	if err != nil {
+		return RecordedUpdate{}, err
+	}
+	if recordLen < recordHeaderLen || recordLen > maxRecordLen {
+		return RecordedUpdate{}, fmt.Errorf("invalid record length (%d)", recordLen)
+	}
+	if uint64(cap(r.buf)) < recordLen {
+		r.buf = make([]byte, recordLen)
+	}
+	buf := r.buf[:recordLen]
+	if _, err := io.ReadFull(r.rd, buf); err != nil {
+		return RecordedUpdate{}, fmt.Errorf("truncated record: %w", io.ErrUnexpectedEOF)
+	}
+
+	update := RecordedUpdate{
+		Slot: binary.LittleEndian.Uint64(buf[0:8]),
+		Data: buf[recordHeaderLen:],
+	}
+	if recvTime := int64(binary.LittleEndian.Uint64(buf[8:16])); recvTime != 0 {
+		update.RecvTime = time.Unix(0, recvTime)
+	}
+	copy(update.Pubkey[:], buf[16:48])
+	return update, nil
+}
+
+// Close releases resources associated with the reader.
+//
+// The underlying reader is not closed.
+func (r *RecordReader) Close() {
+	r.zr.Close()
+}
+
+// Replay speeds.
+const (
+	ReplayAsFastAsPossible = float64(0) // deliver updates without delay
+	ReplayRealTime         = float64(1) // deliver updates with original timing
+)
+
+// ReplayOpts configures a Replayer.
+type ReplayOpts struct {
+	// Speed is the replay speed relative to the original receive times.
+	// Values greater than 1 accelerate the replay.
+	// Zero (ReplayAsFastAsPossible) disables pacing.
+	Speed float64
+}
+
+// Replayer replays a recording as a PriceAccountSource.
+type Replayer struct {
+	reader  *RecordReader
+	speed   float64
+	cancel  context.CancelFunc
+	updates chan PriceAccountEntry
+	done    chan struct{}
+	err     error
+	errLock sync.Mutex
+}
+
+// NewReplayer starts replaying a recording.
+//
+// The underlying reader is not closed.
+func NewReplayer(rd io.Reader, opts ReplayOpts) (*Replayer, error) {
+	if opts.Speed < 0 {
+		return nil, fmt.Errorf("invalid replay speed (%f)", opts.Speed)
+	}
+	reader, err := NewRecordReader(rd)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	replayer := &Replayer{
+		reader:  reader,
+		speed:   opts.Speed,
+		cancel:  cancel,
+		updates: make(chan PriceAccountEntry),
+		done:    make(chan struct{}),
+	}
+	replayer.errLock.Lock()
+	go replayer.runWrapper(ctx)
+	return replayer, nil
+}
+
+// Updates returns a channel with replayed price account updates.
+//
+// The channel is closed when the recording ends.
+func (r *Replayer) Updates() <-chan PriceAccountEntry {
+	return r.updates
+}
+
+// Err returns the reason why the replay has ended.
+// Will block until the replay has actually ended.
+// Returns nil if the recording was replayed completely or the replay was closed.
+func (r *Replayer) Err() error {
+	r.errLock.Lock()
+	defer r.errLock.Unlock()
+	return r.err
+}
+
+// Close stops the replay.
+//
+// Close blocks until the replay has stopped.
+// Any updates that have not been consumed yet are discarded.
+func (r *Replayer) Close() {
+	r.cancel()
+	for range r.updates {
+		// drain
+	}
+	<-r.done
+}
+
+func (r *Replayer) runWrapper(ctx context.Context) {
+	defer close(r.done)
+	defer r.errLock.Unlock()
+	r.err = r.run(ctx)
+	if errors.Is(r.err, context.Canceled) {
+		r.err = nil
+	}
+}
+
+func (r *Replayer) run(ctx context.Context) error {
+	defer close(r.updates)
+	defer r.reader.Close()
+
+	var firstRecv, start time.Time
+	for {
+		update, err := r.reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if PeekAccount(update.Data) != AccountTypePrice {
+			continue
+		}
+		entry, err := update.Entry()
+		if err != nil {
+			return fmt.Errorf("failed to decode price account %s: %w", update.Pubkey, err)
+		}
+
+		// Pace updates according to their original receive times.
+		if r.speed > 0 {
+			if start.IsZero() {
+				firstRecv, start = update.RecvTime, time.Now()
+			}
+			offset := time.Duration(float64(update.RecvTime.Sub(firstRecv)) / r.speed)
+			if err := sleepUntil(ctx, start.Add(offset)); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case r.updates <- entry:
+		}
+	}
+}
+
+// sleepUntil blocks until the given time or until the context is cancelled.
+func sleepUntil(ctx context.Context, t time.Time) error {
+	delay := time.Until(t)
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// ReplayAll decodes all price account updates in a recording.
+func ReplayAll(rd io.Reader) ([]PriceAccountEntry, error) {
+	reader, err := NewRecordReader(rd)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var entries []PriceAccountEntry
+	for {
+		update, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		} else if err != nil {
+			return entries, err
+		}
+		if PeekAccount(update.Data) != AccountTypePrice {
+			continue
+		}
+		entry, err := update.Entry()
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, entry)
+	}
+}
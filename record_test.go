@@ -0,0 +1,194 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// makeRecording returns a recording of the test price account at consecutive slots.
+func makeRecording(t testing.TB, slots int, interval time.Duration) []byte {
+	var buf bytes.Buffer
+	rec, err := NewRecorder(&buf)
+	require.NoError(t, err)
+	start := time.Unix(1660000000, 0)
+	for i := 0; i < slots; i++ {
+		acc := priceAccount_E36MyBbavhYKHVLWR79GiReNNnBDiHj6nWA7htbkNZbh
+		acc.Agg.PubSlot += uint64(i)
+		acc.Agg.Price += int64(i)
+		require.NoError(t, rec.WriteEntry(PriceAccountEntry{
+			PriceAccount: &acc,
+			Pubkey:       solana.MustPublicKeyFromBase58("E36MyBbavhYKHVLWR79GiReNNnBDiHj6nWA7htbkNZbh"),
+			Slot:         acc.Agg.PubSlot,
+		}, start.Add(time.Duration(i)*interval)))
+	}
+	require.NoError(t, rec.Close())
+	return buf.Bytes()
+}
+
+func TestPriceAccount_MarshalBinary(t *testing.T) {
+	data, err := priceAccount_E36MyBbavhYKHVLWR79GiReNNnBDiHj6nWA7htbkNZbh.MarshalBinary()
+	require.NoError(t, err)
+	assert.Len(t, data, PriceAccountLen)
+	assert.Equal(t, casePriceAccount, data)
+}
+
+func TestRecordReader(t *testing.T) {
+	recording := makeRecording(t, 3, time.Second)
+	assert.Less(t, len(recording), 3*PriceAccountLen, "recording should be compressed")
+
+	reader, err := NewRecordReader(bytes.NewReader(recording))
+	require.NoError(t, err)
+	defer reader.Close()
+
+	for i := 0; i < 3; i++ {
+		update, err := reader.Next()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(117491487+i), update.Slot)
+		assert.Equal(t, time.Unix(1660000000+int64(i), 0), update.RecvTime)
+		assert.Equal(t, solana.MustPublicKeyFromBase58("E36MyBbavhYKHVLWR79GiReNNnBDiHj6nWA7htbkNZbh"), update.Pubkey)
+		entry, err := update.Entry()
+		require.NoError(t, err)
+		assert.Equal(t, int64(112717+i), entry.Agg.Price)
+	}
+	_, err = reader.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestRecordReader_Invalid(t *testing.T) {
+	_, err := NewRecordReader(bytes.NewReader([]byte("not a recording")))
+	assert.EqualError(t, err, "not a recording")
+
+	recording := makeRecording(t, 1, time.Second)
+	reader, err := NewRecordReader(bytes.NewReader(recording[:len(recording)-8]))
+	require.NoError(t, err)
+	defer reader.Close()
+	_, err = reader.Next()
+	assert.Error(t, err)
+}
+
+func TestReplayer_AsFastAsPossible(t *testing.T) {
+	recording := makeRecording(t, 100, time.Hour)
+	replayer, err := NewReplayer(bytes.NewReader(recording), ReplayOpts{Speed: ReplayAsFastAsPossible})
+	require.NoError(t, err)
+
+	var slots []uint64
+	for update := range replayer.Updates() {
+		slots = append(slots, update.Slot)
+	}
+	require.NoError(t, replayer.Err())
+	require.Len(t, slots, 100)
+	assert.Equal(t, uint64(117491487), slots[0])
+	assert.Equal(t, uint64(117491586), slots[99])
+}
+
+func TestReplayer_Accelerated(t *testing.T) {
+	recording := makeRecording(t, 3, time.Second)
+	replayer, err := NewReplayer(bytes.NewReader(recording), ReplayOpts{Speed: 20})
+	require.NoError(t, err)
+
+	start := time.Now()
+	var count int
+	for range replayer.Updates() {
+		count++
+	}
+	require.NoError(t, replayer.Err())
+	assert.Equal(t, 3, count)
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestReplayer_Close(t *testing.T) {
+	recording := makeRecording(t, 3, time.Hour)
+	replayer, err := NewReplayer(bytes.NewReader(recording), ReplayOpts{Speed: ReplayRealTime})
+	require.NoError(t, err)
+
+	<-replayer.Updates()
+	replayer.Close()
+	for range replayer.Updates() {
+	}
+	assert.NoError(t, replayer.Err())
+}
+
+func TestRecorder_Tee(t *testing.T) {
+	recording := makeRecording(t, 4, time.Second)
+	replayer, err := NewReplayer(bytes.NewReader(recording), ReplayOpts{})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	rec, err := NewRecorder(&buf)
+	require.NoError(t, err)
+	tee := rec.Tee(replayer)
+	var count int
+	for range tee.Updates() {
+		count++
+	}
+	require.NoError(t, tee.Err())
+	require.NoError(t, rec.Close())
+	assert.Equal(t, 4, count)
+
+	entries, err := ReplayAll(&buf)
+	require.NoError(t, err)
+	assert.Len(t, entries, 4)
+}
+
+func TestRecorder_ZeroRecvTime(t *testing.T) {
+	var buf bytes.Buffer
+	rec, err := NewRecorder(&buf)
+	require.NoError(t, err)
+	require.NoError(t, rec.WriteEntry(PriceAccountEntry{Slot: 1, Raw: casePriceAccount}, time.Time{}))
+	require.NoError(t, rec.Close())
+
+	reader, err := NewRecordReader(&buf)
+	require.NoError(t, err)
+	defer reader.Close()
+	update, err := reader.Next()
+	require.NoError(t, err)
+	assert.True(t, update.RecvTime.IsZero())
+}
+
+func TestRecorder_Tee_RawData(t *testing.T) {
+	// Data not modeled by PriceAccount, such as trailing bytes of resized accounts, is preserved.
+	data := append(append([]byte(nil), casePriceAccount...), 1, 2, 3)
+	var recording bytes.Buffer
+	rec, err := NewRecorder(&recording)
+	require.NoError(t, err)
+	require.NoError(t, rec.Write(RecordedUpdate{Slot: 1, RecvTime: time.Unix(1660000000, 0), Data: data}))
+	require.NoError(t, rec.Close())
+
+	replayer, err := NewReplayer(&recording, ReplayOpts{})
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	rec, err = NewRecorder(&buf)
+	require.NoError(t, err)
+	tee := rec.Tee(replayer)
+	for range tee.Updates() {
+	}
+	require.NoError(t, tee.Err())
+	require.NoError(t, rec.Close())
+
+	reader, err := NewRecordReader(&buf)
+	require.NoError(t, err)
+	defer reader.Close()
+	update, err := reader.Next()
+	require.NoError(t, err)
+	assert.Equal(t, data, update.Data)
+}
@@ -0,0 +1,256 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+// Quote is a price to publish, as provided by a PriceSource.
+type Quote struct {
+	PriceKey solana.PublicKey
+	Price    int64
+	Conf     uint64
+	Status   uint32
+	Time     time.Time // time the price was observed, zero if unknown
+}
+
+// PriceSource provides the prices a Scheduler publishes.
+type PriceSource interface {
+	// Quotes returns the current prices to publish in the given slot.
+	Quotes(ctx context.Context, slot uint64) ([]Quote, error)
+}
+
+// PriceSourceFunc adapts a function to the PriceSource interface.
+type PriceSourceFunc func(ctx context.Context, slot uint64) ([]Quote, error)
+
+// Quotes calls f.
+func (f PriceSourceFunc) Quotes(ctx context.Context, slot uint64) ([]Quote, error) {
+	return f(ctx, slot)
+}
+
+// SchedulerOpts configures a Scheduler.
+type SchedulerOpts struct {
+	// Interval is the number of slots between publishes. Defaults to 1, publishing every slot.
+	Interval uint64
+	// MaxAge skips quotes observed longer ago than this. Zero disables the check.
+	MaxAge time.Duration
+	// Timeout limits the time spent on querying the source and sending a slot's updates.
+	// Defaults to 400 milliseconds, roughly the duration of a slot.
+	Timeout time.Duration
+	// OnError is called with errors of the source or of sending, if set.
+	OnError func(slot uint64, err error)
+}
+
+const defaultScheduleTimeout = 400 * time.Millisecond
+
+// FeedStats are the publishing statistics of a price account.
+type FeedStats struct {
+	Scheduled uint64 // updates sent or attempted to send
+	Stale     uint64 // quotes skipped for exceeding SchedulerOpts.MaxAge
+	Landed    uint64 // updates confirmed on-chain
+	Failed    uint64 // updates that failed to send, failed on-chain or expired
+}
+
+// LandingRate returns the share of resolved updates that landed, between 0 and 1.
+//
+// Returns zero if no update has been resolved yet.
+func (f FeedStats) LandingRate() float64 {
+	resolved := f.Landed + f.Failed
+	if resolved == 0 {
+		return 0
+	}
+	return float64(f.Landed) / float64(resolved)
+}
+
+// Scheduler publishes prices of a PriceSource in sync with the chain's slots.
+type Scheduler struct {
+	publisher *Publisher
+	source    PriceSource
+	opts      SchedulerOpts
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	statsLock sync.Mutex // lock over stats
+	stats     map[solana.PublicKey]*FeedStats
+}
+
+// Schedule starts publishing the prices of source every SchedulerOpts.Interval slots.
+//
+// Slots are taken from the publisher's slot subscription.
+// Each update is published with its slot as CommandUpdPrice.PubSlot.
+// If querying the source and sending take longer than a slot, intermediate slots are skipped.
+// A slot is also skipped if the source fails or all of its quotes are stale.
+// The opts may be nil.
+//
+// Call Close to stop the scheduler.
+func (p *Publisher) Schedule(source PriceSource, opts *SchedulerOpts) *Scheduler {
+	if opts == nil {
+		opts = new(SchedulerOpts)
+	}
+	s := &Scheduler{
+		publisher: p,
+		source:    source,
+		opts:      *opts,
+		done:      make(chan struct{}),
+		stats:     make(map[solana.PublicKey]*FeedStats),
+	}
+	if s.opts.Interval == 0 {
+		s.opts.Interval = 1
+	}
+	if s.opts.Timeout <= 0 {
+		s.opts.Timeout = defaultScheduleTimeout
+	}
+
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(context.Background())
+	slots, stopSlots := p.watchSlots()
+	go func() {
+		defer close(s.done)
+		defer stopSlots()
+		s.run(ctx, slots)
+	}()
+	return s
+}
+
+// Close stops scheduling and waits for the slot in progress to finish.
+//
+// Transactions already sent are no longer reflected in the stats.
+func (s *Scheduler) Close() {
+	s.cancel()
+	<-s.done
+}
+
+// Stats returns the statistics of each price account scheduled so far.
+func (s *Scheduler) Stats() map[solana.PublicKey]FeedStats {
+	s.statsLock.Lock()
+	defer s.statsLock.Unlock()
+	stats := make(map[solana.PublicKey]FeedStats, len(s.stats))
+	for key, feed := range s.stats {
+		stats[key] = *feed
+	}
+	return stats
+}
+
+func (s *Scheduler) run(ctx context.Context, slots <-chan uint64) {
+	var last uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case slot := <-slots:
+			if last != 0 && slot < last+s.opts.Interval {
+				continue
+			}
+			if last == 0 && slot%s.opts.Interval != 0 {
+				continue
+			}
+			last = slot
+			// The slot in progress is not interrupted by Close.
+			s.publishSlot(context.Background(), slot)
+		}
+	}
+}
+
+// publishSlot queries the source and publishes the fresh quotes.
+func (s *Scheduler) publishSlot(ctx context.Context, slot uint64) {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.Timeout)
+	defer cancel()
+
+	quotes, err := s.source.Quotes(ctx, slot)
+	if err != nil {
+		metricsSchedulerSlotsSkippedTotal.WithLabelValues("source").Inc()
+		s.onError(slot, fmt.Errorf("price source failed: %w", err))
+		return
+	}
+
+	now := time.Now()
+	updates := make([]PriceUpdateRequest, 0, len(quotes))
+	var errs []error // reported without holding statsLock, as OnError may call Stats
+	s.statsLock.Lock()
+	for _, quote := range quotes {
+		if _, ok := s.publisher.priceKeys[quote.PriceKey]; !ok {
+			errs = append(errs, fmt.Errorf("price account %s not configured for publisher", quote.PriceKey))
+			continue
+		}
+		feed := s.feedLocked(quote.PriceKey)
+		if s.opts.MaxAge > 0 && !quote.Time.IsZero() && now.Sub(quote.Time) > s.opts.MaxAge {
+			feed.Stale++
+			continue
+		}
+		feed.Scheduled++
+		updates = append(updates, PriceUpdateRequest{
+			PriceKey: quote.PriceKey,
+			Price:    quote.Price,
+			Conf:     quote.Conf,
+			Status:   quote.Status,
+		})
+	}
+	s.statsLock.Unlock()
+	for _, err := range errs {
+		s.onError(slot, err)
+	}
+	if len(updates) == 0 {
+		if len(quotes) > 0 {
+			metricsSchedulerSlotsSkippedTotal.WithLabelValues("stale").Inc()
+		}
+		return
+	}
+
+	_, sent, err := s.publisher.publishAt(ctx, updates, slot, s.record)
+	if err != nil {
+		s.statsLock.Lock()
+		for _, update := range updates[sent:] {
+			s.feedLocked(update.PriceKey).Failed++
+		}
+		s.statsLock.Unlock()
+		s.onError(slot, err)
+	}
+}
+
+// record updates the stats with the outcome of a transaction.
+func (s *Scheduler) record(result TxResult) {
+	s.statsLock.Lock()
+	defer s.statsLock.Unlock()
+	for _, key := range result.PriceKeys {
+		feed := s.feedLocked(key)
+		if result.Outcome == TxLanded {
+			feed.Landed++
+		} else {
+			feed.Failed++
+		}
+	}
+}
+
+func (s *Scheduler) feedLocked(key solana.PublicKey) *FeedStats {
+	feed, ok := s.stats[key]
+	if !ok {
+		feed = new(FeedStats)
+		s.stats[key] = feed
+	}
+	return feed
+}
+
+func (s *Scheduler) onError(slot uint64, err error) {
+	if s.opts.OnError != nil {
+		s.opts.OnError(slot, err)
+	}
+}
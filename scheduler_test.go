@@ -0,0 +1,125 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeedStats_LandingRate(t *testing.T) {
+	assert.Equal(t, 0.0, FeedStats{}.LandingRate())
+	assert.Equal(t, 0.75, FeedStats{Landed: 3, Failed: 1}.LandingRate())
+}
+
+func TestScheduler(t *testing.T) {
+	rpcServer := newFakeRPC(t)
+	rpcServer.handleBlockhash()
+	rpcServer.handleSend(t)
+	rpcServer.handle("getSignatureStatuses", func(params json.RawMessage) interface{} {
+		var args []json.RawMessage
+		require.NoError(t, json.Unmarshal(params, &args))
+		var sigs []solana.Signature
+		require.NoError(t, json.Unmarshal(args[0], &sigs))
+		values := make([]interface{}, len(sigs))
+		for i := range sigs {
+			values[i] = map[string]interface{}{"slot": 101, "err": nil, "confirmationStatus": "confirmed"}
+		}
+		return map[string]interface{}{
+			"context": map[string]interface{}{"slot": 101},
+			"value":   values,
+		}
+	})
+
+	staleKey := solana.PublicKey{2}
+	client := NewClient(Devnet, rpcServer.URL, rpcServer.URL)
+	publisher := client.NewPublisher(context.Background(), NewKeypairSigner(solana.NewWallet().PrivateKey),
+		[]solana.PublicKey{testPriceKey, staleKey}, &PublisherOpts{StatusInterval: time.Hour})
+	defer publisher.Close()
+
+	slots := make(chan uint64, 8)
+	scheduler := publisher.Schedule(PriceSourceFunc(func(_ context.Context, slot uint64) ([]Quote, error) {
+		slots <- slot
+		return []Quote{
+			{PriceKey: testPriceKey, Price: int64(slot), Conf: 1, Status: PriceStatusTrading, Time: time.Now()},
+			{PriceKey: staleKey, Price: 1, Conf: 1, Status: PriceStatusTrading, Time: time.Now().Add(-time.Hour)},
+		}, nil
+	}), &SchedulerOpts{Interval: 2, MaxAge: time.Minute})
+	defer scheduler.Close()
+
+	// Slots not on the interval are skipped.
+	for _, slot := range []uint64{99, 100, 101, 102} {
+		publisher.observeSlot(slot)
+		if slot%2 == 0 {
+			assert.Equal(t, slot, <-slots)
+		}
+	}
+	scheduler.Close()
+	require.NoError(t, publisher.checkPending(context.Background()))
+
+	sent := rpcServer.sentTransactions()
+	require.Len(t, sent, 2)
+	for i, tx := range sent {
+		require.Len(t, tx.Message.Instructions, 1)
+		accounts, err := tx.Message.Instructions[0].ResolveInstructionAccounts(&tx.Message)
+		require.NoError(t, err)
+		ins, err := DecodeInstruction(Devnet.Program, accounts, tx.Message.Instructions[0].Data)
+		require.NoError(t, err)
+		slot := uint64(100 + 2*i)
+		assert.Equal(t, &CommandUpdPrice{
+			Status:  PriceStatusTrading,
+			Price:   int64(slot),
+			Conf:    1,
+			PubSlot: slot,
+		}, ins.Payload)
+	}
+
+	assert.Equal(t, map[solana.PublicKey]FeedStats{
+		testPriceKey: {Scheduled: 2, Landed: 2},
+		staleKey:     {Stale: 2},
+	}, scheduler.Stats())
+}
+
+func TestScheduler_OnErrorCallsStats(t *testing.T) {
+	rpcServer := newFakeRPC(t)
+	rpcServer.handleBlockhash()
+	client := NewClient(Devnet, rpcServer.URL, rpcServer.URL)
+	publisher := client.NewPublisher(context.Background(), NewKeypairSigner(solana.NewWallet().PrivateKey), nil, nil)
+	defer publisher.Close()
+
+	errs := make(chan error, 1)
+	var scheduler *Scheduler
+	scheduler = publisher.Schedule(PriceSourceFunc(func(context.Context, uint64) ([]Quote, error) {
+		return []Quote{{PriceKey: testPriceKey, Price: 1, Conf: 1, Status: PriceStatusTrading}}, nil
+	}), &SchedulerOpts{OnError: func(_ uint64, err error) {
+		scheduler.Stats() // must not deadlock
+		errs <- err
+	}})
+	defer scheduler.Close()
+
+	publisher.observeSlot(100)
+	select {
+	case err := <-errs:
+		assert.EqualError(t, err, "price account "+testPriceKey.String()+" not configured for publisher")
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnError not called")
+	}
+}
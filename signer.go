@@ -0,0 +1,321 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	bin "github.com/gagliardetto/binary"
+	"github.com/gagliardetto/solana-go"
+)
+
+// Signer signs transaction messages on behalf of a Solana account.
+//
+// Implementations may keep the private key outside of the process, see RemoteSigner.
+type Signer interface {
+	// PublicKey returns the account of the signer.
+	PublicKey() solana.PublicKey
+	// SignMessage returns the Ed25519 signature of a serialized transaction message.
+	SignMessage(ctx context.Context, message []byte) (solana.Signature, error)
+}
+
+// SignTransaction adds the signatures of all required signers to the transaction.
+//
+// Each signer required by the message must be among the given signers.
+func SignTransaction(ctx context.Context, tx *solana.Transaction, signers ...Signer) error {
+	message, err := tx.Message.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	numSigners := int(tx.Message.Header.NumRequiredSignatures)
+	if numSigners > len(tx.Message.AccountKeys) {
+		return errors.New("invalid message header")
+	}
+	signatures := make([]solana.Signature, numSigners)
+	for i, key := range tx.Message.AccountKeys[:numSigners] {
+		signer := findSigner(signers, key)
+		if signer == nil {
+			return fmt.Errorf("missing signer for %s", key)
+		}
+		sig, err := signer.SignMessage(ctx, message)
+		if err != nil {
+			return fmt.Errorf("failed to sign with %s: %w", key, err)
+		}
+		signatures[i] = sig
+	}
+	tx.Signatures = signatures
+	return nil
+}
+
+func findSigner(signers []Signer, key solana.PublicKey) Signer {
+	for _, signer := range signers {
+		if signer.PublicKey() == key {
+			return signer
+		}
+	}
+	return nil
+}
+
+// KeypairSigner signs with a private key held in memory.
+type KeypairSigner struct {
+	key solana.PrivateKey
+}
+
+// NewKeypairSigner returns a signer using the given private key.
+func NewKeypairSigner(key solana.PrivateKey) *KeypairSigner {
+	return &KeypairSigner{key: key}
+}
+
+// LoadKeypairFile reads a keypair file in the format of the Solana CLI (solana-keygen),
+// a JSON array of the 64 bytes of the private and public key.
+func LoadKeypairFile(path string) (*KeypairSigner, error) {
+	key, err := solana.PrivateKeyFromSolanaKeygenFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load keypair: %w", err)
+	}
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid keypair %s: expected %d bytes, got %d", path, ed25519.PrivateKeySize, len(key))
+	}
+	// The file contains the seed followed by the public key, which must match.
+	derived := ed25519.NewKeyFromSeed(key[:ed25519.SeedSize])
+	if !bytes.Equal(derived[ed25519.SeedSize:], key[ed25519.SeedSize:]) {
+		return nil, fmt.Errorf("invalid keypair %s: public key does not match private key", path)
+	}
+	return NewKeypairSigner(key), nil
+}
+
+// PublicKey returns the public key of the keypair.
+func (k *KeypairSigner) PublicKey() solana.PublicKey {
+	return k.key.PublicKey()
+}
+
+// SignMessage signs the message with the private key.
+func (k *KeypairSigner) SignMessage(_ context.Context, message []byte) (solana.Signature, error) {
+	return k.key.Sign(message)
+}
+
+// Remote signer protocol.
+//
+// The public key is served as JSON at GET <url>/public_key:
+//
+//	{"public_key": "<base58>"}
+//
+// Messages are signed with POST <url>/sign:
+//
+//	{"message": "<base64 serialized transaction message>"}
+//
+// which returns the signature:
+//
+//	{"signature": "<base58>"}
+//
+// Errors are returned with a non-2xx status code and the body {"error": "<message>"}.
+// Sign requests larger than needed for the largest transaction are rejected with status 413.
+const (
+	remoteSignerPublicKeyPath = "/public_key"
+	remoteSignerSignPath      = "/sign"
+)
+
+// maxSignRequestLen limits the body of sign requests:
+// a base64-encoded message of the largest transaction, plus headroom for the JSON envelope.
+var maxSignRequestLen = int64(base64.StdEncoding.EncodedLen(MaxTransactionSize) + 1024)
+
+// RemoteSignerPublicKeyResponse is the response of a remote signer to a public key request.
+type RemoteSignerPublicKeyResponse struct {
+	PublicKey solana.PublicKey `json:"public_key"`
+}
+
+// RemoteSignerSignRequest is a signature request to a remote signer.
+type RemoteSignerSignRequest struct {
+	Message []byte `json:"message"` // base64 in JSON
+}
+
+// RemoteSignerSignResponse is the response of a remote signer to a signature request.
+type RemoteSignerSignResponse struct {
+	Signature solana.Signature `json:"signature"`
+}
+
+// RemoteSignerErrorResponse is the body of a failed remote signer request.
+type RemoteSignerErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// RemoteSigner delegates signing to an HTTP service, keeping the private key out of the process.
+//
+// See NewSignerServer for a reference implementation of the service.
+// Signatures are verified against the public key before they are used.
+type RemoteSigner struct {
+	url       string
+	client    *http.Client
+	publicKey solana.PublicKey
+}
+
+// NewRemoteSigner connects to the remote signer at the given base URL and fetches its public key.
+//
+// The HTTP client may be nil, which uses http.DefaultClient.
+func NewRemoteSigner(ctx context.Context, url string, client *http.Client) (*RemoteSigner, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	r := &RemoteSigner{
+		url:    strings.TrimSuffix(url, "/"),
+		client: client,
+	}
+	var res RemoteSignerPublicKeyResponse
+	if err := r.do(ctx, http.MethodGet, remoteSignerPublicKeyPath, nil, &res); err != nil {
+		return nil, fmt.Errorf("failed to get public key of remote signer: %w", err)
+	}
+	if res.PublicKey.IsZero() {
+		return nil, errors.New("remote signer returned no public key")
+	}
+	r.publicKey = res.PublicKey
+	return r, nil
+}
+
+// PublicKey returns the public key reported by the remote signer.
+func (r *RemoteSigner) PublicKey() solana.PublicKey {
+	return r.publicKey
+}
+
+// SignMessage requests a signature from the remote signer.
+func (r *RemoteSigner) SignMessage(ctx context.Context, message []byte) (solana.Signature, error) {
+	var res RemoteSignerSignResponse
+	if err := r.do(ctx, http.MethodPost, remoteSignerSignPath, &RemoteSignerSignRequest{Message: message}, &res); err != nil {
+		return solana.Signature{}, err
+	}
+	if !res.Signature.Verify(r.publicKey, message) {
+		return solana.Signature{}, errors.New("remote signer returned an invalid signature")
+	}
+	return res.Signature, nil
+}
+
+func (r *RemoteSigner) do(ctx context.Context, method string, path string, body interface{}, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, r.url+path, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		var errRes RemoteSignerErrorResponse
+		if json.NewDecoder(res.Body).Decode(&errRes) == nil && errRes.Error != "" {
+			return fmt.Errorf("remote signer: %s", errRes.Error)
+		}
+		return fmt.Errorf("remote signer: %s", res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// SignerServerOpts configures a signer server.
+type SignerServerOpts struct {
+	// Authorize is called with each message before it is signed, if set.
+	// Returning an error rejects the request with status 403.
+	Authorize func(msg *solana.Message) error
+}
+
+// NewSignerServer returns an HTTP handler implementing the remote signer protocol, see RemoteSigner.
+//
+// It is a reference implementation intended for testing custody-separated deployments offline.
+// The handler does not authenticate clients, deploy it behind an authenticating proxy if needed.
+// The opts may be nil.
+func NewSignerServer(signer Signer, opts *SignerServerOpts) http.Handler {
+	if opts == nil {
+		opts = new(SignerServerOpts)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(remoteSignerPublicKeyPath, func(wr http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			writeSignerError(wr, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeSignerJSON(wr, http.StatusOK, &RemoteSignerPublicKeyResponse{PublicKey: signer.PublicKey()})
+	})
+	mux.HandleFunc(remoteSignerSignPath, func(wr http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			writeSignerError(wr, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(wr, req.Body, maxSignRequestLen))
+		if err != nil {
+			if int64(len(body)) >= maxSignRequestLen {
+				writeSignerError(wr, http.StatusRequestEntityTooLarge, "request too large")
+			} else {
+				writeSignerError(wr, http.StatusBadRequest, "invalid request: "+err.Error())
+			}
+			return
+		}
+		var signReq RemoteSignerSignRequest
+		if err := json.Unmarshal(body, &signReq); err != nil {
+			writeSignerError(wr, http.StatusBadRequest, "invalid request: "+err.Error())
+			return
+		}
+		var msg solana.Message
+		dec := bin.NewBinDecoder(signReq.Message)
+		if err := msg.UnmarshalWithDecoder(dec); err != nil {
+			writeSignerError(wr, http.StatusBadRequest, "invalid message: "+err.Error())
+			return
+		}
+		// The raw message is signed, so it must not carry anything Authorize did not see.
+		if dec.HasRemaining() {
+			writeSignerError(wr, http.StatusBadRequest, fmt.Sprintf("invalid message: %d trailing bytes", dec.Remaining()))
+			return
+		}
+		if opts.Authorize != nil {
+			if err := opts.Authorize(&msg); err != nil {
+				writeSignerError(wr, http.StatusForbidden, err.Error())
+				return
+			}
+		}
+		sig, err := signer.SignMessage(req.Context(), signReq.Message)
+		if err != nil {
+			writeSignerError(wr, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeSignerJSON(wr, http.StatusOK, &RemoteSignerSignResponse{Signature: sig})
+	})
+	return mux
+}
+
+func writeSignerJSON(wr http.ResponseWriter, status int, v interface{}) {
+	wr.Header().Set("Content-Type", "application/json")
+	wr.WriteHeader(status)
+	_ = json.NewEncoder(wr).Encode(v)
+}
+
+func writeSignerError(wr http.ResponseWriter, status int, message string) {
+	writeSignerJSON(wr, status, &RemoteSignerErrorResponse{Error: message})
+}
@@ -0,0 +1,169 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTransaction returns an unsigned UpdPrice transaction paid by the publisher.
+func testTransaction(t *testing.T, publisher solana.PublicKey) *solana.Transaction {
+	ins := NewInstructionBuilder(Devnet.Program).UpdPrice(publisher, testPriceKey, CommandUpdPrice{Price: 1, PubSlot: 1})
+	tx, err := solana.NewTransaction([]solana.Instruction{ins}, testBlockhash, solana.TransactionPayer(publisher))
+	require.NoError(t, err)
+	return tx
+}
+
+func TestLoadKeypairFile(t *testing.T) {
+	key := solana.NewWallet().PrivateKey
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "id.json")
+	buf, err := json.Marshal(bytesToInts(key))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, buf, 0600))
+	signer, err := LoadKeypairFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, key.PublicKey(), signer.PublicKey())
+
+	// Public key half does not match.
+	corrupt := append(solana.PrivateKey(nil), key...)
+	corrupt[63] ^= 1
+	buf, err = json.Marshal(bytesToInts(corrupt))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, buf, 0600))
+	_, err = LoadKeypairFile(path)
+	assert.EqualError(t, err, "invalid keypair "+path+": public key does not match private key")
+
+	_, err = LoadKeypairFile(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err)
+}
+
+func bytesToInts(b []byte) []int {
+	ints := make([]int, len(b))
+	for i, v := range b {
+		ints[i] = int(v)
+	}
+	return ints
+}
+
+func TestSignTransaction(t *testing.T) {
+	signer := NewKeypairSigner(solana.NewWallet().PrivateKey)
+	tx := testTransaction(t, signer.PublicKey())
+	require.NoError(t, SignTransaction(context.Background(), tx, signer))
+	assert.NoError(t, tx.VerifySignatures())
+
+	other := NewKeypairSigner(solana.NewWallet().PrivateKey)
+	err := SignTransaction(context.Background(), testTransaction(t, signer.PublicKey()), other)
+	assert.EqualError(t, err, "missing signer for "+signer.PublicKey().String())
+}
+
+func TestRemoteSigner(t *testing.T) {
+	local := NewKeypairSigner(solana.NewWallet().PrivateKey)
+	var authorized []*solana.Message
+	server := httptest.NewServer(NewSignerServer(local, &SignerServerOpts{
+		Authorize: func(msg *solana.Message) error {
+			authorized = append(authorized, msg)
+			if len(msg.Instructions) != 1 {
+				return errors.New("only single instructions allowed")
+			}
+			return nil
+		},
+	}))
+	defer server.Close()
+
+	remote, err := NewRemoteSigner(context.Background(), server.URL+"/", server.Client())
+	require.NoError(t, err)
+	assert.Equal(t, local.PublicKey(), remote.PublicKey())
+
+	tx := testTransaction(t, remote.PublicKey())
+	require.NoError(t, SignTransaction(context.Background(), tx, remote))
+	assert.NoError(t, tx.VerifySignatures())
+	require.Len(t, authorized, 1)
+	assert.Equal(t, tx.Message.AccountKeys, authorized[0].AccountKeys)
+
+	// Rejected by policy.
+	tx.Message.Instructions = append(tx.Message.Instructions, tx.Message.Instructions[0])
+	err = SignTransaction(context.Background(), tx, remote)
+	assert.EqualError(t, err, "failed to sign with "+remote.PublicKey().String()+": remote signer: only single instructions allowed")
+}
+
+func TestRemoteSigner_InvalidSignature(t *testing.T) {
+	// Server signs with a different key than it advertises.
+	advertised := NewKeypairSigner(solana.NewWallet().PrivateKey)
+	actual := NewKeypairSigner(solana.NewWallet().PrivateKey)
+	server := httptest.NewServer(NewSignerServer(impostor{advertised.PublicKey(), actual}, nil))
+	defer server.Close()
+
+	remote, err := NewRemoteSigner(context.Background(), server.URL, nil)
+	require.NoError(t, err)
+	message, err := testTransaction(t, remote.PublicKey()).Message.MarshalBinary()
+	require.NoError(t, err)
+	_, err = remote.SignMessage(context.Background(), message)
+	assert.EqualError(t, err, "remote signer returned an invalid signature")
+}
+
+func TestSignerServer_TrailingBytes(t *testing.T) {
+	server := httptest.NewServer(NewSignerServer(NewKeypairSigner(solana.NewWallet().PrivateKey), nil))
+	defer server.Close()
+
+	remote, err := NewRemoteSigner(context.Background(), server.URL, nil)
+	require.NoError(t, err)
+	message, err := testTransaction(t, remote.PublicKey()).Message.MarshalBinary()
+	require.NoError(t, err)
+	_, err = remote.SignMessage(context.Background(), append(message, 1, 2))
+	assert.EqualError(t, err, "remote signer: invalid message: 2 trailing bytes")
+}
+
+type impostor struct {
+	publicKey solana.PublicKey
+	Signer
+}
+
+func (i impostor) PublicKey() solana.PublicKey {
+	return i.publicKey
+}
+
+func TestSignerServer_RequestTooLarge(t *testing.T) {
+	server := httptest.NewServer(NewSignerServer(NewKeypairSigner(solana.NewWallet().PrivateKey), nil))
+	defer server.Close()
+
+	body := `{"message": "` + strings.Repeat("A", int(maxSignRequestLen)) + `"}`
+	res, err := server.Client().Post(server.URL+remoteSignerSignPath, "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+
+	// The message of the largest transaction fits.
+	req, err := json.Marshal(&RemoteSignerSignRequest{Message: make([]byte, MaxTransactionSize)})
+	require.NoError(t, err)
+	res, err = server.Client().Post(server.URL+remoteSignerSignPath, "application/json", bytes.NewReader(req))
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.NotEqual(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+}
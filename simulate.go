@@ -0,0 +1,83 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// SimulationResult is the outcome of a transaction simulation.
+type SimulationResult struct {
+	// Err is nil if the transaction succeeded.
+	// Failed instructions are reported as *InstructionError, with the Pyth instruction type if applicable.
+	Err           error
+	Logs          []string
+	UnitsConsumed uint64
+}
+
+// Simulate runs the transaction against the current state of the cluster without submitting it.
+//
+// The transaction does not need to be signed, and its blockhash is replaced with a recent one.
+// The returned error is only set if the simulation itself failed,
+// the outcome of the transaction is reported in SimulationResult.Err.
+func (c *Client) Simulate(ctx context.Context, tx *solana.Transaction) (*SimulationResult, error) {
+	simTx := tx
+	if numSigners := int(tx.Message.Header.NumRequiredSignatures); len(tx.Signatures) != numSigners {
+		// The node requires a placeholder for each signature, which are not verified.
+		simTx = &solana.Transaction{
+			Signatures: make([]solana.Signature, numSigners),
+			Message:    tx.Message,
+		}
+	}
+	res, err := c.RPC.SimulateTransactionWithOpts(ctx, simTx, &rpc.SimulateTransactionOpts{
+		Commitment:             rpc.CommitmentConfirmed,
+		ReplaceRecentBlockhash: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to simulate transaction: %w", err)
+	}
+	if res.Value == nil {
+		return nil, fmt.Errorf("failed to simulate transaction: empty result")
+	}
+	result := &SimulationResult{Logs: res.Value.Logs}
+	if res.Value.UnitsConsumed != nil {
+		result.UnitsConsumed = *res.Value.UnitsConsumed
+	}
+	result.Err = c.resolveTransactionError(ParseTransactionError(res.Value.Err), tx, res.Value.Logs)
+	return result, nil
+}
+
+// instructionLogs returns the log messages of the top-level instruction with the given index,
+// including those of programs it invoked.
+func instructionLogs(logs []string, index int) []string {
+	var out []string
+	current := -1
+	for _, line := range logs {
+		if strings.HasPrefix(line, "Program ") && strings.HasSuffix(line, " invoke [1]") {
+			current++
+		}
+		if current == index {
+			out = append(out, line)
+		} else if current > index {
+			break
+		}
+	}
+	return out
+}
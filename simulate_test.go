@@ -0,0 +1,99 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	bin "github.com/gagliardetto/binary"
+	"github.com/gagliardetto/solana-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Simulate(t *testing.T) {
+	program := Devnet.Program.String()
+	logs := []string{
+		"Program ComputeBudget111111111111111111111111111111 invoke [1]",
+		"Program ComputeBudget111111111111111111111111111111 success",
+		"Program " + program + " invoke [1]",
+		"Program " + program + " consumed 2000 of 1400000 compute units",
+		"Program " + program + " success",
+		"Program " + program + " invoke [1]",
+		"Program " + program + " consumed 1500 of 1398000 compute units",
+		"Program " + program + " failed: custom program error: 0x267",
+	}
+	rpcServer := newFakeRPC(t)
+	rpcServer.handle("simulateTransaction", func(params json.RawMessage) interface{} {
+		var args []json.RawMessage
+		require.NoError(t, json.Unmarshal(params, &args))
+		var encoded string
+		require.NoError(t, json.Unmarshal(args[0], &encoded))
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		require.NoError(t, err)
+		// Unsigned transactions are sent with placeholder signatures.
+		tx, err := solana.TransactionFromDecoder(bin.NewBinDecoder(raw))
+		require.NoError(t, err)
+		require.Len(t, tx.Signatures, 1)
+		return map[string]interface{}{
+			"context": map[string]interface{}{"slot": 100},
+			"value": map[string]interface{}{
+				"err":           map[string]interface{}{"InstructionError": []interface{}{2, map[string]interface{}{"Custom": 615}}},
+				"logs":          logs,
+				"unitsConsumed": 3650,
+			},
+		}
+	})
+	client := NewClient(Devnet, rpcServer.URL, rpcServer.URL)
+
+	publisher := solana.NewWallet().PublicKey()
+	builder := NewInstructionBuilder(Devnet.Program)
+	tx, err := solana.NewTransaction([]solana.Instruction{
+		NewSetComputeUnitLimitInstruction(40_000),
+		builder.UpdPrice(publisher, solana.PublicKey{1}, CommandUpdPrice{}),
+		builder.UpdPrice(publisher, solana.PublicKey{2}, CommandUpdPrice{}),
+	}, testBlockhash, solana.TransactionPayer(publisher))
+	require.NoError(t, err)
+
+	result, err := client.Simulate(context.Background(), tx)
+	require.NoError(t, err)
+	assert.Equal(t, logs, result.Logs)
+	assert.Equal(t, uint64(3650), result.UnitsConsumed)
+	assert.True(t, errors.Is(result.Err, ErrPermissionViolation))
+	assert.False(t, IsStaleSlot(result.Err))
+	assert.EqualError(t, result.Err,
+		"instruction 2 (upd_price) failed: pyth: PermissionViolation (custom program error 0x267)")
+	var insErr *InstructionError
+	require.True(t, errors.As(result.Err, &insErr))
+	assert.Equal(t, logs[5:], insErr.Logs)
+}
+
+func TestInstructionLogs(t *testing.T) {
+	logs := []string{
+		"Program A invoke [1]",
+		"Program B invoke [2]",
+		"Program B success",
+		"Program A success",
+		"Program A invoke [1]",
+		"Program A failed: custom program error: 0x1",
+	}
+	assert.Equal(t, logs[:4], instructionLogs(logs, 0))
+	assert.Equal(t, logs[4:], instructionLogs(logs, 1))
+	assert.Empty(t, instructionLogs(logs, 2))
+}
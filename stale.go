@@ -0,0 +1,238 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+// defaultStaleCheckInterval is the default of HandlerOpts.StaleCheckInterval.
+const defaultStaleCheckInterval = time.Second
+
+// StaleOpts defines when a price feed is considered stale.
+//
+// A watchdog without limits could never fire, so registering one is a no-op.
+type StaleOpts struct {
+	// MaxSlots is the maximum number of slots between the last publish slot of the feed
+	// and the latest slot seen by the handler. Zero disables the check.
+	//
+	// If the handler consumes a PriceAccountStream opened with StreamOpts.TrackSlots,
+	// the slots of the slot subscription count as seen,
+	// so that the check fires even if all feeds stop updating.
+	MaxSlots uint64
+	// MaxDuration is the maximum time without the publish slot of the feed advancing.
+	// Zero disables the check.
+	MaxDuration time.Duration
+}
+
+// StaleEvent is passed to staleness callbacks.
+type StaleEvent struct {
+	PriceKey    solana.PublicKey
+	Publisher   solana.PublicKey // zero for the aggregate price
+	Stale       bool             // false if the feed has recovered
+	LastPubSlot uint64           // last publish slot seen, zero if none
+	LatestSlot  uint64           // latest slot seen by the handler, see StaleOpts.MaxSlots
+	LastUpdate  time.Time        // time the publish slot last advanced
+}
+
+// OnStale registers callbacks to be called when the aggregate price of the provided price account
+// stops updating, and when it recovers. The onRecovered callback may be nil.
+//
+// Staleness is evaluated periodically (see HandlerOpts.StaleCheckInterval),
+// so feeds that never update are detected as well.
+// The callbacks are called from a background goroutine, not from a dispatch worker.
+//
+// If opts sets neither MaxSlots nor MaxDuration, nothing is registered
+// and the returned handle does nothing.
+func (p *PriceEventHandler) OnStale(priceKey solana.PublicKey, opts StaleOpts, onStale, onRecovered func(StaleEvent)) CallbackHandle {
+	if !opts.valid() {
+		return CallbackHandle{}
+	}
+	w := p.newStaleWatch(priceKey, solana.PublicKey{}, opts, onStale, onRecovered)
+	handle := p.OnPriceChange(priceKey, func(update PriceUpdate) {
+		w.observe(update.CurrentInfo.PubSlot)
+	})
+	return p.watchStale(w, handle)
+}
+
+// OnComponentStale registers callbacks to be called when the price component
+// of the given (price account, publisher account) pair stops updating, and when it recovers.
+//
+// See OnStale for details.
+func (p *PriceEventHandler) OnComponentStale(priceKey solana.PublicKey, publisher solana.PublicKey, opts StaleOpts, onStale, onRecovered func(StaleEvent)) CallbackHandle {
+	if !opts.valid() {
+		return CallbackHandle{}
+	}
+	w := p.newStaleWatch(priceKey, publisher, opts, onStale, onRecovered)
+	handle := p.OnComponentChange(priceKey, publisher, func(update PriceUpdate) {
+		w.observe(update.CurrentInfo.PubSlot)
+	})
+	return p.watchStale(w, handle)
+}
+
+// valid reports whether at least one limit is set.
+func (o StaleOpts) valid() bool {
+	return o.MaxSlots > 0 || o.MaxDuration > 0
+}
+
+func (p *PriceEventHandler) newStaleWatch(priceKey, publisher solana.PublicKey, opts StaleOpts, onStale, onRecovered func(StaleEvent)) *staleWatch {
+	return &staleWatch{
+		priceKey:    priceKey,
+		publisher:   publisher,
+		opts:        opts,
+		onStale:     onStale,
+		onRecovered: onRecovered,
+		lastUpdate:  timeNow(),
+	}
+}
+
+func (p *PriceEventHandler) watchStale(w *staleWatch, handle CallbackHandle) CallbackHandle {
+	p.staleLock.Lock()
+	if p.staleWatches == nil {
+		p.staleWatches = make(map[*staleWatch]struct{})
+	}
+	p.staleWatches[w] = struct{}{}
+	p.staleLock.Unlock()
+
+	handle.cleanup = func() {
+		p.staleLock.Lock()
+		defer p.staleLock.Unlock()
+		delete(p.staleWatches, w)
+	}
+	p.startBackground("stale", p.runStaleChecks)
+	return handle
+}
+
+func (p *PriceEventHandler) runStaleChecks(stopped <-chan struct{}) {
+	interval := p.opts.StaleCheckInterval
+	if interval <= 0 {
+		// time.NewTicker panics on non-positive intervals.
+		interval = defaultStaleCheckInterval
+	}
+	if stream, ok := p.stream.(*PriceAccountStream); ok && stream.opts.TrackSlots {
+		stopSlots := stream.onSlot(p.observeTipSlot)
+		defer stopSlots()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopped:
+			return
+		case <-ticker.C:
+			p.checkStale()
+		}
+	}
+}
+
+func (p *PriceEventHandler) checkStale() {
+	p.staleLock.Lock()
+	watches := make([]*staleWatch, 0, len(p.staleWatches))
+	for w := range p.staleWatches {
+		watches = append(watches, w)
+	}
+	p.staleLock.Unlock()
+
+	now := timeNow()
+	latestSlot := p.LatestSlot()
+	if tip := atomic.LoadUint64(&p.tipSlot); tip > latestSlot {
+		latestSlot = tip
+	}
+	for _, w := range watches {
+		if event, ok := w.check(now, latestSlot); ok {
+			p.safeStaleCallback(w, event)
+		}
+	}
+}
+
+// observeTipSlot records a slot of the stream's slot subscription.
+func (p *PriceEventHandler) observeTipSlot(slot uint64) {
+	for {
+		prev := atomic.LoadUint64(&p.tipSlot)
+		if slot <= prev || atomic.CompareAndSwapUint64(&p.tipSlot, prev, slot) {
+			return
+		}
+	}
+}
+
+func (p *PriceEventHandler) safeStaleCallback(w *staleWatch, event StaleEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			metricsCallbackPanicsTotal.Inc()
+			if p.opts.OnPanic != nil {
+				p.opts.OnPanic(w.priceKey, r)
+			}
+		}
+	}()
+	if event.Stale {
+		w.onStale(event)
+	} else if w.onRecovered != nil {
+		w.onRecovered(event)
+	}
+}
+
+// staleWatch tracks the staleness of a single feed.
+type staleWatch struct {
+	priceKey    solana.PublicKey
+	publisher   solana.PublicKey
+	opts        StaleOpts
+	onStale     func(StaleEvent)
+	onRecovered func(StaleEvent)
+
+	lock        sync.Mutex
+	lastPubSlot uint64
+	lastUpdate  time.Time
+	stale       bool
+}
+
+// observe records the publish slot of a new update.
+func (w *staleWatch) observe(pubSlot uint64) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if pubSlot > w.lastPubSlot {
+		w.lastPubSlot = pubSlot
+		w.lastUpdate = timeNow()
+	}
+}
+
+// check evaluates the staleness limits and returns an event if the state has changed.
+func (w *staleWatch) check(now time.Time, latestSlot uint64) (StaleEvent, bool) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	var stale bool
+	if w.opts.MaxSlots > 0 && latestSlot > w.lastPubSlot+w.opts.MaxSlots {
+		stale = true
+	}
+	if w.opts.MaxDuration > 0 && now.Sub(w.lastUpdate) > w.opts.MaxDuration {
+		stale = true
+	}
+	if stale == w.stale {
+		return StaleEvent{}, false
+	}
+	w.stale = stale
+	return StaleEvent{
+		PriceKey:    w.priceKey,
+		Publisher:   w.publisher,
+		Stale:       stale,
+		LastPubSlot: w.lastPubSlot,
+		LatestSlot:  latestSlot,
+		LastUpdate:  w.lastUpdate,
+	}, true
+}
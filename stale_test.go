@@ -0,0 +1,153 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaleWatch_Slots(t *testing.T) {
+	now := time.Unix(1660000000, 0)
+	w := &staleWatch{opts: StaleOpts{MaxSlots: 10}, lastUpdate: now}
+
+	_, changed := w.check(now, 0)
+	assert.False(t, changed, "no slots seen yet")
+
+	w.observe(100)
+	_, changed = w.check(now, 110)
+	assert.False(t, changed)
+	event, changed := w.check(now, 111)
+	require.True(t, changed)
+	assert.True(t, event.Stale)
+	assert.Equal(t, uint64(100), event.LastPubSlot)
+	assert.Equal(t, uint64(111), event.LatestSlot)
+	_, changed = w.check(now, 120)
+	assert.False(t, changed, "still stale")
+
+	w.observe(115)
+	event, changed = w.check(now, 120)
+	require.True(t, changed)
+	assert.False(t, event.Stale)
+}
+
+func TestStaleWatch_Duration(t *testing.T) {
+	now := fakeClock(t)
+	w := &staleWatch{opts: StaleOpts{MaxDuration: time.Minute}, lastUpdate: *now}
+
+	_, changed := w.check(now.Add(time.Minute), 0)
+	assert.False(t, changed)
+	event, changed := w.check(now.Add(61*time.Second), 0)
+	require.True(t, changed)
+	assert.True(t, event.Stale, "feed never updated")
+
+	*now = now.Add(2 * time.Minute)
+	w.observe(1)
+	event, changed = w.check(*now, 0)
+	require.True(t, changed)
+	assert.False(t, event.Stale)
+}
+
+func TestPriceEventHandler_OnStale(t *testing.T) {
+	src := newTestSource()
+	// Checks are triggered manually below.
+	handler := NewPriceEventHandlerWithOpts(src, &HandlerOpts{StaleCheckInterval: time.Hour})
+
+	var events []StaleEvent
+	record := func(event StaleEvent) {
+		events = append(events, event)
+	}
+	handler.OnStale(testPriceKey, StaleOpts{MaxSlots: 5}, record, record)
+
+	// Updates are processed asynchronously, wait for each of them.
+	processed := make(chan struct{})
+	handler.OnPriceChange(testPriceKey, func(PriceUpdate) { processed <- struct{}{} })
+	otherKey := solana.PublicKey{1, 2, 3}
+	handler.OnPriceChange(otherKey, func(PriceUpdate) { processed <- struct{}{} })
+	send := func(key solana.PublicKey, slot uint64) {
+		src.send(key, 1, slot)
+		<-processed
+	}
+
+	send(testPriceKey, 100)
+	handler.checkStale()
+	assert.Empty(t, events)
+
+	send(otherKey, 110) // advances the latest slot
+	handler.checkStale()
+	require.Len(t, events, 1)
+	assert.True(t, events[0].Stale)
+	assert.Equal(t, uint64(100), events[0].LastPubSlot)
+	assert.Equal(t, uint64(110), events[0].LatestSlot)
+
+	send(testPriceKey, 111)
+	handler.checkStale()
+	require.Len(t, events, 2)
+	assert.False(t, events[1].Stale)
+	assert.Equal(t, uint64(111), events[1].LastPubSlot)
+
+	src.Close()
+	require.NoError(t, handler.Wait())
+}
+
+func TestPriceEventHandler_OnStale_NoLimits(t *testing.T) {
+	handler := NewPriceEventHandler(newTestSource())
+	noop := func(StaleEvent) {}
+	handle := handler.OnStale(testPriceKey, StaleOpts{}, noop, nil)
+	handle.Unsubscribe()
+	handle = handler.OnComponentStale(testPriceKey, solana.PublicKey{1}, StaleOpts{MaxDuration: -time.Second}, noop, nil)
+	handle.Unsubscribe()
+	assert.Empty(t, handler.staleWatches)
+}
+
+func TestPriceEventHandler_OnStale_StreamSlots(t *testing.T) {
+	stream := &PriceAccountStream{
+		updates: make(chan PriceAccountEntry),
+		opts:    StreamOpts{TrackSlots: true},
+		metrics: newStreamMetrics(Devnet.Program),
+	}
+	handler := NewPriceEventHandlerWithOpts(stream, &HandlerOpts{StaleCheckInterval: time.Hour})
+
+	events := make(chan StaleEvent, 1)
+	handler.OnStale(testPriceKey, StaleOpts{MaxSlots: 5}, func(event StaleEvent) { events <- event }, nil)
+	require.Eventually(t, func() bool {
+		stream.slotHooksLock.Lock()
+		defer stream.slotHooksLock.Unlock()
+		return len(stream.slotHooks) == 1
+	}, 5*time.Second, time.Millisecond)
+
+	// No account updates arrive, but the cluster keeps producing slots.
+	stream.observeTip(100)
+	handler.checkStale()
+	event := <-events
+	assert.True(t, event.Stale)
+	assert.Equal(t, uint64(100), event.LatestSlot)
+	assert.Zero(t, handler.LatestSlot())
+
+	close(stream.updates)
+	require.NoError(t, handler.Wait())
+	assert.Empty(t, stream.slotHooks)
+}
+
+func TestPriceEventHandler_RunStaleChecks_DefaultInterval(t *testing.T) {
+	handler := &PriceEventHandler{}
+	stopped := make(chan struct{})
+	close(stopped)
+	assert.NotPanics(t, func() { handler.runStaleChecks(stopped) })
+}
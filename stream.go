@@ -19,12 +19,14 @@ import (
 	"errors"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
 	"github.com/gagliardetto/solana-go"
 	"github.com/gagliardetto/solana-go/rpc"
 	"github.com/gagliardetto/solana-go/rpc/ws"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
@@ -32,31 +34,140 @@ import (
 //
 // It will reconnect automatically if the WebSocket connection breaks or stalls.
 func (c *Client) StreamPriceAccounts() *PriceAccountStream {
-	ctx, cancel := context.WithCancel(context.Background())
+	return c.StreamPriceAccountsWithOpts(nil)
+}
+
+// StreamOpts configures a price account stream.
+type StreamOpts struct {
+	// TrackSlots additionally subscribes to slot updates
+	// to measure how far the stream lags behind the cluster tip.
+	TrackSlots bool
+
+	// MaxIdle is the time without updates after which the stream is reported unhealthy.
+	// Defaults to 20 seconds.
+	MaxIdle time.Duration
+	// MaxTipLag is the number of slots the stream may lag behind the cluster tip
+	// before it is reported unhealthy. Requires TrackSlots. Zero disables the check.
+	MaxTipLag uint64
+
+	// Coalesce keeps only the latest update of each price account
+	// instead of sending every update to the Updates channel.
+	// Updates are then read through PriceAccountStream.Coalesced.
+	Coalesce bool
+
+	// PoolAccounts takes the PriceAccount of each update from a shared pool
+	// to avoid allocating a new account per update.
+	// Consumers must call ReleasePriceAccount once they are done with an update.
+	// PriceEventHandler does so automatically. Ignored if Coalesce is set.
+	PoolAccounts bool
+}
+
+// StreamPriceAccountsWithOpts creates a new stream of price account updates with custom options.
+//
+// It will reconnect automatically if the WebSocket connection breaks or stalls.
+func (c *Client) StreamPriceAccountsWithOpts(opts *StreamOpts) *PriceAccountStream {
+	return c.StreamPriceAccountsContext(context.Background(), opts)
+}
+
+// StreamPriceAccountsContext creates a new stream of price account updates
+// that ends when the given context is cancelled. The opts may be nil.
+//
+// It will reconnect automatically if the WebSocket connection breaks or stalls.
+func (c *Client) StreamPriceAccountsContext(ctx context.Context, opts *StreamOpts) *PriceAccountStream {
+	if opts == nil {
+		opts = new(StreamOpts)
+	}
+	ctx, cancel := context.WithCancel(ctx)
 	stream := &PriceAccountStream{
 		cancel:  cancel,
 		updates: make(chan PriceAccountEntry),
+		done:    make(chan struct{}),
 		client:  c,
+		opts:    *opts,
+		metrics: newStreamMetrics(c.Env.Program),
+	}
+	if stream.opts.Coalesce {
+		stream.coalescer = NewPriceAccountCoalescer()
+	}
+	if stream.opts.MaxIdle <= 0 {
+		stream.opts.MaxIdle = 20 * time.Second
 	}
 	stream.errLock.Lock()
 	go stream.runWrapper(ctx)
 	return stream
 }
 
+// PriceAccountSource delivers price account updates, such as a live stream or a replayed recording.
+type PriceAccountSource interface {
+	// Updates returns a channel with new price account updates.
+	// The channel is closed when the source has ended.
+	Updates() <-chan PriceAccountEntry
+	// Err returns the reason why the source has ended.
+	// Will block until the source has actually closed.
+	Err() error
+	// Close stops the source.
+	// Will block until the source has released all resources.
+	Close()
+}
+
 // PriceAccountStream is an ongoing stream of on-chain price account updates.
 type PriceAccountStream struct {
 	cancel  context.CancelFunc
 	updates chan PriceAccountEntry
+	done    chan struct{}  // closed when all goroutines have exited
+	wg      sync.WaitGroup // background goroutines of the current connection
 	client  *Client
-	err     error
-	errLock sync.Mutex
+	opts    StreamOpts
+	// coalescer replaces the updates channel if StreamOpts.Coalesce is set
+	coalescer *PriceAccountCoalescer
+	err       error
+	errLock   sync.Mutex
+
+	healthLock sync.Mutex
+	health     StreamHealth
+	metrics    streamMetrics
+
+	slotHooksLock sync.Mutex // lock over slotHooks
+	slotHooks     map[uint64]func(uint64)
+	slotHookNonce uint64
+}
+
+// streamMetrics are the health metrics of a stream, labeled with the program
+// so that streams of different programs do not overwrite each other.
+type streamMetrics struct {
+	lastUpdateTimestamp prometheus.Gauge
+	updateSlotLag       prometheus.Observer
+	tipSlotLag          prometheus.Gauge
+	reconnects          *prometheus.CounterVec // by reason
+	decodeFailures      prometheus.Counter
+}
+
+func newStreamMetrics(program solana.PublicKey) streamMetrics {
+	label := program.String()
+	return streamMetrics{
+		lastUpdateTimestamp: metricsLastUpdateTimestamp.WithLabelValues(label),
+		updateSlotLag:       metricsUpdateSlotLag.WithLabelValues(label),
+		tipSlotLag:          metricsTipSlotLag.WithLabelValues(label),
+		reconnects:          metricsWsReconnectsTotal.MustCurryWith(prometheus.Labels{"program": label}),
+		decodeFailures:      metricsDecodeFailuresTotal.WithLabelValues(label),
+	}
 }
 
 // Updates returns a channel with new price account updates.
+//
+// If the stream was opened with StreamOpts.Coalesce,
+// no updates are sent and the channel is only closed when the stream ends.
 func (p *PriceAccountStream) Updates() <-chan PriceAccountEntry {
 	return p.updates
 }
 
+// Coalesced returns the latest-value buffer of the stream.
+//
+// Returns nil unless the stream was opened with StreamOpts.Coalesce.
+func (p *PriceAccountStream) Coalesced() *PriceAccountCoalescer {
+	return p.coalescer
+}
+
 // Err returns the reason why the price account stream is closed.
 // Will block until the stream has actually closed.
 // Returns nil if closure was expected.
@@ -67,13 +178,34 @@ func (p *PriceAccountStream) Err() error {
 }
 
 // Close must be called when no more updates are needed.
+//
+// Close blocks until all goroutines of the stream have exited.
+// Any updates that have not been consumed yet are discarded.
 func (p *PriceAccountStream) Close() {
 	p.cancel()
+	for range p.updates {
+		// drain
+	}
+	<-p.done
+}
+
+// Done returns a channel that is closed once the stream has fully shut down.
+func (p *PriceAccountStream) Done() <-chan struct{} {
+	return p.done
 }
 
 func (p *PriceAccountStream) runWrapper(ctx context.Context) {
+	defer close(p.done)
 	defer p.errLock.Unlock()
-	p.err = p.run(ctx)
+	err := p.run(ctx)
+	p.wg.Wait()
+	if p.coalescer != nil {
+		p.coalescer.Close()
+	}
+	if errors.Is(err, context.Canceled) {
+		err = nil
+	}
+	p.err = err
 }
 
 func (p *PriceAccountStream) run(ctx context.Context) error {
@@ -85,7 +217,9 @@ func (p *PriceAccountStream) run(ctx context.Context) error {
 		case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
 			return backoff.Permanent(err)
 		default:
-			p.client.Log.Error("Stream failed, restarting", zap.Error(err))
+			reason := streamErrorReason(err)
+			p.client.Log.Error("Stream failed, restarting", zap.Error(err), zap.String("reason", reason))
+			p.recordReconnect(reason)
 			return err
 		}
 	}, backoff.WithContext(backoff.NewConstantBackOff(retryInterval), ctx))
@@ -94,18 +228,24 @@ func (p *PriceAccountStream) run(ctx context.Context) error {
 func (p *PriceAccountStream) runConn(ctx context.Context) error {
 	client, err := ws.Connect(ctx, p.client.WebSocketURL)
 	if err != nil {
-		return err
+		return &streamError{reason: reasonConnect, err: err}
 	}
 	defer client.Close()
 
 	// Make sure client cannot outlive context.
+	connCtx, connCancel := context.WithCancel(ctx)
+	defer connCancel()
+	p.wg.Add(1)
 	go func() {
-		defer client.Close()
-		<-ctx.Done()
+		defer p.wg.Done()
+		<-connCtx.Done()
+		client.Close()
 	}()
 
 	metricsWsActiveConns.Inc()
 	defer metricsWsActiveConns.Dec()
+	p.setConnected(true)
+	defer p.setConnected(false)
 
 	sub, err := client.ProgramSubscribeWithOpts(
 		p.client.Env.Program,
@@ -124,38 +264,77 @@ func (p *PriceAccountStream) runConn(ctx context.Context) error {
 		},
 	)
 	if err != nil {
-		return err
+		return &streamError{reason: reasonSubscribe, err: err}
+	}
+
+	if p.opts.TrackSlots {
+		slotSub, err := client.SlotSubscribe()
+		if err != nil {
+			return &streamError{reason: reasonSubscribe, err: err}
+		}
+		defer slotSub.Unsubscribe()
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.trackSlots(slotSub)
+		}()
 	}
 
 	// Stream updates.
+	watchdog := newReadWatchdog(p.client.Log, sub.Unsubscribe)
+	defer watchdog.disarm()
 	for {
-		if err := p.readNextUpdate(ctx, sub); err != nil {
+		if err := p.readNextUpdate(ctx, sub, watchdog); err != nil {
 			return err
 		}
 	}
 }
 
-func (p *PriceAccountStream) readNextUpdate(ctx context.Context, sub *ws.ProgramSubscription) error {
-	// If no update comes in within 20 seconds, bail.
-	const readTimeout = 20 * time.Second
-	ctx, cancel := context.WithTimeout(ctx, readTimeout)
-	defer cancel()
-	go func() {
-		<-ctx.Done()
-		// Terminate subscription if above timer has expired.
-		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-			p.client.Log.Warn("Read deadline exceeded, terminating WebSocket connection",
-				zap.Duration("timeout", readTimeout))
-			sub.Unsubscribe()
-		}
-	}()
+// readTimeout is the maximum time to wait for the next WebSocket message before reconnecting.
+const readTimeout = 20 * time.Second
 
+// readWatchdog terminates a subscription if no message was received in time.
+//
+// A single watchdog is used per connection and re-armed for each read.
+type readWatchdog struct {
+	timer   *time.Timer
+	expired uint32 // atomic
+}
+
+func newReadWatchdog(log *zap.Logger, unsubscribe func()) *readWatchdog {
+	w := new(readWatchdog)
+	w.timer = time.AfterFunc(readTimeout, func() {
+		atomic.StoreUint32(&w.expired, 1)
+		log.Warn("Read deadline exceeded, terminating WebSocket connection",
+			zap.Duration("timeout", readTimeout))
+		unsubscribe()
+	})
+	w.timer.Stop()
+	return w
+}
+
+// arm starts the timeout for the next read.
+func (w *readWatchdog) arm() {
+	w.timer.Reset(readTimeout)
+}
+
+// disarm stops the timeout and reports whether it has expired.
+func (w *readWatchdog) disarm() (expired bool) {
+	w.timer.Stop()
+	return atomic.LoadUint32(&w.expired) != 0
+}
+
+func (p *PriceAccountStream) readNextUpdate(ctx context.Context, sub *ws.ProgramSubscription, watchdog *readWatchdog) error {
 	// Read next account update from WebSockets.
+	// The watchdog is disarmed while waiting on the consumer, so slow consumers do not cause reconnects.
+	watchdog.arm()
 	update, err := sub.Recv()
-	if err != nil {
-		return err
+	if watchdog.disarm() {
+		return &streamError{reason: reasonReadTimeout, err: errReadTimeout}
+	} else if err != nil {
+		return &streamError{reason: reasonRecv, err: err}
 	} else if update == nil {
-		return net.ErrClosed
+		return &streamError{reason: reasonClosed, err: net.ErrClosed}
 	}
 	metricsWsEventsTotal.Inc()
 
@@ -167,22 +346,201 @@ func (p *PriceAccountStream) readNextUpdate(ctx context.Context, sub *ws.Program
 	if PeekAccount(accountData) != AccountTypePrice {
 		return nil
 	}
-	priceAcc := new(PriceAccount)
+	var priceAcc *PriceAccount
+	if p.pooled() {
+		priceAcc = AcquirePriceAccount()
+	} else {
+		priceAcc = new(PriceAccount)
+	}
 	if err := priceAcc.UnmarshalBinary(accountData); err != nil {
 		p.client.Log.Warn("Failed to unmarshal priceAcc account", zap.Error(err))
+		p.recordDecodeFailure()
+		if p.pooled() {
+			ReleasePriceAccount(priceAcc)
+		}
 		return nil
 	}
+	p.recordUpdate(update.Context.Slot, priceAcc)
 
 	// Send update to channel.
 	msg := PriceAccountEntry{
 		Slot:         update.Context.Slot,
 		Pubkey:       update.Value.Pubkey,
 		PriceAccount: priceAcc,
+		Raw:          accountData,
+	}
+	if p.coalescer != nil {
+		p.coalescer.Put(msg)
+		return nil
 	}
 	select {
 	case <-ctx.Done():
+		if p.pooled() {
+			ReleasePriceAccount(priceAcc)
+		}
 		return ctx.Err()
 	case p.updates <- msg:
 		return nil
 	}
 }
+
+// pooled returns whether price accounts are taken from the shared pool.
+func (p *PriceAccountStream) pooled() bool {
+	return p.opts.PoolAccounts && p.coalescer == nil
+}
+
+func (p *PriceAccountStream) trackSlots(sub *ws.SlotSubscription) {
+	for {
+		slot, err := sub.Recv()
+		if err != nil || slot == nil {
+			return
+		}
+		p.observeTip(slot.Slot)
+	}
+}
+
+// observeTip records a slot of the slot subscription.
+func (p *PriceAccountStream) observeTip(slot uint64) {
+	p.healthLock.Lock()
+	if slot > p.health.TipSlot {
+		p.health.TipSlot = slot
+	}
+	if lag, ok := p.health.TipLag(); ok {
+		p.metrics.tipSlotLag.Set(float64(lag))
+	}
+	p.healthLock.Unlock()
+
+	p.slotHooksLock.Lock()
+	defer p.slotHooksLock.Unlock()
+	for _, hook := range p.slotHooks {
+		hook(slot)
+	}
+}
+
+// onSlot registers a function called with each slot of the slot subscription,
+// which requires StreamOpts.TrackSlots. The function must not block.
+func (p *PriceAccountStream) onSlot(hook func(slot uint64)) (cancel func()) {
+	p.slotHooksLock.Lock()
+	defer p.slotHooksLock.Unlock()
+	if p.slotHooks == nil {
+		p.slotHooks = make(map[uint64]func(uint64))
+	}
+	p.slotHookNonce++
+	key := p.slotHookNonce
+	p.slotHooks[key] = hook
+	return func() {
+		p.slotHooksLock.Lock()
+		defer p.slotHooksLock.Unlock()
+		delete(p.slotHooks, key)
+	}
+}
+
+func (p *PriceAccountStream) recordUpdate(slot uint64, acc *PriceAccount) {
+	now := time.Now()
+	p.metrics.lastUpdateTimestamp.Set(float64(now.UnixNano()) / 1e9)
+	if slot >= acc.Agg.PubSlot && acc.Agg.PubSlot != 0 {
+		p.metrics.updateSlotLag.Observe(float64(slot - acc.Agg.PubSlot))
+	}
+
+	p.healthLock.Lock()
+	defer p.healthLock.Unlock()
+	p.health.LastUpdate = now
+	if slot > p.health.LastSlot {
+		p.health.LastSlot = slot
+	}
+}
+
+func (p *PriceAccountStream) recordReconnect(reason string) {
+	p.metrics.reconnects.WithLabelValues(reason).Inc()
+
+	p.healthLock.Lock()
+	defer p.healthLock.Unlock()
+	p.health.Reconnects++
+	p.health.LastReconnectReason = reason
+}
+
+func (p *PriceAccountStream) recordDecodeFailure() {
+	p.metrics.decodeFailures.Inc()
+
+	p.healthLock.Lock()
+	defer p.healthLock.Unlock()
+	p.health.DecodeFailures++
+}
+
+func (p *PriceAccountStream) setConnected(connected bool) {
+	p.healthLock.Lock()
+	defer p.healthLock.Unlock()
+	p.health.Connected = connected
+}
+
+// Health returns a snapshot of the stream's health.
+func (p *PriceAccountStream) Health() StreamHealth {
+	p.healthLock.Lock()
+	health := p.health
+	p.healthLock.Unlock()
+
+	health.Healthy = health.Connected && time.Since(health.LastUpdate) <= p.opts.MaxIdle
+	if lag, ok := health.TipLag(); ok && p.opts.MaxTipLag > 0 && lag > p.opts.MaxTipLag {
+		health.Healthy = false
+	}
+	return health
+}
+
+// StreamHealth describes the state of a price account stream, e.g. for readiness probes.
+type StreamHealth struct {
+	Healthy             bool      // connected, receiving updates and not lagging behind
+	Connected           bool      // whether a WebSocket subscription is active
+	LastUpdate          time.Time // receive time of the last price account update
+	LastSlot            uint64    // highest slot of any price account update
+	TipSlot             uint64    // highest cluster slot seen, requires StreamOpts.TrackSlots
+	Reconnects          uint64    // number of times the connection was restarted
+	LastReconnectReason string    // reason for the last reconnect
+	DecodeFailures      uint64    // number of price accounts that failed to decode
+}
+
+// TipLag returns how many slots the stream lags behind the cluster tip.
+//
+// If ok is false, the lag is unknown.
+func (h *StreamHealth) TipLag() (lag uint64, ok bool) {
+	if h.TipSlot == 0 || h.LastSlot == 0 {
+		return 0, false
+	}
+	if h.LastSlot >= h.TipSlot {
+		return 0, true
+	}
+	return h.TipSlot - h.LastSlot, true
+}
+
+// Reasons for restarting a stream.
+const (
+	reasonConnect     = "connect"
+	reasonSubscribe   = "subscribe"
+	reasonReadTimeout = "read_timeout"
+	reasonRecv        = "recv"
+	reasonClosed      = "closed"
+	reasonUnknown     = "unknown"
+)
+
+var errReadTimeout = errors.New("read deadline exceeded")
+
+// streamError annotates a stream failure with the reason for reconnecting.
+type streamError struct {
+	reason string
+	err    error
+}
+
+func (e *streamError) Error() string {
+	return e.reason + ": " + e.err.Error()
+}
+
+func (e *streamError) Unwrap() error {
+	return e.err
+}
+
+func streamErrorReason(err error) string {
+	var streamErr *streamError
+	if errors.As(err, &streamErr) {
+		return streamErr.reason
+	}
+	return reasonUnknown
+}
@@ -15,8 +15,17 @@
 package pyth
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
+	"testing"
 	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
 )
 
 func ExampleClient_StreamPriceAccounts() {
@@ -32,3 +41,144 @@ func ExampleClient_StreamPriceAccounts() {
 		fmt.Println(update.Agg.Price)
 	}
 }
+
+func TestPriceAccountStream_Health(t *testing.T) {
+	stream := &PriceAccountStream{
+		opts: StreamOpts{
+			MaxIdle:   time.Minute,
+			MaxTipLag: 10,
+		},
+		metrics: newStreamMetrics(Devnet.Program),
+	}
+	assert.False(t, stream.Health().Healthy)
+
+	stream.setConnected(true)
+	assert.False(t, stream.Health().Healthy, "no updates yet")
+
+	acc := priceAccount_E36MyBbavhYKHVLWR79GiReNNnBDiHj6nWA7htbkNZbh
+	stream.recordUpdate(acc.Agg.PubSlot+2, &acc)
+	health := stream.Health()
+	assert.True(t, health.Healthy)
+	assert.Equal(t, acc.Agg.PubSlot+2, health.LastSlot)
+	_, ok := health.TipLag()
+	assert.False(t, ok)
+
+	stream.health.TipSlot = acc.Agg.PubSlot + 20
+	health = stream.Health()
+	lag, ok := health.TipLag()
+	assert.True(t, ok)
+	assert.Equal(t, uint64(18), lag)
+	assert.False(t, health.Healthy, "lagging behind tip")
+
+	stream.health.TipSlot = acc.Agg.PubSlot + 5
+	assert.True(t, stream.Health().Healthy)
+
+	stream.health.LastUpdate = time.Now().Add(-2 * time.Minute)
+	assert.False(t, stream.Health().Healthy, "idle")
+}
+
+func TestPriceAccountStream_MetricsPerProgram(t *testing.T) {
+	devnet := &PriceAccountStream{metrics: newStreamMetrics(Devnet.Program)}
+	mainnet := &PriceAccountStream{metrics: newStreamMetrics(Mainnet.Program)}
+
+	acc := priceAccount_E36MyBbavhYKHVLWR79GiReNNnBDiHj6nWA7htbkNZbh
+	devnet.recordUpdate(acc.Agg.PubSlot, &acc)
+	before := testutil.ToFloat64(metricsLastUpdateTimestamp.WithLabelValues(Devnet.Program.String()))
+	time.Sleep(10 * time.Millisecond)
+	mainnet.recordUpdate(acc.Agg.PubSlot, &acc)
+
+	// An update of one stream does not mask an idle stream of another program.
+	assert.Equal(t, before, testutil.ToFloat64(metricsLastUpdateTimestamp.WithLabelValues(Devnet.Program.String())))
+	assert.Greater(t, testutil.ToFloat64(metricsLastUpdateTimestamp.WithLabelValues(Mainnet.Program.String())), before)
+
+	// Reconnects and decode failures are counted per program as well.
+	reconnects := func(program solana.PublicKey) float64 {
+		return testutil.ToFloat64(metricsWsReconnectsTotal.WithLabelValues(program.String(), reasonReadTimeout))
+	}
+	decodeFailures := func(program solana.PublicKey) float64 {
+		return testutil.ToFloat64(metricsDecodeFailuresTotal.WithLabelValues(program.String()))
+	}
+	devnetReconnects, devnetDecodeFailures := reconnects(Devnet.Program), decodeFailures(Devnet.Program)
+	mainnetReconnects, mainnetDecodeFailures := reconnects(Mainnet.Program), decodeFailures(Mainnet.Program)
+	devnet.recordReconnect(reasonReadTimeout)
+	devnet.recordDecodeFailure()
+	assert.Equal(t, devnetReconnects+1, reconnects(Devnet.Program))
+	assert.Equal(t, devnetDecodeFailures+1, decodeFailures(Devnet.Program))
+	assert.Equal(t, mainnetReconnects, reconnects(Mainnet.Program))
+	assert.Equal(t, mainnetDecodeFailures, decodeFailures(Mainnet.Program))
+	health := devnet.Health()
+	assert.Equal(t, uint64(1), health.Reconnects)
+	assert.Equal(t, reasonReadTimeout, health.LastReconnectReason)
+	assert.Equal(t, uint64(1), health.DecodeFailures)
+}
+
+func TestStreamErrorReason(t *testing.T) {
+	assert.Equal(t, "read_timeout", streamErrorReason(&streamError{reason: reasonReadTimeout, err: errReadTimeout}))
+	assert.Equal(t, "closed", streamErrorReason(fmt.Errorf("wrapped: %w", &streamError{reason: reasonClosed, err: net.ErrClosed})))
+	assert.Equal(t, "unknown", streamErrorReason(errors.New("other")))
+}
+
+func TestPriceAccountStream_Context(t *testing.T) {
+	client := NewClient(Devnet, "http://127.0.0.1:1", "ws://127.0.0.1:1")
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := client.StreamPriceAccountsContext(ctx, nil)
+	cancel()
+
+	select {
+	case <-stream.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream did not shut down after context cancellation")
+	}
+	_, ok := <-stream.Updates()
+	assert.False(t, ok)
+	assert.NoError(t, stream.Err())
+}
+
+func TestPriceAccountStream_Close(t *testing.T) {
+	client := NewClient(Devnet, "http://127.0.0.1:1", "ws://127.0.0.1:1")
+	stream := client.StreamPriceAccounts()
+	stream.Close()
+
+	select {
+	case <-stream.Done():
+	default:
+		t.Fatal("Close returned before stream shut down")
+	}
+	assert.NoError(t, stream.Err())
+}
+
+func TestReadWatchdog(t *testing.T) {
+	expired := make(chan struct{})
+	watchdog := newReadWatchdog(zap.NewNop(), func() { close(expired) })
+	watchdog.timer.Reset(time.Millisecond)
+	<-expired
+	assert.True(t, watchdog.disarm())
+}
+
+func BenchmarkReadWatchdog(b *testing.B) {
+	b.ReportAllocs()
+	watchdog := newReadWatchdog(zap.NewNop(), func() {})
+	defer watchdog.disarm()
+	for i := 0; i < b.N; i++ {
+		watchdog.arm()
+		if watchdog.disarm() {
+			b.Fatal("watchdog expired")
+		}
+	}
+}
+
+// BenchmarkReadTimeoutPerMessage measures the previous approach
+// of spawning a timeout context and goroutine for each message.
+func BenchmarkReadTimeoutPerMessage(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), readTimeout)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			<-ctx.Done()
+		}()
+		cancel()
+		<-done
+	}
+}
@@ -0,0 +1,305 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"path"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+// Subscription delivers events of type T over a channel.
+//
+// The channel is closed after Unsubscribe, or when the event handler stops.
+type Subscription[T any] struct {
+	c       chan T
+	drop    bool
+	done    chan struct{} // closed on Unsubscribe, unblocks pending sends
+	lock    sync.RWMutex  // held for reading while sending
+	closed  bool
+	once    sync.Once
+	dropped uint64 // atomic
+	cancel  func() // de-registers the subscription
+}
+
+func newSubscription[T any](buffer int, drop bool) *Subscription[T] {
+	if buffer < 0 {
+		buffer = 0
+	}
+	return &Subscription[T]{
+		c:    make(chan T, buffer),
+		drop: drop,
+		done: make(chan struct{}),
+	}
+}
+
+// C returns the channel of events.
+func (s *Subscription[T]) C() <-chan T {
+	return s.c
+}
+
+// Dropped returns the number of events discarded because the channel was full.
+//
+// Always zero unless the subscription was created with DropWhenFull.
+func (s *Subscription[T]) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Unsubscribe stops the delivery of events and closes the channel.
+//
+// Buffered events not yet received remain readable.
+// It is safe to call Unsubscribe multiple times.
+func (s *Subscription[T]) Unsubscribe() {
+	s.once.Do(func() {
+		close(s.done)
+		if s.cancel != nil {
+			s.cancel()
+		}
+		// Wait for in-flight sends to return before closing the channel.
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.closed = true
+		close(s.c)
+	})
+}
+
+// send delivers an event, blocking or dropping if the channel is full.
+func (s *Subscription[T]) send(event T) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.closed {
+		return
+	}
+	if s.drop {
+		select {
+		case s.c <- event:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+		return
+	}
+	select {
+	case s.c <- event:
+	case <-s.done:
+	}
+}
+
+// SubscribeFilter selects the price updates delivered to a subscription.
+//
+// The zero value matches the aggregate prices of all price accounts.
+// Non-empty fields are combined with AND.
+type SubscribeFilter struct {
+	// PriceKeys restricts updates to the given price accounts.
+	PriceKeys []solana.PublicKey
+	// Symbol restricts updates to price accounts of products
+	// whose symbol matches the pattern, using the syntax of path.Match.
+	// For example, "Crypto.*/USD" matches all crypto prices quoted in USD.
+	//
+	// Requires product metadata, see PriceEventHandler.SetProducts.
+	Symbol string
+	// Publishers selects component price updates of the given publishers
+	// instead of aggregate price updates.
+	Publishers []solana.PublicKey
+}
+
+// SubscribeOpts configures a channel subscription.
+type SubscribeOpts struct {
+	Filter SubscribeFilter
+	// Buffer is the capacity of the channel. Zero creates an unbuffered channel.
+	Buffer int
+	// Policy decides which updates are delivered. Defaults to ChangeStatusOrSlot.
+	Policy ChangePolicy
+	// DropWhenFull discards events when the channel is full.
+	// Otherwise, a full channel blocks the dispatch worker of the price account,
+	// which eventually applies backpressure to the stream.
+	DropWhenFull bool
+}
+
+// subscriber receives every price account update processed by the handler.
+type subscriber struct {
+	deliver func(priceKey solana.PublicKey, acc *PriceAccount)
+	close   func()
+}
+
+// SetProducts provides product metadata used to resolve symbols in SubscribeFilter.Symbol.
+//
+// Products are usually fetched with Client.GetAllProductAccounts.
+// Calling SetProducts again replaces the previous metadata.
+func (p *PriceEventHandler) SetProducts(products []ProductAccountEntry) {
+	symbols := make(map[solana.PublicKey]string, len(products))
+	for _, product := range products {
+		if product.ProductAccount == nil {
+			continue
+		}
+		if symbol, ok := product.Attrs.KVs()["symbol"]; ok {
+			symbols[product.Pubkey] = symbol
+		}
+	}
+	p.subsLock.Lock()
+	defer p.subsLock.Unlock()
+	p.symbols = symbols
+}
+
+// productSymbol returns the symbol of the product of a price account.
+func (p *PriceEventHandler) productSymbol(acc *PriceAccount) (string, bool) {
+	p.subsLock.RLock()
+	defer p.subsLock.RUnlock()
+	symbol, ok := p.symbols[acc.Product]
+	return symbol, ok
+}
+
+// Subscribe returns a channel subscription to price updates matching the filter.
+//
+// Updates are delivered when the price has changed according to SubscribeOpts.Policy.
+// Updates of the same price account are delivered in order.
+//
+// The opts may be nil, which subscribes to all aggregate prices over an unbuffered channel.
+// If the underlying stream uses StreamOpts.PoolAccounts, delivered accounts are copies.
+func (p *PriceEventHandler) Subscribe(opts *SubscribeOpts) *Subscription[PriceUpdate] {
+	if opts == nil {
+		opts = new(SubscribeOpts)
+	}
+	sub := newSubscription[PriceUpdate](opts.Buffer, opts.DropWhenFull)
+	filter := opts.Filter
+	policy := opts.Policy.orDefault()
+
+	var priceKeys map[solana.PublicKey]struct{}
+	if len(filter.PriceKeys) > 0 {
+		priceKeys = make(map[solana.PublicKey]struct{}, len(filter.PriceKeys))
+		for _, key := range filter.PriceKeys {
+			priceKeys[key] = struct{}{}
+		}
+	}
+	var publishers map[solana.PublicKey]struct{}
+	if len(filter.Publishers) > 0 {
+		publishers = make(map[solana.PublicKey]struct{}, len(filter.Publishers))
+		for _, key := range filter.Publishers {
+			publishers[key] = struct{}{}
+		}
+	}
+
+	// Updates of different price accounts may be delivered concurrently.
+	type infoKey struct{ price, publisher solana.PublicKey }
+	var infoLock sync.Mutex
+	previous := make(map[infoKey]*PriceInfo)
+	emit := func(priceKey, publisher solana.PublicKey, acc *PriceAccount, info *PriceInfo) {
+		key := infoKey{priceKey, publisher}
+		infoLock.Lock()
+		prev := previous[key]
+		changed := policy.hasChanged(prev, info)
+		next := *info
+		previous[key] = &next
+		infoLock.Unlock()
+		if !changed {
+			return
+		}
+		update := PriceUpdate{
+			PriceKey:     priceKey,
+			Publisher:    publisher,
+			Policy:       policy.Name,
+			Account:      acc,
+			PreviousInfo: prev,
+			CurrentInfo:  info,
+		}
+		if p.pooled {
+			update.Account = p.retainable(acc)
+			update.CurrentInfo = &next
+		}
+		sub.send(update)
+	}
+
+	deliver := func(priceKey solana.PublicKey, acc *PriceAccount) {
+		if priceKeys != nil {
+			if _, ok := priceKeys[priceKey]; !ok {
+				return
+			}
+		}
+		if filter.Symbol != "" {
+			symbol, ok := p.productSymbol(acc)
+			if !ok {
+				return
+			}
+			if match, _ := path.Match(filter.Symbol, symbol); !match {
+				return
+			}
+		}
+		if publishers == nil {
+			emit(priceKey, solana.PublicKey{}, acc, &acc.Agg)
+			return
+		}
+		for i := range acc.Components {
+			comp := &acc.Components[i]
+			if _, ok := publishers[comp.Publisher]; ok && !comp.Publisher.IsZero() {
+				emit(priceKey, comp.Publisher, acc, &comp.Latest)
+			}
+		}
+	}
+
+	key := atomic.AddUint64(&p.regNonce, 1)
+	sub.cancel = func() {
+		p.subsLock.Lock()
+		defer p.subsLock.Unlock()
+		delete(p.subscriptions, key)
+	}
+
+	p.subsLock.Lock()
+	defer p.subsLock.Unlock()
+	if p.subsClosed {
+		// Handler already stopped, return a closed subscription.
+		sub.cancel = nil
+		sub.Unsubscribe()
+		return sub
+	}
+	if p.subscriptions == nil {
+		p.subscriptions = make(map[uint64]subscriber)
+	}
+	p.subscriptions[key] = subscriber{deliver: deliver, close: sub.Unsubscribe}
+	return sub
+}
+
+// deliverSubscriptions passes a price account update to all channel subscriptions.
+func (p *PriceEventHandler) deliverSubscriptions(priceKey solana.PublicKey, acc *PriceAccount) {
+	p.subsLock.RLock()
+	if len(p.subscriptions) == 0 {
+		p.subsLock.RUnlock()
+		return
+	}
+	subs := make([]subscriber, 0, len(p.subscriptions))
+	for _, sub := range p.subscriptions {
+		subs = append(subs, sub)
+	}
+	p.subsLock.RUnlock()
+
+	for _, sub := range subs {
+		sub.deliver(priceKey, acc)
+	}
+}
+
+// closeSubscriptions closes all channel subscriptions once the workers have exited.
+func (p *PriceEventHandler) closeSubscriptions() {
+	p.subsLock.Lock()
+	p.subsClosed = true
+	subs := make([]subscriber, 0, len(p.subscriptions))
+	for _, sub := range p.subscriptions {
+		subs = append(subs, sub)
+	}
+	p.subsLock.Unlock()
+
+	for _, sub := range subs {
+		sub.close()
+	}
+}
@@ -0,0 +1,137 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"testing"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func collect(sub *Subscription[PriceUpdate]) (updates []PriceUpdate) {
+	for update := range sub.C() {
+		updates = append(updates, update)
+	}
+	return
+}
+
+func TestPriceEventHandler_SubscribeAll(t *testing.T) {
+	src := newTestSource()
+	handler := NewPriceEventHandler(src)
+	sub := handler.Subscribe(&SubscribeOpts{Buffer: 16})
+
+	otherKey := solana.PublicKey{1, 2, 3}
+	src.send(testPriceKey, 1, 1)
+	src.send(otherKey, 1, 1)
+	src.send(testPriceKey, 1, 1) // unchanged
+	src.send(testPriceKey, 2, 2)
+	src.Close()
+	require.NoError(t, handler.Wait())
+
+	updates := collect(sub)
+	require.Len(t, updates, 3)
+	assert.Equal(t, testPriceKey, updates[0].PriceKey)
+	assert.Nil(t, updates[0].PreviousInfo)
+	assert.Equal(t, otherKey, updates[1].PriceKey)
+	assert.Equal(t, testPriceKey, updates[2].PriceKey)
+	assert.Equal(t, int64(2), updates[2].CurrentInfo.Price)
+	assert.Equal(t, uint64(1), updates[2].PreviousInfo.PubSlot)
+	assert.True(t, updates[2].Publisher.IsZero())
+}
+
+func TestPriceEventHandler_SubscribeSymbol(t *testing.T) {
+	src := newTestSource()
+	handler := NewPriceEventHandler(src)
+
+	attrs, err := NewAttrsMap(map[string]string{"symbol": "Crypto.BTC/USD"})
+	require.NoError(t, err)
+	productKey := priceAccount_E36MyBbavhYKHVLWR79GiReNNnBDiHj6nWA7htbkNZbh.Product
+	handler.SetProducts([]ProductAccountEntry{{
+		ProductAccount: &ProductAccount{Attrs: attrs},
+		Pubkey:         productKey,
+	}})
+
+	crypto := handler.Subscribe(&SubscribeOpts{Buffer: 16, Filter: SubscribeFilter{Symbol: "Crypto.*/USD"}})
+	fx := handler.Subscribe(&SubscribeOpts{Buffer: 16, Filter: SubscribeFilter{Symbol: "FX.*"}})
+
+	src.send(testPriceKey, 1, 1)
+	src.Close()
+	require.NoError(t, handler.Wait())
+
+	assert.Len(t, collect(crypto), 1)
+	assert.Empty(t, collect(fx))
+}
+
+func TestPriceEventHandler_SubscribePublisher(t *testing.T) {
+	src := newTestSource()
+	handler := NewPriceEventHandler(src)
+
+	publisher := priceAccount_E36MyBbavhYKHVLWR79GiReNNnBDiHj6nWA7htbkNZbh.Components[0].Publisher
+	sub := handler.Subscribe(&SubscribeOpts{Buffer: 16, Filter: SubscribeFilter{
+		PriceKeys:  []solana.PublicKey{testPriceKey},
+		Publishers: []solana.PublicKey{publisher},
+	}})
+
+	src.send(solana.PublicKey{1, 2, 3}, 1, 1) // filtered by price key
+	src.send(testPriceKey, 1, 1)
+	src.send(testPriceKey, 2, 2) // component did not change
+	src.Close()
+	require.NoError(t, handler.Wait())
+
+	updates := collect(sub)
+	require.Len(t, updates, 1)
+	assert.Equal(t, publisher, updates[0].Publisher)
+	assert.Same(t, &updates[0].Account.Components[0].Latest, updates[0].CurrentInfo)
+}
+
+func TestPriceEventHandler_SubscribeDropWhenFull(t *testing.T) {
+	src := newTestSource()
+	handler := NewPriceEventHandler(src)
+	sub := handler.Subscribe(&SubscribeOpts{Buffer: 1, DropWhenFull: true})
+
+	for slot := uint64(1); slot <= 3; slot++ {
+		src.send(testPriceKey, 1, slot)
+	}
+	src.Close()
+	require.NoError(t, handler.Wait())
+
+	assert.Len(t, collect(sub), 1)
+	assert.Equal(t, uint64(2), sub.Dropped())
+}
+
+func TestPriceEventHandler_UnsubscribeBlocked(t *testing.T) {
+	src := newTestSource()
+	handler := NewPriceEventHandler(src)
+	sub := handler.Subscribe(nil)
+
+	src.send(testPriceKey, 1, 1)
+	<-sub.C()
+	// Worker blocks on the unbuffered channel until unsubscribed.
+	src.send(testPriceKey, 2, 2)
+	sub.Unsubscribe()
+	sub.Unsubscribe()
+
+	src.send(testPriceKey, 3, 3)
+	src.Close()
+	require.NoError(t, handler.Wait())
+	_, ok := <-sub.C()
+	assert.False(t, ok)
+
+	// Subscribing after the handler stopped returns a closed subscription.
+	_, ok = <-handler.Subscribe(nil).C()
+	assert.False(t, ok)
+}
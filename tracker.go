@@ -0,0 +1,220 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// LandingTrackerOpts configures a LandingTracker.
+type LandingTrackerOpts struct {
+	// Commitment is used to fetch price accounts. Defaults to confirmed.
+	Commitment rpc.CommitmentType
+	// OnResult is called with the classified outcome of each transaction, if set.
+	OnResult func(TxResult)
+	// OnError is called if a landed transaction could not be cross-checked, if set.
+	// The transaction is then counted as landed.
+	OnError func(sig solana.Signature, err error)
+}
+
+// LandingStats counts the outcomes of transactions.
+type LandingStats struct {
+	Landed         uint64
+	Expired        uint64
+	ProgramError   uint64
+	NotInComponent uint64
+	Dropped        uint64 // outcomes not classified because the tracker fell behind
+}
+
+// LandingRatio returns the share of transactions that landed, between 0 and 1.
+// Dropped outcomes are not included.
+//
+// Returns zero if no transaction has been resolved yet.
+func (s LandingStats) LandingRatio() float64 {
+	total := s.Landed + s.Expired + s.ProgramError + s.NotInComponent
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Landed) / float64(total)
+}
+
+func (s *LandingStats) add(outcome TxOutcome) {
+	switch outcome {
+	case TxLanded:
+		s.Landed++
+	case TxExpired:
+		s.Expired++
+	case TxProgramError:
+		s.ProgramError++
+	case TxNotInComponent:
+		s.NotInComponent++
+	}
+}
+
+// LandingTracker classifies the outcomes of the transactions of a Publisher.
+//
+// Transactions confirmed without error are cross-checked against the price accounts:
+// unless the publisher's component (PriceComp.Latest.PubSlot) reached the publish slot of the update,
+// the outcome is TxNotInComponent. This happens when an update is ignored by the on-chain program,
+// for example with UpdPriceNoFailOnError or when the publisher is not a component of the price.
+//
+// Outcomes are exported as Prometheus metrics, along with the confirmation latency and the landing ratio.
+// If classifying falls behind by more than a batch of outcomes, further outcomes are dropped
+// rather than stalling the publisher.
+type LandingTracker struct {
+	publisher *Publisher
+	opts      LandingTrackerOpts
+
+	queueLock sync.Mutex // lock over queue and closed
+	queue     chan TxResult
+	closed    bool
+	stopHook  func()
+	done      chan struct{}
+
+	statsLock sync.Mutex // lock over stats
+	stats     LandingStats
+
+	landingRatio prometheus.Gauge
+}
+
+// TrackLandings starts classifying the outcomes of transactions sent by the publisher.
+//
+// Transactions sent by other means can be added with Publisher.Track.
+// The opts may be nil.
+//
+// Call Close to stop the tracker.
+func (p *Publisher) TrackLandings(opts *LandingTrackerOpts) *LandingTracker {
+	if opts == nil {
+		opts = new(LandingTrackerOpts)
+	}
+	t := &LandingTracker{
+		publisher: p,
+		opts:      *opts,
+		queue:     make(chan TxResult, maxSignatureStatuses),
+		done:      make(chan struct{}),
+		landingRatio: metricsPublisherLandingRatio.WithLabelValues(
+			p.client.Env.Program.String(), p.signer.PublicKey().String()),
+	}
+	if t.opts.Commitment == "" {
+		t.opts.Commitment = rpc.CommitmentConfirmed
+	}
+	t.stopHook = p.onResult(t.enqueue)
+	go func() {
+		defer close(t.done)
+		t.run()
+	}()
+	return t
+}
+
+// Close stops tracking, after classifying the outcomes already reported.
+func (t *LandingTracker) Close() {
+	t.stopHook()
+	t.queueLock.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.queueLock.Unlock()
+	<-t.done
+}
+
+// enqueue adds an outcome for classification without blocking the publisher's status loop.
+func (t *LandingTracker) enqueue(result TxResult) {
+	t.queueLock.Lock()
+	defer t.queueLock.Unlock()
+	if t.closed {
+		return
+	}
+	select {
+	case t.queue <- result:
+	default:
+		metricsPublisherOutcomesDroppedTotal.Inc()
+		t.statsLock.Lock()
+		t.stats.Dropped++
+		t.statsLock.Unlock()
+	}
+}
+
+// Stats returns the outcomes counted so far.
+func (t *LandingTracker) Stats() LandingStats {
+	t.statsLock.Lock()
+	defer t.statsLock.Unlock()
+	return t.stats
+}
+
+func (t *LandingTracker) run() {
+	for result := range t.queue {
+		if result.Outcome == TxLanded {
+			if err := t.verify(context.Background(), &result); err != nil && t.opts.OnError != nil {
+				t.opts.OnError(result.Signature, err)
+			}
+		}
+		t.record(result)
+		if t.opts.OnResult != nil {
+			t.opts.OnResult(result)
+		}
+	}
+}
+
+// verify reclassifies a landed transaction as TxNotInComponent
+// if the publisher's component of any of its price accounts is behind the publish slot.
+func (t *LandingTracker) verify(ctx context.Context, result *TxResult) error {
+	if len(result.PriceKeys) == 0 {
+		return nil
+	}
+	res, err := t.publisher.client.RPC.GetMultipleAccountsWithOpts(ctx, result.PriceKeys, &rpc.GetMultipleAccountsOpts{
+		Commitment: t.opts.Commitment,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get price accounts: %w", err)
+	}
+	if len(res.Value) != len(result.PriceKeys) {
+		return fmt.Errorf("unexpected number of price accounts, asked for %d but got %d", len(result.PriceKeys), len(res.Value))
+	}
+	publisherKey := t.publisher.PublicKey()
+	for i, info := range res.Value {
+		if info == nil {
+			return fmt.Errorf("price account %s not found", result.PriceKeys[i])
+		}
+		var acc PriceAccount
+		if err := acc.UnmarshalBinary(info.Data.GetBinary()); err != nil {
+			return fmt.Errorf("failed to decode price account %s: %w", result.PriceKeys[i], err)
+		}
+		comp := acc.GetComponent(&publisherKey)
+		if comp == nil || comp.Latest.PubSlot < result.PubSlot {
+			result.Outcome = TxNotInComponent
+			return nil
+		}
+	}
+	return nil
+}
+
+func (t *LandingTracker) record(result TxResult) {
+	metricsPublisherOutcomesTotal.WithLabelValues(result.Outcome.String()).Inc()
+	if result.Outcome == TxLanded {
+		metricsPublisherConfirmationLatency.Observe(result.Latency.Seconds())
+	}
+	t.statsLock.Lock()
+	t.stats.add(result.Outcome)
+	ratio := t.stats.LandingRatio()
+	t.statsLock.Unlock()
+	t.landingRatio.Set(ratio)
+}
@@ -0,0 +1,163 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLandingStats_LandingRatio(t *testing.T) {
+	assert.Equal(t, 0.0, LandingStats{}.LandingRatio())
+	assert.Equal(t, 0.5, LandingStats{Landed: 2, Expired: 1, NotInComponent: 1}.LandingRatio())
+}
+
+func TestLandingTracker(t *testing.T) {
+	signer := solana.NewWallet().PrivateKey
+	updatedKey, ignoredKey := solana.PublicKey{1}, solana.PublicKey{2}
+
+	// Price account data, with the publisher's component at slot 100 in updatedKey only.
+	accounts := make(map[string]string)
+	for _, key := range []solana.PublicKey{updatedKey, ignoredKey} {
+		var acc PriceAccount
+		require.NoError(t, acc.UnmarshalBinary(casePriceAccount))
+		if key == updatedKey {
+			acc.Components[0].Publisher = signer.PublicKey()
+			acc.Components[0].Latest.PubSlot = 100
+		}
+		data, err := acc.MarshalBinary()
+		require.NoError(t, err)
+		accounts[key.String()] = base64.StdEncoding.EncodeToString(data)
+	}
+
+	landed, ignored, expired := solana.Signature{1}, solana.Signature{2}, solana.Signature{3}
+	rpcServer := newFakeRPC(t)
+	rpcServer.handleBlockhash()
+	rpcServer.handle("getSignatureStatuses", func(params json.RawMessage) interface{} {
+		var args []json.RawMessage
+		require.NoError(t, json.Unmarshal(params, &args))
+		var sigs []solana.Signature
+		require.NoError(t, json.Unmarshal(args[0], &sigs))
+		values := make([]interface{}, len(sigs))
+		for i, sig := range sigs {
+			if sig != expired {
+				values[i] = map[string]interface{}{"slot": 101, "err": nil, "confirmationStatus": "confirmed"}
+			}
+		}
+		return map[string]interface{}{
+			"context": map[string]interface{}{"slot": 101},
+			"value":   values,
+		}
+	})
+	rpcServer.handle("getMultipleAccounts", func(params json.RawMessage) interface{} {
+		var args []json.RawMessage
+		require.NoError(t, json.Unmarshal(params, &args))
+		var keys []string
+		require.NoError(t, json.Unmarshal(args[0], &keys))
+		values := make([]interface{}, len(keys))
+		for i, key := range keys {
+			values[i] = map[string]interface{}{
+				"data":       []string{accounts[key], "base64"},
+				"executable": false,
+				"lamports":   1,
+				"owner":      Devnet.Program.String(),
+				"rentEpoch":  0,
+			}
+		}
+		return map[string]interface{}{
+			"context": map[string]interface{}{"slot": 101},
+			"value":   values,
+		}
+	})
+
+	client := NewClient(Devnet, rpcServer.URL, rpcServer.URL)
+	publisher := client.NewPublisher(context.Background(), NewKeypairSigner(signer), nil, &PublisherOpts{
+		StatusInterval: time.Hour,
+		Expiry:         time.Minute,
+	})
+	defer publisher.Close()
+
+	results := make(map[solana.Signature]TxOutcome)
+	tracker := publisher.TrackLandings(&LandingTrackerOpts{
+		OnResult: func(result TxResult) {
+			results[result.Signature] = result.Outcome
+		},
+		OnError: func(sig solana.Signature, err error) {
+			t.Errorf("failed to verify %s: %s", sig, err)
+		},
+	})
+	defer tracker.Close()
+
+	publisher.Track(landed, []solana.PublicKey{updatedKey}, 100)
+	publisher.Track(ignored, []solana.PublicKey{ignoredKey}, 100)
+	publisher.Track(expired, []solana.PublicKey{updatedKey}, 100)
+	publisher.pendingLock.Lock()
+	publisher.pending[expired].sentAt = time.Now().Add(-2 * time.Minute)
+	publisher.pendingLock.Unlock()
+
+	require.NoError(t, publisher.checkPending(context.Background()))
+	tracker.Close()
+
+	assert.Equal(t, map[solana.Signature]TxOutcome{
+		landed:  TxLanded,
+		ignored: TxNotInComponent,
+		expired: TxExpired,
+	}, results)
+	assert.Equal(t, LandingStats{Landed: 1, Expired: 1, NotInComponent: 1}, tracker.Stats())
+
+	// The landing ratio is reported per publisher.
+	assert.Equal(t, tracker.Stats().LandingRatio(), testutil.ToFloat64(
+		metricsPublisherLandingRatio.WithLabelValues(Devnet.Program.String(), signer.PublicKey().String())))
+	assert.Zero(t, testutil.ToFloat64(
+		metricsPublisherLandingRatio.WithLabelValues(Devnet.Program.String(), solana.NewWallet().PublicKey().String())))
+}
+
+func TestLandingTracker_Full(t *testing.T) {
+	rpcServer := newFakeRPC(t)
+	rpcServer.handleBlockhash()
+	client := NewClient(Devnet, rpcServer.URL, rpcServer.URL)
+	publisher := client.NewPublisher(context.Background(), NewKeypairSigner(solana.NewWallet().PrivateKey), nil, nil)
+	defer publisher.Close()
+
+	entered, release := make(chan struct{}, 1), make(chan struct{})
+	tracker := publisher.TrackLandings(&LandingTrackerOpts{
+		OnResult: func(TxResult) {
+			select {
+			case entered <- struct{}{}:
+				<-release
+			default:
+			}
+		},
+	})
+	defer tracker.Close()
+
+	// A stalled tracker must not block the publisher.
+	tracker.enqueue(TxResult{Outcome: TxExpired})
+	<-entered
+	for i := 0; i < maxSignatureStatuses+1; i++ {
+		tracker.enqueue(TxResult{Outcome: TxExpired})
+	}
+	close(release)
+	tracker.Close()
+	assert.Equal(t, LandingStats{Expired: maxSignatureStatuses + 1, Dropped: 1}, tracker.Stats())
+}
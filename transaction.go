@@ -0,0 +1,175 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"fmt"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// DecodedInstruction is a Pyth instruction found in a transaction.
+type DecodedInstruction struct {
+	*Instruction
+
+	Index      int                // index of the top-level instruction
+	InnerIndex int                // index within the inner instructions of Index, -1 if top-level
+	Signers    []solana.PublicKey // signers of the transaction
+
+	// Err is set if the instruction could not be decoded.
+	// Header and Payload are zero then, and Accounts is empty if an account index is invalid.
+	Err error
+}
+
+// DecodeTransaction returns all instructions of the given Pyth program in a transaction.
+//
+// The metadata is optional. If set, inner instructions invoked by other programs are included,
+// and accounts of versioned transactions are resolved from the loaded addresses.
+// Without metadata, versioned transactions using address lookup tables
+// must have the tables set with Message.SetAddressTables.
+//
+// An instruction of the program that cannot be decoded does not fail the call,
+// it is returned with DecodedInstruction.Err set instead.
+func DecodeTransaction(program solana.PublicKey, tx *solana.Transaction, meta *rpc.TransactionMeta) ([]DecodedInstruction, error) {
+	accounts, err := transactionAccounts(&tx.Message, meta)
+	if err != nil {
+		return nil, err
+	}
+	signers := tx.Message.Signers()
+
+	var decoded []DecodedInstruction
+	decode := func(index, innerIndex int, compiled *solana.CompiledInstruction) error {
+		if int(compiled.ProgramIDIndex) >= len(accounts) {
+			return fmt.Errorf("program index %d out of range", compiled.ProgramIDIndex)
+		}
+		if accounts[compiled.ProgramIDIndex].PublicKey != program {
+			return nil
+		}
+		entry := DecodedInstruction{
+			Index:      index,
+			InnerIndex: innerIndex,
+			Signers:    signers,
+		}
+		metas, err := instructionAccounts(accounts, compiled)
+		if err == nil {
+			entry.Instruction, err = DecodeInstruction(program, metas, compiled.Data)
+		}
+		if err != nil {
+			entry.Instruction = &Instruction{programKey: program, accounts: metas}
+			entry.Err = err
+		}
+		decoded = append(decoded, entry)
+		return nil
+	}
+
+	inner := make(map[int][]solana.CompiledInstruction)
+	if meta != nil {
+		for _, set := range meta.InnerInstructions {
+			inner[int(set.Index)] = append(inner[int(set.Index)], set.Instructions...)
+		}
+	}
+	for i := range tx.Message.Instructions {
+		if err := decode(i, -1, &tx.Message.Instructions[i]); err != nil {
+			return nil, fmt.Errorf("instruction %d: %w", i, err)
+		}
+		for j := range inner[i] {
+			if err := decode(i, j, &inner[i][j]); err != nil {
+				return nil, fmt.Errorf("instruction %d, inner instruction %d: %w", i, j, err)
+			}
+		}
+	}
+	return decoded, nil
+}
+
+// DecodeTransactionResult returns all instructions of the given Pyth program
+// in a transaction fetched with getTransaction, including inner instructions.
+//
+// The transaction must be fetched in binary encoding, or in JSON encoding (not jsonParsed).
+func DecodeTransactionResult(program solana.PublicKey, res *rpc.GetTransactionResult) ([]DecodedInstruction, error) {
+	if res.Transaction == nil {
+		return nil, fmt.Errorf("transaction not found")
+	}
+	tx, err := res.Transaction.GetTransaction()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode transaction: %w", err)
+	}
+	if tx == nil {
+		return nil, fmt.Errorf("transaction not found")
+	}
+	return DecodeTransaction(program, tx, res.Meta)
+}
+
+// instructionAccounts resolves the account indexes of a compiled instruction.
+func instructionAccounts(accounts []*solana.AccountMeta, compiled *solana.CompiledInstruction) ([]*solana.AccountMeta, error) {
+	metas := make([]*solana.AccountMeta, len(compiled.Accounts))
+	for i, idx := range compiled.Accounts {
+		if int(idx) >= len(accounts) {
+			return nil, fmt.Errorf("account index %d out of range", idx)
+		}
+		metas[i] = accounts[idx]
+	}
+	return metas, nil
+}
+
+// transactionAccounts returns the accounts referenced by the indexes of a message,
+// in the order used by the runtime: static keys, then writable and read-only keys of lookup tables.
+func transactionAccounts(msg *solana.Message, meta *rpc.TransactionMeta) ([]*solana.AccountMeta, error) {
+	keys := msg.AccountKeys
+	numStatic := len(keys)
+	numWritableLookups := 0
+	if msg.IsVersioned() && msg.NumLookups() > 0 {
+		switch {
+		case msg.GetAddressTables() != nil:
+			var err error
+			if keys, err = msg.GetAllKeys(); err != nil {
+				return nil, fmt.Errorf("failed to resolve address lookup tables: %w", err)
+			}
+			numStatic = len(keys) - msg.NumLookups()
+			numWritableLookups = msg.NumWritableLookups()
+		case meta != nil:
+			loaded := meta.LoadedAddresses
+			if len(loaded.Writable)+len(loaded.ReadOnly) != msg.NumLookups() {
+				return nil, fmt.Errorf("transaction has %d address table lookups, but %d loaded addresses",
+					msg.NumLookups(), len(loaded.Writable)+len(loaded.ReadOnly))
+			}
+			keys = make([]solana.PublicKey, 0, numStatic+msg.NumLookups())
+			keys = append(keys, msg.AccountKeys...)
+			keys = append(keys, loaded.Writable...)
+			keys = append(keys, loaded.ReadOnly...)
+			numWritableLookups = len(loaded.Writable)
+		default:
+			return nil, fmt.Errorf("address lookup tables not resolved")
+		}
+	}
+
+	header := msg.Header
+	numSigners := int(header.NumRequiredSignatures)
+	accounts := make([]*solana.AccountMeta, len(keys))
+	for i, key := range keys {
+		var signer, writable bool
+		switch {
+		case i < numSigners:
+			signer = true
+			writable = i < numSigners-int(header.NumReadonlySignedAccounts)
+		case i < numStatic:
+			writable = i < numStatic-int(header.NumReadonlyUnsignedAccounts)
+		default:
+			writable = i < numStatic+numWritableLookups
+		}
+		accounts[i] = solana.NewAccountMeta(key, writable, signer)
+	}
+	return accounts, nil
+}
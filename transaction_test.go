@@ -0,0 +1,182 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/programs/system"
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeTransaction_Inner(t *testing.T) {
+	funding := solana.NewWallet().PublicKey()
+	price := solana.NewWallet().PublicKey()
+	builder := NewInstructionBuilder(Devnet.Program)
+
+	tx, err := solana.NewTransaction([]solana.Instruction{
+		system.NewTransferInstruction(1, funding, price).Build(),
+		builder.SetMinPub(funding, price, CommandSetMinPub{MinPub: 2}),
+	}, testBlockhash, solana.TransactionPayer(funding))
+	require.NoError(t, err)
+
+	// The transfer is assumed to invoke the Pyth program.
+	indexOf := func(key solana.PublicKey) uint16 {
+		for i, k := range tx.Message.AccountKeys {
+			if k == key {
+				return uint16(i)
+			}
+		}
+		require.FailNow(t, "account not found", key.String())
+		return 0
+	}
+	addPublisher := builder.AddPublisher(funding, price, CommandAddPublisher{Publisher: solana.PublicKey{1}})
+	data, err := addPublisher.Data()
+	require.NoError(t, err)
+	meta := &rpc.TransactionMeta{InnerInstructions: []rpc.InnerInstruction{{
+		Index: 0,
+		Instructions: []solana.CompiledInstruction{{
+			ProgramIDIndex: indexOf(Devnet.Program),
+			Accounts:       []uint16{indexOf(funding), indexOf(price)},
+			Data:           data,
+		}},
+	}}}
+
+	decoded, err := DecodeTransaction(Devnet.Program, tx, meta)
+	require.NoError(t, err)
+	require.Len(t, decoded, 2)
+
+	assert.Equal(t, 0, decoded[0].Index)
+	assert.Equal(t, 0, decoded[0].InnerIndex)
+	assert.Equal(t, Instruction_AddPublisher, decoded[0].Header.Cmd)
+	assert.Equal(t, &CommandAddPublisher{Publisher: solana.PublicKey{1}}, decoded[0].Payload)
+	assert.Equal(t, addPublisher.Accounts(), decoded[0].Accounts())
+
+	assert.Equal(t, 1, decoded[1].Index)
+	assert.Equal(t, -1, decoded[1].InnerIndex)
+	assert.Equal(t, Instruction_SetMinPub, decoded[1].Header.Cmd)
+	assert.Equal(t, []solana.PublicKey{funding, price}, decoded[1].Signers)
+
+	// Without metadata, only top-level instructions are decoded.
+	decoded, err = DecodeTransaction(Devnet.Program, tx, nil)
+	require.NoError(t, err)
+	require.Len(t, decoded, 1)
+	assert.Equal(t, Instruction_SetMinPub, decoded[0].Header.Cmd)
+
+	// Instructions of other programs are skipped.
+	decoded, err = DecodeTransaction(Mainnet.Program, tx, meta)
+	require.NoError(t, err)
+	assert.Empty(t, decoded)
+}
+
+func TestDecodeTransaction_Versioned(t *testing.T) {
+	publisher := solana.NewWallet().PublicKey()
+	price := solana.NewWallet().PublicKey()
+	table := solana.NewWallet().PublicKey()
+	builder := NewInstructionBuilder(Devnet.Program)
+
+	payload := CommandUpdPrice{Status: PriceStatusTrading, Price: 42, Conf: 1, PubSlot: 100}
+	updPrice := builder.UpdPrice(publisher, price, payload)
+	data, err := updPrice.Data()
+	require.NoError(t, err)
+	// The price and clock accounts are loaded from index 5 and 2 of the lookup table.
+	msg := solana.Message{
+		AccountKeys: []solana.PublicKey{publisher, Devnet.Program},
+		Header: solana.MessageHeader{
+			NumRequiredSignatures:       1,
+			NumReadonlyUnsignedAccounts: 1,
+		},
+		RecentBlockhash: testBlockhash,
+		Instructions: []solana.CompiledInstruction{{
+			ProgramIDIndex: 1,
+			Accounts:       []uint16{0, 2, 3},
+			Data:           data,
+		}},
+	}
+	msg.SetVersion(solana.MessageVersionV0)
+	msg.AddAddressTableLookup(solana.MessageAddressTableLookup{
+		AccountKey:      table,
+		WritableIndexes: []uint8{5},
+		ReadonlyIndexes: []uint8{2},
+	})
+	tx := &solana.Transaction{Signatures: make([]solana.Signature, 1), Message: msg}
+	txData, err := tx.MarshalBinary()
+	require.NoError(t, err)
+
+	var res rpc.GetTransactionResult
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"slot": 100,
+		"transaction": ["`+base64.StdEncoding.EncodeToString(txData)+`", "base64"],
+		"meta": {"loadedAddresses": {"writable": ["`+price.String()+`"], "readonly": ["`+solana.SysVarClockPubkey.String()+`"]}}
+	}`), &res))
+	decoded, err := DecodeTransactionResult(Devnet.Program, &res)
+	require.NoError(t, err)
+	require.Len(t, decoded, 1)
+	assert.Equal(t, Instruction_UpdPrice, decoded[0].Header.Cmd)
+	assert.Equal(t, &payload, decoded[0].Payload)
+	assert.Equal(t, updPrice.Accounts(), decoded[0].Accounts())
+	assert.Equal(t, []solana.PublicKey{publisher}, decoded[0].Signers)
+
+	// Without metadata, the lookup tables must be provided.
+	_, err = DecodeTransaction(Devnet.Program, tx, nil)
+	assert.EqualError(t, err, "address lookup tables not resolved")
+
+	tableKeys := make(solana.PublicKeySlice, 6)
+	tableKeys[2] = solana.SysVarClockPubkey
+	tableKeys[5] = price
+	require.NoError(t, tx.Message.SetAddressTables(map[solana.PublicKey]solana.PublicKeySlice{table: tableKeys}))
+	decoded, err = DecodeTransaction(Devnet.Program, tx, nil)
+	require.NoError(t, err)
+	require.Len(t, decoded, 1)
+	assert.Equal(t, updPrice.Accounts(), decoded[0].Accounts())
+}
+
+func TestDecodeTransaction_Undecodable(t *testing.T) {
+	funding := solana.NewWallet().PublicKey()
+	price := solana.NewWallet().PublicKey()
+	builder := NewInstructionBuilder(Devnet.Program)
+
+	invalid := solana.NewInstruction(Devnet.Program, solana.AccountMetaSlice{
+		solana.Meta(funding).SIGNER().WRITE(),
+	}, []byte{0xff, 0xff})
+	tx, err := solana.NewTransaction([]solana.Instruction{
+		builder.SetMinPub(funding, price, CommandSetMinPub{MinPub: 2}),
+		invalid,
+		builder.AddPublisher(funding, price, CommandAddPublisher{Publisher: solana.PublicKey{1}}),
+	}, testBlockhash, solana.TransactionPayer(funding))
+	require.NoError(t, err)
+
+	decoded, err := DecodeTransaction(Devnet.Program, tx, nil)
+	require.NoError(t, err)
+	require.Len(t, decoded, 3)
+
+	assert.NoError(t, decoded[0].Err)
+	assert.Equal(t, Instruction_SetMinPub, decoded[0].Header.Cmd)
+
+	assert.Equal(t, 1, decoded[1].Index)
+	assert.ErrorContains(t, decoded[1].Err, "failed to decode header")
+	assert.Equal(t, Devnet.Program, decoded[1].ProgramID())
+	assert.Equal(t, invalid.Accounts(), decoded[1].Accounts())
+	assert.Nil(t, decoded[1].Payload)
+
+	assert.NoError(t, decoded[2].Err)
+	assert.Equal(t, Instruction_AddPublisher, decoded[2].Header.Cmd)
+	assert.Equal(t, &CommandAddPublisher{Publisher: solana.PublicKey{1}}, decoded[2].Payload)
+}
@@ -0,0 +1,227 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"math"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+// Trigger describes a condition on the aggregate price of a price account.
+//
+// Level conditions (such as ConfRatioAbove) fire once when the measured value crosses the threshold,
+// and fire again with TriggerEvent.Active set to false once the value has fallen back
+// below the threshold minus Hysteresis.
+// Edge conditions (such as PriceMoveBps) fire on each occurrence.
+type Trigger struct {
+	Condition TriggerCondition
+	// Hysteresis is how far the value of a level condition must fall below its threshold to re-arm.
+	// Uses the same unit as the threshold of the condition.
+	Hysteresis float64
+	// Cooldown is the minimum time between two activations.
+	// Activations within the cooldown are suppressed.
+	Cooldown time.Duration
+}
+
+// TriggerEvent is passed to trigger callbacks.
+type TriggerEvent struct {
+	PriceKey solana.PublicKey
+	Account  *PriceAccount // a copy if the stream uses StreamOpts.PoolAccounts
+	Active   bool          // false if a level condition has cleared
+	Value    float64       // measured value, in the unit of the condition
+	Time     time.Time
+}
+
+// TriggerCondition is evaluated on each aggregate price update.
+//
+// Use one of the provided constructors such as PriceMoveBps.
+type TriggerCondition interface {
+	// measure returns the value of the condition and whether it is exceeded.
+	measure(state *triggerState, acc *PriceAccount) (value float64, exceeded bool, ok bool)
+	// level returns the threshold if this is a level condition.
+	level() (threshold float64, isLevel bool)
+}
+
+// PriceMoveBps fires when the aggregate price has moved by at least bps basis points
+// since the last time the trigger fired (or since the first update seen).
+func PriceMoveBps(bps float64) TriggerCondition {
+	return priceMoveCondition{bps: bps}
+}
+
+// ConfRatioAbove is a level condition on the ratio of confidence interval to aggregate price.
+//
+// For example, a ratio of 0.01 fires once the confidence exceeds 1% of the price.
+func ConfRatioAbove(ratio float64) TriggerCondition {
+	return confRatioCondition{ratio: ratio}
+}
+
+// StatusTransition fires when the aggregate status changes from the given status to any of the given statuses.
+//
+// For example, StatusTransition(PriceStatusTrading, PriceStatusHalted, PriceStatusAuction).
+func StatusTransition(from uint32, to ...uint32) TriggerCondition {
+	return statusCondition{from: from, to: to}
+}
+
+// TwapDivergenceBps is a level condition on the distance between
+// the aggregate price and the time-weighted average price, in basis points.
+func TwapDivergenceBps(bps float64) TriggerCondition {
+	return twapCondition{bps: bps}
+}
+
+type priceMoveCondition struct{ bps float64 }
+
+func (c priceMoveCondition) measure(state *triggerState, acc *PriceAccount) (float64, bool, bool) {
+	if acc.Agg.Status != PriceStatusTrading {
+		return 0, false, false
+	}
+	if !state.hasReference {
+		state.reference = acc.Agg.Price
+		state.hasReference = true
+		return 0, false, true
+	}
+	move := bpsDiff(acc.Agg.Price, state.reference)
+	return move, move >= c.bps, true
+}
+
+func (c priceMoveCondition) level() (float64, bool) {
+	return 0, false
+}
+
+type confRatioCondition struct{ ratio float64 }
+
+func (c confRatioCondition) measure(_ *triggerState, acc *PriceAccount) (float64, bool, bool) {
+	if acc.Agg.Status != PriceStatusTrading || acc.Agg.Price == 0 {
+		return 0, false, false
+	}
+	ratio := float64(acc.Agg.Conf) / math.Abs(float64(acc.Agg.Price))
+	return ratio, ratio > c.ratio, true
+}
+
+func (c confRatioCondition) level() (float64, bool) {
+	return c.ratio, true
+}
+
+type statusCondition struct {
+	from uint32
+	to   []uint32
+}
+
+func (c statusCondition) measure(state *triggerState, acc *PriceAccount) (float64, bool, bool) {
+	prev, hasPrev := state.status, state.hasStatus
+	state.status, state.hasStatus = acc.Agg.Status, true
+	if !hasPrev || prev != c.from {
+		return float64(acc.Agg.Status), false, true
+	}
+	for _, to := range c.to {
+		if acc.Agg.Status == to {
+			return float64(acc.Agg.Status), true, true
+		}
+	}
+	return float64(acc.Agg.Status), false, true
+}
+
+func (c statusCondition) level() (float64, bool) {
+	return 0, false
+}
+
+type twapCondition struct{ bps float64 }
+
+func (c twapCondition) measure(_ *triggerState, acc *PriceAccount) (float64, bool, bool) {
+	if acc.Agg.Status != PriceStatusTrading || acc.Twap.Val == 0 {
+		return 0, false, false
+	}
+	diff := bpsDiff(acc.Agg.Price, acc.Twap.Val)
+	return diff, diff > c.bps, true
+}
+
+func (c twapCondition) level() (float64, bool) {
+	return c.bps, true
+}
+
+// bpsDiff returns the absolute difference of a to the reference b in basis points.
+func bpsDiff(a, b int64) float64 {
+	if b == 0 {
+		return math.Inf(1)
+	}
+	return math.Abs(float64(a)-float64(b)) * 1e4 / math.Abs(float64(b))
+}
+
+// triggerState is the state machine of a single trigger registration.
+type triggerState struct {
+	trigger  Trigger
+	active   bool
+	lastFire time.Time
+
+	// condition-specific state
+	reference    int64
+	hasReference bool
+	status       uint32
+	hasStatus    bool
+}
+
+// timeNow is replaced in tests.
+var timeNow = time.Now
+
+// update advances the state machine and returns the event to deliver, if any.
+func (s *triggerState) update(priceKey solana.PublicKey, acc *PriceAccount) (TriggerEvent, bool) {
+	value, exceeded, ok := s.trigger.Condition.measure(s, acc)
+	if !ok {
+		return TriggerEvent{}, false
+	}
+	now := timeNow()
+	event := TriggerEvent{
+		PriceKey: priceKey,
+		Account:  acc,
+		Value:    value,
+		Time:     now,
+	}
+
+	threshold, isLevel := s.trigger.Condition.level()
+	if isLevel && s.active {
+		if value < threshold-s.trigger.Hysteresis {
+			s.active = false
+			return event, true
+		}
+		return TriggerEvent{}, false
+	}
+	if !exceeded {
+		return TriggerEvent{}, false
+	}
+	if s.trigger.Cooldown > 0 && !s.lastFire.IsZero() && now.Sub(s.lastFire) < s.trigger.Cooldown {
+		return TriggerEvent{}, false
+	}
+
+	s.lastFire = now
+	s.active = isLevel
+	s.reference, s.hasReference = acc.Agg.Price, true
+	event.Active = true
+	return event, true
+}
+
+// OnTrigger registers a callback function to be called
+// whenever the given trigger fires on the aggregate price of the provided price account.
+func (p *PriceEventHandler) OnTrigger(priceKey solana.PublicKey, trigger Trigger, callback func(TriggerEvent)) CallbackHandle {
+	// Callbacks of a price account are called in order from a single worker,
+	// so the state does not need to be locked.
+	state := &triggerState{trigger: trigger}
+	return p.OnPriceChange(priceKey, func(update PriceUpdate) {
+		if event, ok := state.update(priceKey, update.Account); ok {
+			event.Account = p.retainable(event.Account)
+			callback(event)
+		}
+	})
+}
@@ -0,0 +1,131 @@
+//  Copyright 2022 Blockdaemon Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pyth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func triggerAccount(price int64, conf uint64, status uint32) *PriceAccount {
+	return &PriceAccount{
+		Twap: Ema{Val: 10000},
+		Agg: PriceInfo{
+			Price:  price,
+			Conf:   conf,
+			Status: status,
+		},
+	}
+}
+
+// fakeClock replaces timeNow for the duration of a test.
+func fakeClock(t *testing.T) *time.Time {
+	now := time.Unix(1660000000, 0)
+	timeNow = func() time.Time { return now }
+	t.Cleanup(func() { timeNow = time.Now })
+	return &now
+}
+
+func TestTrigger_PriceMoveBps(t *testing.T) {
+	fakeClock(t)
+	state := &triggerState{trigger: Trigger{Condition: PriceMoveBps(50)}}
+
+	_, fired := state.update(testPriceKey, triggerAccount(10000, 1, PriceStatusTrading))
+	assert.False(t, fired, "first update sets reference")
+	_, fired = state.update(testPriceKey, triggerAccount(10040, 1, PriceStatusTrading))
+	assert.False(t, fired)
+	event, fired := state.update(testPriceKey, triggerAccount(10050, 1, PriceStatusTrading))
+	require.True(t, fired)
+	assert.True(t, event.Active)
+	assert.InDelta(t, 50, event.Value, 1e-9)
+	_, fired = state.update(testPriceKey, triggerAccount(10090, 1, PriceStatusTrading))
+	assert.False(t, fired, "reference was reset on fire")
+	_, fired = state.update(testPriceKey, triggerAccount(5000, 1, PriceStatusHalted))
+	assert.False(t, fired, "non-trading prices are ignored")
+}
+
+func TestTrigger_ConfRatioHysteresis(t *testing.T) {
+	fakeClock(t)
+	state := &triggerState{trigger: Trigger{Condition: ConfRatioAbove(0.01), Hysteresis: 0.005}}
+
+	_, fired := state.update(testPriceKey, triggerAccount(10000, 50, PriceStatusTrading))
+	assert.False(t, fired)
+	event, fired := state.update(testPriceKey, triggerAccount(10000, 150, PriceStatusTrading))
+	require.True(t, fired)
+	assert.True(t, event.Active)
+	_, fired = state.update(testPriceKey, triggerAccount(10000, 200, PriceStatusTrading))
+	assert.False(t, fired, "already active")
+	_, fired = state.update(testPriceKey, triggerAccount(10000, 90, PriceStatusTrading))
+	assert.False(t, fired, "within hysteresis")
+	event, fired = state.update(testPriceKey, triggerAccount(10000, 40, PriceStatusTrading))
+	require.True(t, fired)
+	assert.False(t, event.Active, "cleared")
+}
+
+func TestTrigger_StatusTransition(t *testing.T) {
+	fakeClock(t)
+	state := &triggerState{trigger: Trigger{Condition: StatusTransition(PriceStatusTrading, PriceStatusHalted, PriceStatusAuction)}}
+
+	_, fired := state.update(testPriceKey, triggerAccount(1, 1, PriceStatusHalted))
+	assert.False(t, fired)
+	_, fired = state.update(testPriceKey, triggerAccount(1, 1, PriceStatusTrading))
+	assert.False(t, fired)
+	event, fired := state.update(testPriceKey, triggerAccount(1, 1, PriceStatusAuction))
+	require.True(t, fired)
+	assert.Equal(t, float64(PriceStatusAuction), event.Value)
+	_, fired = state.update(testPriceKey, triggerAccount(1, 1, PriceStatusHalted))
+	assert.False(t, fired)
+}
+
+func TestTrigger_TwapDivergenceCooldown(t *testing.T) {
+	now := fakeClock(t)
+	state := &triggerState{trigger: Trigger{Condition: TwapDivergenceBps(100), Cooldown: time.Minute}}
+
+	_, fired := state.update(testPriceKey, triggerAccount(10200, 1, PriceStatusTrading))
+	require.True(t, fired)
+	_, fired = state.update(testPriceKey, triggerAccount(10000, 1, PriceStatusTrading))
+	require.True(t, fired, "cleared")
+	*now = now.Add(30 * time.Second)
+	_, fired = state.update(testPriceKey, triggerAccount(10200, 1, PriceStatusTrading))
+	assert.False(t, fired, "within cooldown")
+	*now = now.Add(time.Minute)
+	_, fired = state.update(testPriceKey, triggerAccount(10200, 1, PriceStatusTrading))
+	assert.True(t, fired)
+}
+
+func TestPriceEventHandler_OnTrigger(t *testing.T) {
+	src := newTestSource()
+	handler := NewPriceEventHandler(src)
+
+	var events []TriggerEvent
+	handler.OnTrigger(testPriceKey, Trigger{Condition: PriceMoveBps(100)}, func(event TriggerEvent) {
+		events = append(events, event)
+	})
+	// Test account has exponent -5 and trading status is required.
+	for slot, price := range []int64{100000, 100500, 101000, 101500, 102100} {
+		acc := priceAccount_E36MyBbavhYKHVLWR79GiReNNnBDiHj6nWA7htbkNZbh
+		acc.Agg = PriceInfo{Price: price, Conf: 1, Status: PriceStatusTrading, PubSlot: uint64(slot + 1)}
+		src.updates <- PriceAccountEntry{PriceAccount: &acc, Pubkey: testPriceKey, Slot: uint64(slot + 1)}
+	}
+	src.Close()
+	require.NoError(t, handler.Wait())
+
+	require.Len(t, events, 2)
+	assert.Equal(t, int64(101000), events[0].Account.Agg.Price)
+	assert.Equal(t, int64(102100), events[1].Account.Agg.Price)
+}